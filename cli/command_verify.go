@@ -0,0 +1,131 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/esm-dev/esm.sh/server/common"
+	"github.com/ije/gox/term"
+)
+
+const verifyHelpMessage = "\033[30mVerify a module built by esm.sh with its provenance manifest.\033[0m" + `
+
+Usage: esm.sh verify [options] <module>
+
+The <module> can be a build URL (e.g. https://esm.sh/react@19.0.0/es2022/react.mjs)
+or a downloaded module file.
+
+Options:
+  --manifest <file|url>   The manifest of the module (default: "<module>.manifest.json").
+  --public-key <key>      The base64 encoded ed25519 public key of the server, or a file containing it.
+`
+
+// Verify a module with its provenance manifest.
+func Verify() {
+	manifestPath := flag.String("manifest", "", "the manifest of the module")
+	publicKeyArg := flag.String("public-key", "", "the ed25519 public key of the server")
+	module, _ := parseCommandFlag()
+	if module == "" {
+		fmt.Print(verifyHelpMessage)
+		return
+	}
+	if *manifestPath == "" {
+		*manifestPath = module + ".manifest.json"
+	}
+
+	code, err := readFileOrUrl(module)
+	if err != nil {
+		os.Stderr.WriteString(term.Red(err.Error()) + "\n")
+		os.Exit(1)
+	}
+	manifestData, err := readFileOrUrl(*manifestPath)
+	if err != nil {
+		os.Stderr.WriteString(term.Red(err.Error()) + "\n")
+		os.Exit(1)
+	}
+
+	var publicKey []byte
+	if key := *publicKeyArg; key != "" {
+		if data, err := os.ReadFile(key); err == nil {
+			key = strings.TrimSpace(string(data))
+		}
+		publicKey, err = common.ParsePublicKey(key)
+		if err != nil {
+			os.Stderr.WriteString(term.Red(err.Error()) + "\n")
+			os.Exit(1)
+		}
+	}
+
+	manifest, err := common.VerifyManifest(manifestData, publicKey, code)
+	if err != nil {
+		os.Stderr.WriteString(term.Red("✗ "+err.Error()) + "\n")
+		os.Exit(1)
+	}
+	// the manifest must describe the requested module, not another build with the same output
+	verifiedFiles := 0
+	if isHttpSepcifier(module) {
+		u, err := url.Parse(module)
+		if err != nil || u.Path != manifest.Path {
+			os.Stderr.WriteString(term.Red(fmt.Sprintf("✗ the manifest is for %s, not %s", manifest.Path, module)) + "\n")
+			os.Exit(1)
+		}
+		// check the shared chunks and the css that are loaded by the module
+		for _, file := range manifest.Files {
+			data, err := readFileOrUrl(u.Scheme + "://" + u.Host + file.Path)
+			if err != nil {
+				os.Stderr.WriteString(term.Red("✗ "+err.Error()) + "\n")
+				os.Exit(1)
+			}
+			if err = common.VerifyManifestFile(file, data); err != nil {
+				os.Stderr.WriteString(term.Red("✗ "+err.Error()) + "\n")
+				os.Exit(1)
+			}
+			verifiedFiles++
+		}
+	}
+
+	fmt.Printf("path:      %s\n", manifest.Path)
+	fmt.Printf("package:   %s@%s\n", manifest.Package.Name, manifest.Package.Version)
+	if manifest.Package.Tarball != "" {
+		fmt.Printf("tarball:   %s\n", manifest.Package.Tarball)
+	}
+	if manifest.Package.Integrity != "" {
+		fmt.Printf("integrity: %s\n", manifest.Package.Integrity)
+	}
+	fmt.Printf("esbuild:   %s\n", manifest.Esbuild)
+	fmt.Printf("target:    %s\n", manifest.Target)
+	fmt.Printf("digest:    %s\n", manifest.Digest)
+	for _, file := range manifest.Files {
+		fmt.Printf("file:      %s (%s)\n", file.Path, file.Digest)
+	}
+	if publicKey != nil {
+		fmt.Println(term.Green("✓ the module matches the signed manifest"))
+	} else {
+		fmt.Println(term.Green("✓ the module matches the manifest"))
+		fmt.Println(term.Dim("the signature is not verified, use `--public-key` to check it"))
+	}
+	if verifiedFiles < len(manifest.Files) {
+		fmt.Println(term.Dim(fmt.Sprintf("%d files loaded by the module are not verified, use the build url to check them", len(manifest.Files)-verifiedFiles)))
+	}
+}
+
+// readFileOrUrl reads the content of a local file or a http(s) url.
+func readFileOrUrl(name string) ([]byte, error) {
+	if !isHttpSepcifier(name) {
+		return os.ReadFile(name)
+	}
+	res, err := http.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("failed to fetch %s: %s", name, res.Status)
+	}
+	return io.ReadAll(res.Body)
+}
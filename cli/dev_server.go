@@ -450,22 +450,48 @@ func (d *DevServer) ServeModule(w http.ResponseWriter, r *http.Request, pathname
 }
 
 func (d *DevServer) ServeCSSModule(w http.ResponseWriter, r *http.Request, pathname string, query url.Values) {
-	ret := esbuild.Build(esbuild.BuildOptions{
-		EntryPoints:      []string{filepath.Join(d.rootDir, pathname)},
+	filename := filepath.Join(d.rootDir, pathname)
+	options := esbuild.BuildOptions{
+		EntryPoints:      []string{filename},
 		Write:            false,
 		MinifyWhitespace: true,
 		MinifySyntax:     true,
 		Target:           esbuild.ES2022,
 		Bundle:           true,
-	})
+	}
+	// css modules export the class names
+	isCSSModule := strings.HasSuffix(pathname, ".module.css")
+	if isCSSModule {
+		options.EntryPoints = nil
+		options.Stdin = &esbuild.StdinOptions{
+			Contents:   fmt.Sprintf(`export { default } from %s;`, utils.MustEncodeJSON(filename)),
+			ResolveDir: d.rootDir,
+			Loader:     esbuild.LoaderJS,
+		}
+		options.Format = esbuild.FormatESModule
+		options.Outdir = "/esbuild"
+	}
+	ret := esbuild.Build(options)
 	if len(ret.Errors) > 0 {
 		fmt.Println(term.Red(ret.Errors[0].Text))
 		http.Error(w, "Internal Server Error", 500)
 		return
 	}
-	css := bytes.TrimSpace(ret.OutputFiles[0].Contents)
+	var css, js []byte
+	if isCSSModule {
+		for _, file := range ret.OutputFiles {
+			if strings.HasSuffix(file.Path, ".js") {
+				js = file.Contents
+			} else if strings.HasSuffix(file.Path, ".css") {
+				css = bytes.TrimSpace(file.Contents)
+			}
+		}
+	} else {
+		css = bytes.TrimSpace(ret.OutputFiles[0].Contents)
+	}
 	sha := xxhash.New()
 	sha.Write(css)
+	sha.Write(js)
 	etag := fmt.Sprintf("w/\"%x-%d\"", sha.Sum(nil), VERSION)
 	if r.Header.Get("If-None-Match") == etag && !query.Has("t") {
 		w.WriteHeader(http.StatusNotModified)
@@ -482,6 +508,13 @@ func (d *DevServer) ServeCSSModule(w http.ResponseWriter, r *http.Request, pathn
 	w.Write([]byte(`function applyCSS(css){if(styleEl)styleEl.textContent=css;else{styleEl=document.createElement("style");styleEl.textContent=css;document.head.appendChild(styleEl);}}`))
 	w.Write([]byte(`!(new URL(import.meta.url)).searchParams.has("t")&&applyCSS(CSS);`))
 	w.Write([]byte(`import createHotContext from"/@hmr";`))
+	if isCSSModule {
+		// the class names are generated from the filename, only the css needs to be updated
+		fmt.Fprintf(w, `createHotContext("%s").accept(m=>applyCSS(m.__CSS$));`, pathname)
+		w.Write([]byte(`export{CSS as __CSS$};`))
+		w.Write(js)
+		return
+	}
 	fmt.Fprintf(w, `createHotContext("%s").accept(m=>applyCSS(m.default));`, pathname)
 	w.Write([]byte(`export default CSS;`))
 }
@@ -93,6 +93,13 @@ func (l *Logger) Debug(category, format string, v ...interface{}) {
     }
 }
 
+// 输出警告级别日志
+func (l *Logger) Warn(category, format string, v ...interface{}) {
+    if l != nil && l.isEnabled(category) {
+        l.logger.Warnf("[%s] %s", category, fmt.Sprintf(format, v...))
+    }
+}
+
 // 输出错误级别日志
 func (l *Logger) Error(category, format string, v ...interface{}) {
     if l != nil && l.isEnabled(category) {
@@ -167,6 +174,8 @@ func DownloadDependencies(args []string) error {
     denoJsonPath = ""
     // 默认basePath为空
     basePath = ""
+    // 默认不生成 SBOM
+    sbomFormat := ""
     
     // 日志类别
     logCategories := []string{LogCatGeneral, LogCatNetwork, LogCatDependency, LogCatCompile, LogCatFS, LogCatContent}
@@ -201,6 +210,10 @@ func DownloadDependencies(args []string) error {
             }
             logger.Info(LogCatGeneral, "使用基础路径: %s", basePath)
             i++
+        } else if args[i] == "--sbom" && i+1 < len(args) {
+            sbomFormat = args[i+1]
+            logger.Info(LogCatGeneral, "生成 SBOM: %s", sbomFormat)
+            i++
         } else if args[i] == "--log-level" && i+1 < len(args) {
             // 设置日志级别
             initLogger(args[i+1], logCategories)
@@ -526,6 +539,18 @@ func DownloadDependencies(args []string) error {
         }
     }
 
+    // 9. 生成应用的 SBOM
+    if sbomFormat != "" {
+        absEntryPath, _ := filepath.Abs(entryPath)
+        appName := strings.TrimSuffix(filepath.Base(absEntryPath), filepath.Ext(absEntryPath))
+        sbomPath, err := generateAppSBOM(appName, importMapData.Imports, sbomFormat, outDir)
+        if err != nil {
+            logger.Error(LogCatGeneral, "生成 SBOM 失败: %v", err)
+            return fmt.Errorf("生成 SBOM 失败: %v", err)
+        }
+        logger.Info(LogCatGeneral, "SBOM 已保存到 %s", sbomPath)
+    }
+
     logger.Info(LogCatGeneral, "下载完成！所有文件已保存到 %s 目录", outDir)
     return nil
 }
@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/esm-dev/esm.sh/server/common"
+)
+
+// generateAppSBOM generates the SBOM of the app by merging the SBOMs of the modules in the import map,
+// the SBOM of each module is fetched with the `?sbom` query.
+func generateAppSBOM(appName string, imports map[string]string, format string, outDir string) (string, error) {
+	if format != "cyclonedx" && format != "spdx" {
+		return "", fmt.Errorf("unsupported sbom format \"%s\", available formats are \"cyclonedx\" and \"spdx\"", format)
+	}
+
+	appPurl := "pkg:generic/" + appName
+	sbom := &common.SBOM{Root: appPurl}
+	sbom.Add(&common.SBOMComponent{Name: appName, Purl: appPurl})
+
+	specifiers := make([]string, 0, len(imports))
+	for specifier := range imports {
+		specifiers = append(specifiers, specifier)
+	}
+	sort.Strings(specifiers)
+
+	apiOrigin := strings.TrimSuffix(apiBaseURL, "/")
+	fetched := map[string]bool{}
+	for _, specifier := range specifiers {
+		moduleUrl := imports[specifier]
+		if strings.HasSuffix(specifier, "/") {
+			continue
+		}
+		if strings.HasPrefix(moduleUrl, "/") {
+			moduleUrl = apiOrigin + moduleUrl
+		}
+		if !isHttpSepcifier(moduleUrl) || fetched[moduleUrl] {
+			continue
+		}
+		fetched[moduleUrl] = true
+		// only the modules of the esm.sh server provide the SBOM
+		if !strings.HasPrefix(moduleUrl, apiOrigin+"/") {
+			logger.Warn(LogCatDependency, "跳过 %s 的 SBOM: %s 不是 %s 的模块", specifier, moduleUrl, apiOrigin)
+			continue
+		}
+		sbomUrl := moduleUrl + "?sbom=cyclonedx"
+		if strings.ContainsRune(moduleUrl, '?') {
+			sbomUrl = moduleUrl + "&sbom=cyclonedx"
+		}
+		logger.Info(LogCatDependency, "获取 SBOM: %s", sbomUrl)
+		data, err := fetchContent(sbomUrl)
+		if err != nil {
+			logger.Warn(LogCatDependency, "跳过 %s 的 SBOM: 获取失败: %v", specifier, err)
+			continue
+		}
+		// the build files (e.g. `/react@19.0.0/es2022/react.mjs`) are served without the SBOM
+		moduleSBOM, err := common.ParseCycloneDX(data)
+		if err != nil {
+			logger.Warn(LogCatDependency, "跳过 %s 的 SBOM: 响应不是 CycloneDX JSON: %v", specifier, err)
+			continue
+		}
+		sbom.Merge(moduleSBOM)
+	}
+
+	var data []byte
+	var filename string
+	var err error
+	if format == "spdx" {
+		data, err = sbom.SPDX("esm.sh", fmt.Sprint(VERSION), strings.TrimSuffix(apiBaseURL, "/")+"/spdx/"+appName)
+		filename = "sbom.spdx.json"
+	} else {
+		data, err = sbom.CycloneDX("esm.sh", fmt.Sprint(VERSION))
+		filename = "sbom.cdx.json"
+	}
+	if err != nil {
+		return "", err
+	}
+	savePath := filepath.Join(outDir, filename)
+	return savePath, os.WriteFile(savePath, data, 0644)
+}
@@ -3,6 +3,7 @@ module github.com/esm-dev/esm.sh
 go 1.23.0
 
 require (
+	github.com/BurntSushi/toml v1.5.0
 	github.com/Masterminds/semver/v3 v3.3.1
 	github.com/evanw/esbuild v0.25.0
 	github.com/gorilla/websocket v1.5.3
@@ -16,6 +17,7 @@ require (
 	go.etcd.io/bbolt v1.4.0
 	golang.org/x/net v0.35.0
 	golang.org/x/term v0.29.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
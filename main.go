@@ -18,6 +18,7 @@ Commands:
   init                  Create a new nobuild web app with esm.sh CDN.
   serve                 Serve a nobuild web app with esm.sh CDN, HMR, transforming TS/Vue/Svelte on the fly.
   download              Download app and all dependencies to local directory.
+  verify <module>       Verify a module built by esm.sh with its provenance manifest.
 
 Download Options:
   --out-dir <dir>       Specify output directory (default: "dist").
@@ -25,6 +26,7 @@ Download Options:
   --api-url <url>       Use custom API base URL (default: "https://esm.sh").
   --deno-json <file>    Specify a deno.json file path to use as importmap source.
   --base-path <path>    Add a base path prefix to all generated URLs (useful when app is not served from root).
+  --sbom <format>       Generate a SBOM of the app dependencies, available formats are "cyclonedx" and "spdx".
 `
 
 //go:embed cli/internal
@@ -47,6 +49,8 @@ func main() {
 		cli.Serve(&fs)
 	case "download":
 		cli.DownloadDependencies(os.Args[2:])
+	case "verify":
+		cli.Verify()
 	default:
 		fmt.Print(helpMessage)
 	}
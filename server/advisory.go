@@ -0,0 +1,458 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/esm-dev/esm.sh/server/common"
+	"github.com/ije/esbuild-internal/xxhash"
+	"github.com/ije/gox/log"
+)
+
+// Advisory represents a security advisory of npm packages.
+type Advisory struct {
+	Id       string   `json:"id"`
+	Aliases  []string `json:"aliases,omitempty"`
+	Summary  string   `json:"summary,omitempty"`
+	Severity string   `json:"severity"`
+	Url      string   `json:"url,omitempty"`
+}
+
+// AdvisoryDB is the local security advisory database loaded from the OSV or GitHub advisory JSON files.
+type AdvisoryDB struct {
+	lock     sync.RWMutex
+	packages map[string][]advisoryEntry
+	// the fingerprint of the advisory files to detect the changes
+	checksum uint64
+}
+
+type advisoryEntry struct {
+	advisory *Advisory
+	ranges   []advisoryRange
+	versions []string
+}
+
+type advisoryRange struct {
+	introduced *semver.Version
+	// the exclusive lower bound, e.g. "> 1.0.0"
+	after        *semver.Version
+	fixed        *semver.Version
+	lastAffected *semver.Version
+}
+
+// the raw advisory in the OSV format or the GitHub REST API format
+type rawAdvisory struct {
+	Id               string          `json:"id"`
+	GhsaId           string          `json:"ghsa_id"`
+	CveId            string          `json:"cve_id"`
+	Aliases          []string        `json:"aliases"`
+	Summary          string          `json:"summary"`
+	Withdrawn        string          `json:"withdrawn"`
+	Severity         json.RawMessage `json:"severity"`
+	HtmlUrl          string          `json:"html_url"`
+	DatabaseSpecific struct {
+		Severity string `json:"severity"`
+	} `json:"database_specific"`
+	References []struct {
+		Type string `json:"type"`
+		Url  string `json:"url"`
+	} `json:"references"`
+	Affected []struct {
+		Package struct {
+			Ecosystem string `json:"ecosystem"`
+			Name      string `json:"name"`
+		} `json:"package"`
+		Ranges []struct {
+			Type   string              `json:"type"`
+			Events []map[string]string `json:"events"`
+		} `json:"ranges"`
+		Versions []string `json:"versions"`
+	} `json:"affected"`
+	Vulnerabilities []struct {
+		Package struct {
+			Ecosystem string `json:"ecosystem"`
+			Name      string `json:"name"`
+		} `json:"package"`
+		VulnerableVersionRange string `json:"vulnerable_version_range"`
+	} `json:"vulnerabilities"`
+}
+
+var advisoryDB = &AdvisoryDB{}
+
+// Load loads the advisories from the JSON file or the JSON files in the directory, a file can contain
+// an advisory object or an array of advisories.
+func (db *AdvisoryDB) Load(root string) (n int, err error) {
+	packages := map[string][]advisoryEntry{}
+	checksum, err := walkAdvisoryFiles(root, func(filename string) error {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		var list []rawAdvisory
+		if data = bytes.TrimSpace(data); len(data) > 0 && data[0] == '[' {
+			err = json.Unmarshal(data, &list)
+		} else {
+			var raw rawAdvisory
+			err = json.Unmarshal(data, &raw)
+			list = []rawAdvisory{raw}
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", filename, err)
+		}
+		for _, raw := range list {
+			n += raw.addTo(packages)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	db.lock.Lock()
+	db.packages = packages
+	db.checksum = checksum
+	db.lock.Unlock()
+	return
+}
+
+// Query returns the advisories that affect the package version.
+func (db *AdvisoryDB) Query(pkgName string, version string) []*Advisory {
+	db.lock.RLock()
+	entries := db.packages[pkgName]
+	db.lock.RUnlock()
+	if len(entries) == 0 {
+		return nil
+	}
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return nil
+	}
+	var advisories []*Advisory
+	for _, entry := range entries {
+		if entry.affects(v, version) && !slices.Contains(advisories, entry.advisory) {
+			advisories = append(advisories, entry.advisory)
+		}
+	}
+	sort.SliceStable(advisories, func(i, j int) bool {
+		return getSeverityLevel(advisories[i].Severity) > getSeverityLevel(advisories[j].Severity)
+	})
+	return advisories
+}
+
+func (entry *advisoryEntry) affects(v *semver.Version, version string) bool {
+	for _, s := range entry.versions {
+		if s == version {
+			return true
+		}
+	}
+	for _, r := range entry.ranges {
+		if r.introduced != nil && v.LessThan(r.introduced) {
+			continue
+		}
+		if r.after != nil && !v.GreaterThan(r.after) {
+			continue
+		}
+		if r.fixed != nil && !v.LessThan(r.fixed) {
+			continue
+		}
+		if r.lastAffected != nil && v.GreaterThan(r.lastAffected) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// addTo adds the npm packages affected by the advisory, returns 1 if the advisory is added.
+func (raw *rawAdvisory) addTo(packages map[string][]advisoryEntry) int {
+	if raw.Withdrawn != "" {
+		return 0
+	}
+	advisory := &Advisory{
+		Id:       raw.Id,
+		Aliases:  raw.Aliases,
+		Summary:  raw.Summary,
+		Severity: "unknown",
+		Url:      raw.HtmlUrl,
+	}
+	if advisory.Id == "" {
+		advisory.Id = raw.GhsaId
+		if raw.CveId != "" {
+			advisory.Aliases = append(advisory.Aliases, raw.CveId)
+		}
+	}
+	if advisory.Id == "" {
+		return 0
+	}
+	var severity string
+	if raw.DatabaseSpecific.Severity != "" {
+		severity = raw.DatabaseSpecific.Severity
+	} else if len(raw.Severity) > 0 && raw.Severity[0] == '"' {
+		json.Unmarshal(raw.Severity, &severity)
+	}
+	if getSeverityLevel(severity) > 0 {
+		advisory.Severity = normalizeSeverity(severity)
+	}
+	if advisory.Url == "" {
+		for _, ref := range raw.References {
+			if ref.Type == "ADVISORY" || ref.Type == "WEB" {
+				advisory.Url = ref.Url
+				break
+			}
+		}
+	}
+
+	added := 0
+	for _, affected := range raw.Affected {
+		if !strings.EqualFold(affected.Package.Ecosystem, "npm") || affected.Package.Name == "" {
+			continue
+		}
+		entry := advisoryEntry{advisory: advisory, versions: affected.Versions}
+		for _, r := range affected.Ranges {
+			if r.Type != "SEMVER" && r.Type != "ECOSYSTEM" {
+				continue
+			}
+			entry.ranges = append(entry.ranges, parseOSVEvents(r.Events)...)
+		}
+		if len(entry.ranges) > 0 || len(entry.versions) > 0 {
+			packages[affected.Package.Name] = append(packages[affected.Package.Name], entry)
+			added = 1
+		}
+	}
+	for _, vuln := range raw.Vulnerabilities {
+		if !strings.EqualFold(vuln.Package.Ecosystem, "npm") || vuln.Package.Name == "" {
+			continue
+		}
+		if r, ok := parseVulnerableVersionRange(vuln.VulnerableVersionRange); ok {
+			packages[vuln.Package.Name] = append(packages[vuln.Package.Name], advisoryEntry{advisory: advisory, ranges: []advisoryRange{r}})
+			added = 1
+		}
+	}
+	return added
+}
+
+// parseOSVEvents parses the events of an OSV range, e.g. [{"introduced":"0"},{"fixed":"4.17.21"}].
+func parseOSVEvents(events []map[string]string) (ranges []advisoryRange) {
+	var r *advisoryRange
+	for _, event := range events {
+		if v, ok := event["introduced"]; ok {
+			if r != nil {
+				ranges = append(ranges, *r)
+			}
+			r = &advisoryRange{}
+			if v != "0" {
+				r.introduced, _ = semver.NewVersion(v)
+			}
+		} else if r != nil {
+			if v, ok := event["fixed"]; ok {
+				r.fixed, _ = semver.NewVersion(v)
+			} else if v, ok := event["last_affected"]; ok {
+				r.lastAffected, _ = semver.NewVersion(v)
+			} else {
+				continue
+			}
+			ranges = append(ranges, *r)
+			r = nil
+		}
+	}
+	if r != nil {
+		ranges = append(ranges, *r)
+	}
+	return
+}
+
+// parseVulnerableVersionRange parses the `vulnerable_version_range` of the GitHub REST API, e.g. ">= 4.0.0, < 4.17.21".
+func parseVulnerableVersionRange(s string) (r advisoryRange, ok bool) {
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		op := part[:len(part)-len(strings.TrimLeft(part, "<>="))]
+		v, err := semver.NewVersion(strings.TrimSpace(part[len(op):]))
+		if err != nil {
+			return r, false
+		}
+		switch op {
+		case ">=":
+			r.introduced = v
+		case ">":
+			r.after = v
+		case "<":
+			r.fixed = v
+		case "<=":
+			r.lastAffected = v
+		case "=", "":
+			r.introduced = v
+			r.lastAffected = v
+		default:
+			return r, false
+		}
+	}
+	return r, s != ""
+}
+
+// walkAdvisoryFiles walks the JSON files of the advisory database, returns the checksum of the file set that
+// covers the paths, sizes and modification times of the files, so adding, removing or changing a file changes it.
+func walkAdvisoryFiles(root string, fn func(filename string) error) (checksum uint64, err error) {
+	h := xxhash.New()
+	err = filepath.WalkDir(root, func(filename string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(filename, ".json") {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s:%d:%d\n", filename, fi.Size(), fi.ModTime().UnixNano())
+		if fn != nil {
+			return fn(filename)
+		}
+		return nil
+	})
+	checksum = h.Sum64()
+	return
+}
+
+// startAdvisoryDBReload reloads the advisory database periodically if the advisory files are changed.
+func startAdvisoryDBReload(logger *log.Logger) {
+	tick := time.NewTicker(time.Duration(config.Advisories.ReloadInterval) * time.Second)
+	for {
+		<-tick.C
+		checksum, err := walkAdvisoryFiles(config.Advisories.Path, nil)
+		if err != nil {
+			logger.Errorf("advisories: %v", err)
+			continue
+		}
+		advisoryDB.lock.RLock()
+		changed := checksum != advisoryDB.checksum
+		advisoryDB.lock.RUnlock()
+		if changed {
+			n, err := advisoryDB.Load(config.Advisories.Path)
+			if err != nil {
+				logger.Errorf("advisories: %v", err)
+			} else {
+				logger.Infof("%d advisories reloaded from %s", n, config.Advisories.Path)
+			}
+		}
+	}
+}
+
+// isAdvisoryBlocked checks if any of the advisories reaches the `block` severity of the config.
+func isAdvisoryBlocked(advisories []*Advisory) bool {
+	if config.Advisories.Block == "" {
+		return false
+	}
+	level := getSeverityLevel(config.Advisories.Block)
+	for _, a := range advisories {
+		if level > 0 && getSeverityLevel(a.Severity) >= level {
+			return true
+		}
+	}
+	return false
+}
+
+// checkBundledAdvisories checks the bundled dependencies of the build with the advisory database, since the
+// bundled dependencies are not requested through the router. It's checked when building and serving the build,
+// as the advisories may be added after the build is cached.
+func checkBundledAdvisories(specifier string, bundled []*common.SBOMComponent) error {
+	for _, c := range bundled {
+		if advisories := advisoryDB.Query(c.Name, c.Version); isAdvisoryBlocked(advisories) {
+			return &BuildError{
+				Kind:    "advisory",
+				Message: fmt.Sprintf("forbidden: bundled dependency \"%s@%s\" has security advisories: %s", c.Name, c.Version, formatAdvisoriesHeader(advisories)),
+				Package: specifier,
+			}
+		}
+	}
+	return nil
+}
+
+// formatAdvisoriesHeader formats the advisories for the `X-ESM-Advisories` header,
+// e.g. "GHSA-35jh-r3h4-6jhm; severity=high, GHSA-p6mc-m468-83gw; severity=high".
+func formatAdvisoriesHeader(advisories []*Advisory) string {
+	values := make([]string, len(advisories))
+	for i, a := range advisories {
+		values[i] = a.Id + "; severity=" + a.Severity
+	}
+	return strings.Join(values, ", ")
+}
+
+func normalizeSeverity(severity string) string {
+	severity = strings.ToLower(severity)
+	if severity == "medium" {
+		return "moderate"
+	}
+	return severity
+}
+
+func getSeverityLevel(severity string) int {
+	switch normalizeSeverity(severity) {
+	case "low":
+		return 1
+	case "moderate":
+		return 2
+	case "high":
+		return 3
+	case "critical":
+		return 4
+	}
+	return 0
+}
+
+// AuditNode is a node of the dependency tree of the `?audit` report.
+type AuditNode struct {
+	Name         string       `json:"name"`
+	Version      string       `json:"version"`
+	Scope        string       `json:"scope,omitempty"`
+	Advisories   []*Advisory  `json:"advisories,omitempty"`
+	Dependencies []*AuditNode `json:"dependencies,omitempty"`
+}
+
+// AuditReport is the response of the `?audit` query.
+type AuditReport struct {
+	Package         string         `json:"package"`
+	Vulnerabilities map[string]int `json:"vulnerabilities"`
+	Tree            *AuditNode     `json:"tree"`
+}
+
+// auditSBOM checks the components of the SBOM with the advisory database, the dependencies of a component
+// are only expanded at the first occurrence in the tree.
+func auditSBOM(sbom *common.SBOM) *AuditReport {
+	report := &AuditReport{Vulnerabilities: map[string]int{}}
+	expanded := map[string]bool{}
+	var walk func(c *common.SBOMComponent) *AuditNode
+	walk = func(c *common.SBOMComponent) *AuditNode {
+		node := &AuditNode{Name: c.Name, Version: c.Version, Scope: c.Scope}
+		if strings.HasPrefix(c.Purl, "pkg:npm/") {
+			node.Advisories = advisoryDB.Query(c.Name, c.Version)
+		}
+		if expanded[c.Purl] {
+			return node
+		}
+		expanded[c.Purl] = true
+		for _, a := range node.Advisories {
+			report.Vulnerabilities[a.Severity]++
+		}
+		for _, purl := range c.DependsOn {
+			if dep := sbom.Get(purl); dep != nil {
+				node.Dependencies = append(node.Dependencies, walk(dep))
+			}
+		}
+		return node
+	}
+	if root := sbom.Get(sbom.Root); root != nil {
+		report.Package = root.Name + "@" + root.Version
+		report.Tree = walk(root)
+	}
+	return report
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/esm-dev/esm.sh/server/common"
+)
+
+func TestAdvisoryDB(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(path.Join(dir, "npm"), 0755)
+	os.WriteFile(path.Join(dir, "npm", "GHSA-35jh-r3h4-6jhm.json"), []byte(`{
+		"id": "GHSA-35jh-r3h4-6jhm",
+		"aliases": ["CVE-2021-23337"],
+		"summary": "Command Injection in lodash",
+		"affected": [{
+			"package": {"ecosystem": "npm", "name": "lodash"},
+			"ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}, {"fixed": "4.17.21"}]}]
+		}],
+		"database_specific": {"severity": "HIGH"}
+	}`), 0644)
+	os.WriteFile(path.Join(dir, "github.json"), []byte(`[{
+		"ghsa_id": "GHSA-xxxx-xxxx-xxxx",
+		"cve_id": "CVE-2099-0001",
+		"summary": "Prototype Pollution in foo",
+		"severity": "critical",
+		"vulnerabilities": [
+			{"package": {"ecosystem": "npm", "name": "foo"}, "vulnerable_version_range": ">= 1.0.0, < 1.2.0"},
+			{"package": {"ecosystem": "npm", "name": "baz"}, "vulnerable_version_range": "> 1.0.0, <= 1.1.0"}
+		]
+	}, {
+		"ghsa_id": "GHSA-yyyy-yyyy-yyyy",
+		"severity": "low",
+		"withdrawn": "2099-01-01T00:00:00Z",
+		"vulnerabilities": [{"package": {"ecosystem": "npm", "name": "foo"}, "vulnerable_version_range": "< 2.0.0"}]
+	}]`), 0644)
+	os.WriteFile(path.Join(dir, "README.md"), []byte("# advisories"), 0644)
+
+	db := &AdvisoryDB{}
+	n, err := db.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 advisories, got %d", n)
+	}
+
+	tests := []struct {
+		name     string
+		version  string
+		expected string
+	}{
+		{"lodash", "4.17.20", "GHSA-35jh-r3h4-6jhm; severity=high"},
+		{"lodash", "4.17.21", ""},
+		{"foo", "0.9.0", ""},
+		{"foo", "1.1.0", "GHSA-xxxx-xxxx-xxxx; severity=critical"},
+		{"foo", "1.2.0", ""},
+		{"baz", "1.0.0", ""},
+		{"baz", "1.0.1", "GHSA-xxxx-xxxx-xxxx; severity=critical"},
+		{"baz", "1.1.1", ""},
+		{"bar", "1.0.0", ""},
+	}
+	for _, test := range tests {
+		if header := formatAdvisoriesHeader(db.Query(test.name, test.version)); header != test.expected {
+			t.Fatalf("Query(%q, %q): expected %q, got %q", test.name, test.version, test.expected, header)
+		}
+	}
+
+	advisoryDB = db
+	defer func() { advisoryDB = &AdvisoryDB{} }()
+
+	config.Advisories.Block = "critical"
+	defer func() { config.Advisories.Block = "" }()
+	if isAdvisoryBlocked(db.Query("lodash", "4.17.20")) || !isAdvisoryBlocked(db.Query("foo", "1.1.0")) {
+		t.Fatal("only the critical advisories should be blocked")
+	}
+
+	sbom := &common.SBOM{Root: common.NpmPurl("app", "1.0.0")}
+	root := sbom.Add(&common.SBOMComponent{Name: "app", Version: "1.0.0", Purl: common.NpmPurl("app", "1.0.0")})
+	for _, name := range []string{"lodash", "foo"} {
+		c := sbom.Add(&common.SBOMComponent{Name: name, Version: "1.1.0", Purl: common.NpmPurl(name, "1.1.0"), Scope: "bundled"})
+		root.AddDependency(c.Purl)
+	}
+	report := auditSBOM(sbom)
+	if report.Package != "app@1.0.0" || len(report.Tree.Dependencies) != 2 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if report.Vulnerabilities["high"] != 1 || report.Vulnerabilities["critical"] != 1 {
+		t.Fatalf("unexpected vulnerabilities: %v", report.Vulnerabilities)
+	}
+}
+
+func TestWalkAdvisoryFiles(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(path.Join(dir, "a.json"), []byte(`[]`), 0644)
+	os.WriteFile(path.Join(dir, "b.json"), []byte(`[]`), 0644)
+	checksum, err := walkAdvisoryFiles(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// removing a file that is not the newest one should be detected
+	os.Remove(path.Join(dir, "a.json"))
+	if ret, _ := walkAdvisoryFiles(dir, nil); ret == checksum {
+		t.Fatal("the checksum should be changed after removing a file")
+	}
+}
+
+func TestCheckBundledAdvisories(t *testing.T) {
+	ctx := newBundleTestContext(t, map[string]string{
+		"app":    `{"name":"app","version":"1.0.0","dependencies":{"lodash":"^4.17.0"}}`,
+		"lodash": `{"name":"lodash","version":"4.17.20"}`,
+	})
+	block := config.Advisories.Block
+	defer func() { config.Advisories.Block = block }()
+	config.Advisories.Block = "high"
+
+	// the build is cached before the advisory is added
+	meta, err := decodeBuildMeta(encodeBuildMeta(&BuildMeta{BundledDeps: ctx.getBundledComponents()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkBundledAdvisories(ctx.esm.Specifier(), meta.BundledDeps); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(path.Join(dir, "github.json"), []byte(`[{
+		"ghsa_id": "GHSA-35jh-r3h4-6jhm",
+		"severity": "high",
+		"vulnerabilities": [{"package": {"ecosystem": "npm", "name": "lodash"}, "vulnerable_version_range": "< 4.17.21"}]
+	}]`), 0644)
+	db := &AdvisoryDB{}
+	if _, err := db.Load(dir); err != nil {
+		t.Fatal(err)
+	}
+	advisoryDB = db
+	defer func() { advisoryDB = &AdvisoryDB{} }()
+
+	err = checkBundledAdvisories(ctx.esm.Specifier(), meta.BundledDeps)
+	if err == nil || err.(*BuildError).Kind != "advisory" {
+		t.Fatalf("the bundled lodash@4.17.20 should be blocked, got %v", err)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"path"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/esm-dev/esm.sh/server/npm_replacements"
@@ -48,12 +49,12 @@ type BuildContext struct {
 	splitting   *set.ReadOnlySet[string]
 	esmImports  [][2]string
 	cjsRequires [][3]string
-	smOffset    int
 }
 
 var (
 	regexpESMInternalIdent = regexp.MustCompile(`__[a-zA-Z]+\$`)
 	regexpVarDecl          = regexp.MustCompile(`var ([\w$]+)\s*=\s*[\w$]+$`)
+	regexpWasmSourceImport = regexp.MustCompile(`import\s*([\w$]+)\s*from\s*"wasm-source:`)
 )
 
 var loaders = map[string]esbuild.Loader{
@@ -68,18 +69,20 @@ var loaders = map[string]esbuild.Loader{
 	".vue":    esbuild.LoaderJS,
 	".svelte": esbuild.LoaderJS,
 	".css":    esbuild.LoaderCSS,
-	".json":   esbuild.LoaderJSON,
-	".txt":    esbuild.LoaderText,
-	".html":   esbuild.LoaderText,
-	".md":     esbuild.LoaderText,
-	".svg":    esbuild.LoaderDataURL,
-	".png":    esbuild.LoaderDataURL,
-	".webp":   esbuild.LoaderDataURL,
-	".gif":    esbuild.LoaderDataURL,
-	".ttf":    esbuild.LoaderDataURL,
-	".eot":    esbuild.LoaderDataURL,
-	".woff":   esbuild.LoaderDataURL,
-	".woff2":  esbuild.LoaderDataURL,
+	// css modules export the class names
+	".module.css": esbuild.LoaderLocalCSS,
+	".json":       esbuild.LoaderJSON,
+	".txt":        esbuild.LoaderText,
+	".html":       esbuild.LoaderText,
+	".md":         esbuild.LoaderText,
+	".svg":        esbuild.LoaderDataURL,
+	".png":        esbuild.LoaderDataURL,
+	".webp":       esbuild.LoaderDataURL,
+	".gif":        esbuild.LoaderDataURL,
+	".ttf":        esbuild.LoaderDataURL,
+	".eot":        esbuild.LoaderDataURL,
+	".woff":       esbuild.LoaderDataURL,
+	".woff2":      esbuild.LoaderDataURL,
 }
 
 func (ctx *BuildContext) Path() string {
@@ -144,6 +147,35 @@ func (ctx *BuildContext) Build() (meta *BuildMeta, err error) {
 		return
 	}
 
+	// the bundled dependencies are collected once for the checks below, and they are stored in the build meta
+	// to be checked with the current license policy and advisory database when the build is served
+	bundled := ctx.getBundledComponents()
+
+	// check the bundled dependencies with the allow list and the ban list
+	if hasPackageRules() {
+		err = ctx.checkBundledPackageRules(bundled)
+		if err != nil {
+			return
+		}
+	}
+
+	// check the bundled dependencies with the security advisories
+	if config.Advisories.Block != "" {
+		err = checkBundledAdvisories(ctx.esm.Specifier(), bundled)
+		if err != nil {
+			ctx.logger.Warnf("build(%s): %v", ctx.Path(), err)
+			return
+		}
+	}
+
+	// check the licenses of the package and its bundled dependencies
+	if config.LicensePolicy.IsEnabled() {
+		err = ctx.checkLicensePolicy(bundled)
+		if err != nil {
+			return
+		}
+	}
+
 	// analyze splitting modules
 	ctx.status = "analyze"
 	err = ctx.analyzeSplitting()
@@ -157,6 +189,13 @@ func (ctx *BuildContext) Build() (meta *BuildMeta, err error) {
 	if err != nil {
 		return
 	}
+	meta.BundledDeps = bundled
+
+	// save the provenance manifest of the build
+	err = ctx.saveManifest(meta)
+	if err != nil {
+		return
+	}
 
 	// save the build result to the storage
 	key := ctx.npmrc.zoneId + ":" + ctx.Path()
@@ -207,18 +246,26 @@ func (ctx *BuildContext) buildPath() {
 	if ctx.dev {
 		name += ".development"
 	}
-	if ctx.bundleMode == BundleDeps {
+	ext := "mjs"
+	if ctx.args.format == "cjs" {
+		// the `cjs` build always bundles all dependencies
+		ext = "cjs"
+	} else if ctx.args.format != "" {
+		// the `iife` and `umd` builds always bundle all dependencies
+		name += "." + ctx.args.format
+	} else if ctx.bundleMode == BundleDeps {
 		name += ".bundle"
 	} else if ctx.bundleMode == BundleFalse {
 		name += ".nobundle"
 	}
 	ctx.path = fmt.Sprintf(
-		"/%s%s/%s%s/%s.mjs",
+		"/%s%s/%s%s/%s.%s",
 		asteriskPrefix,
 		esm.Name(),
 		ctx.getBuildArgsPrefix(ctx.target == "types"),
 		ctx.target,
 		name,
+		ext,
 	)
 }
 
@@ -296,7 +343,8 @@ func (ctx *BuildContext) buildModule(analyzeMode bool) (meta *BuildMeta, include
 	}
 
 	// cjs reexport
-	if cjsReexport != "" {
+	// the `iife`, `umd` and `cjs` builds bundle the reexported module instead
+	if cjsReexport != "" && ctx.args.format == "" {
 		dep, _, e := ctx.lookupDep(cjsReexport, false)
 		if e != nil {
 			err = e
@@ -351,6 +399,12 @@ func (ctx *BuildContext) buildModule(analyzeMode bool) (meta *BuildMeta, include
 
 	if entry.module {
 		entryPoint = entryModuleFilename
+	} else if ctx.args.format == "cjs" {
+		// keep the `module.exports` of the cjs module as it is
+		stdin = esbuild.StdinOptions{
+			Sourcefile: "endpoint.js",
+			Contents:   fmt.Sprintf(`module.exports = require("%s");`, entrySpecifier),
+		}
 	} else {
 		buf, recycle := NewBuffer()
 		defer recycle()
@@ -365,6 +419,12 @@ func (ctx *BuildContext) buildModule(analyzeMode bool) (meta *BuildMeta, include
 		}
 	}
 
+	// share chunks across the exported entries of the package in `bundle` mode
+	var splitEntries []*splitEntry
+	if !analyzeMode && ctx.bundleMode == BundleDeps && ctx.args.format == "" && ctx.pkgJson.Exports.Len() > 1 {
+		splitEntries = ctx.resolveSplitEntries(entry, cjsExports)
+	}
+
 	browserExclude := map[string]*set.Set[string]{}
 	implicitExternal := set.New[string]()
 	pkgSideEffects := esbuild.SideEffectsTrue
@@ -386,8 +446,37 @@ func (ctx *BuildContext) buildModule(analyzeMode bool) (meta *BuildMeta, include
 			build.OnResolve(
 				esbuild.OnResolveOptions{Filter: ".*"},
 				func(args esbuild.OnResolveArgs) (esbuild.OnResolveResult, error) {
+					// node runtime modules and their chunks that are bundled in `iife`, `umd` and `cjs` format
+					if args.Namespace == "node-runtime" {
+						filename := args.Path
+						if isRelPathSpecifier(filename) {
+							filename = path.Join(path.Dir(args.Importer), filename)
+						}
+						if strings.HasPrefix(filename, "/node/") {
+							if code, ok := unenvNodeRuntimeBulid[filename[6:]]; ok {
+								return esbuild.OnResolveResult{Path: filename, Namespace: "node-runtime", PluginData: code}, nil
+							}
+						}
+						return esbuild.OnResolveResult{Path: filename, Namespace: "browser-exclude"}, nil
+					}
+
+					// nodejs polyfills that are injected in `iife`, `umd` and `cjs` format
+					if args.Path == "<node-polyfills>" {
+						return esbuild.OnResolveResult{Path: args.Path, Namespace: "node-polyfills"}, nil
+					}
+
+					// wasm modules that are imported with the source phase imports
+					if strings.HasPrefix(args.Path, "wasm-source:") {
+						return esbuild.OnResolveResult{Path: args.Path, External: true}, nil
+					}
+
+					// cjs entries that share chunks in `bundle` mode
+					if strings.HasPrefix(args.Path, "split-entry:") {
+						return esbuild.OnResolveResult{Path: args.Path, Namespace: "split-entry"}, nil
+					}
+
 					// entry point
-					if args.Path == entryPoint || args.Path == entrySpecifier {
+					if args.Path == entryPoint || args.Path == entrySpecifier || (len(splitEntries) > 0 && args.Kind == esbuild.ResolveEntryPoint) {
 						path := args.Path
 						if path == entrySpecifier {
 							path = entryModuleFilename
@@ -418,7 +507,7 @@ func (ctx *BuildContext) buildModule(analyzeMode bool) (meta *BuildMeta, include
 					}
 
 					// if `?external-require` present, ignore specifier that is a require call
-					if ctx.args.externalRequire && args.Kind == esbuild.ResolveJSRequireCall && entry.module {
+					if ctx.args.externalRequire && ctx.args.format == "" && args.Kind == esbuild.ResolveJSRequireCall && entry.module {
 						return esbuild.OnResolveResult{
 							Path:     args.Path,
 							External: true,
@@ -518,6 +607,16 @@ func (ctx *BuildContext) buildModule(analyzeMode bool) (meta *BuildMeta, include
 
 					// nodejs builtin module
 					if isNodeBuiltInModule(specifier) {
+						// bundle the node runtime polyfills in `iife`, `umd` and `cjs` format
+						if ctx.args.format != "" {
+							if ctx.isBrowserTarget() {
+								if code, ok := unenvNodeRuntimeBulid[specifier[5:]+".mjs"]; ok {
+									return esbuild.OnResolveResult{Path: "/node/" + specifier[5:] + ".mjs", Namespace: "node-runtime", PluginData: code}, nil
+								}
+								return esbuild.OnResolveResult{Path: specifier, Namespace: "browser-exclude"}, nil
+							}
+							return esbuild.OnResolveResult{Path: specifier, External: true}, nil
+						}
 						externalPath, err := ctx.resolveExternalModule(specifier, args.Kind, withTypeJSON, analyzeMode)
 						if err != nil {
 							return esbuild.OnResolveResult{}, err
@@ -559,6 +658,11 @@ func (ctx *BuildContext) buildModule(analyzeMode bool) (meta *BuildMeta, include
 						}, nil
 					}
 
+					// bundles everything in `iife`, `umd` and `cjs` format, including peerDependencies
+					if ctx.args.format != "" {
+						return esbuild.OnResolveResult{}, nil
+					}
+
 					// bundles all dependencies in `bundle` mode, apart from peerDependencies and `?external` flag
 					if ctx.bundleMode == BundleDeps && !ctx.args.external.Has(toPackageName(specifier)) && !implicitExternal.Has(specifier) {
 						pkgName := toPackageName(specifier)
@@ -862,6 +966,44 @@ func (ctx *BuildContext) buildModule(analyzeMode bool) (meta *BuildMeta, include
 				},
 			)
 
+			// node runtime loader
+			build.OnLoad(
+				esbuild.OnLoadOptions{Filter: ".*", Namespace: "node-runtime"},
+				func(args esbuild.OnLoadArgs) (ret esbuild.OnLoadResult, err error) {
+					contents := string(args.PluginData.([]byte))
+					return esbuild.OnLoadResult{Contents: &contents, Loader: esbuild.LoaderJS}, nil
+				},
+			)
+
+			// node polyfills loader
+			build.OnLoad(
+				esbuild.OnLoadOptions{Filter: ".*", Namespace: "node-polyfills"},
+				func(args esbuild.OnLoadArgs) (ret esbuild.OnLoadResult, err error) {
+					contents := ctx.getNodePolyfills()
+					return esbuild.OnLoadResult{Contents: &contents, Loader: esbuild.LoaderJS}, nil
+				},
+			)
+
+			// split entry loader, wraps the cjs entry as an es module
+			build.OnLoad(
+				esbuild.OnLoadOptions{Filter: ".*", Namespace: "split-entry"},
+				func(args esbuild.OnLoadArgs) (ret esbuild.OnLoadResult, err error) {
+					i, err := strconv.Atoi(strings.TrimPrefix(args.Path, "split-entry:"))
+					if err != nil || i < 0 || i >= len(splitEntries) {
+						return esbuild.OnLoadResult{}, errors.New("invalid split entry")
+					}
+					e := splitEntries[i]
+					buf := bytes.NewBuffer(nil)
+					fmt.Fprintf(buf, `import * as cjsm from "%s";`, path.Join(ctx.wd, "node_modules", ctx.esm.PkgName, e.entry.main))
+					if len(e.cjsExports) > 0 {
+						fmt.Fprintf(buf, `export const { %s } = cjsm;`, strings.Join(e.cjsExports, ","))
+					}
+					buf.WriteString("export default cjsm.default ?? cjsm;")
+					contents := buf.String()
+					return esbuild.OnLoadResult{Contents: &contents, ResolveDir: ctx.wd, Loader: esbuild.LoaderJS}, nil
+				},
+			)
+
 			// npm replacement loader
 			build.OnLoad(
 				esbuild.OnLoadOptions{Filter: ".*", Namespace: "npm-replacement"},
@@ -885,16 +1027,28 @@ func (ctx *BuildContext) buildModule(analyzeMode bool) (meta *BuildMeta, include
 				},
 			)
 
-			// wasm module exclude loader
+			// wasm module loader
 			build.OnLoad(
 				esbuild.OnLoadOptions{Filter: ".*", Namespace: "wasm"},
 				func(args esbuild.OnLoadArgs) (ret esbuild.OnLoadResult, err error) {
+					// load the wasm file from the raw file url to enable streaming compilation
+					if wasmUrl := ctx.getWasmURL(args.Path); wasmUrl != "" {
+						var code string
+						if supportsSourcePhaseImports(ctx.target) {
+							code = fmt.Sprintf(`import mod from "wasm-source:%s";export default mod;`, wasmUrl)
+						} else {
+							code = fmt.Sprintf(`export default await WebAssembly.compileStreaming(fetch(new URL(%s, import.meta.url)));`, utils.MustEncodeJSON(wasmUrl))
+						}
+						return esbuild.OnLoadResult{Contents: &code, Loader: esbuild.LoaderJS}, nil
+					}
+					// embed the wasm file for the targets that don't support top-level await,
+					// the default export is a `WebAssembly.Module` as well
 					wasm, err := os.ReadFile(args.Path)
 					if err != nil {
 						return
 					}
 					wasm64 := base64.StdEncoding.EncodeToString(wasm)
-					code := fmt.Sprintf("export default Uint8Array.from(atob('%s'), c => c.charCodeAt(0))", wasm64)
+					code := fmt.Sprintf("export default new WebAssembly.Module(Uint8Array.from(atob('%s'), c => c.charCodeAt(0)))", wasm64)
 					return esbuild.OnLoadResult{Contents: &code, Loader: esbuild.LoaderJS}, nil
 				},
 			)
@@ -1013,6 +1167,10 @@ func (ctx *BuildContext) buildModule(analyzeMode bool) (meta *BuildMeta, include
 		}
 		define["global"] = "globalThis"
 	}
+	// apply the `?define` and `?env` query
+	for key, value := range ctx.args.define {
+		define[key] = value
+	}
 	conditions := ctx.args.conditions
 	if ctx.dev {
 		conditions = append(conditions, "development")
@@ -1027,6 +1185,7 @@ func (ctx *BuildContext) buildModule(analyzeMode bool) (meta *BuildMeta, include
 		PreserveSymlinks:  true,
 		Format:            esbuild.FormatESModule,
 		Target:            targets[ctx.target],
+		Engines:           getBuildEngines(ctx.target),
 		Platform:          esbuild.PlatformBrowser,
 		Define:            define,
 		Supported:         supported,
@@ -1044,7 +1203,21 @@ func (ctx *BuildContext) buildModule(analyzeMode bool) (meta *BuildMeta, include
 		Outdir:            "/esbuild",
 		Write:             false,
 	}
-	if entryPoint != "" {
+	if len(splitEntries) > 0 {
+		entryPoints := make([]esbuild.EntryPoint, len(splitEntries))
+		for i, e := range splitEntries {
+			inputPath := path.Join(ctx.wd, "node_modules", ctx.esm.PkgName, e.entry.main)
+			if !e.entry.module {
+				inputPath = "split-entry:" + strconv.Itoa(i)
+			}
+			entryPoints[i] = esbuild.EntryPoint{InputPath: inputPath, OutputPath: e.outPath}
+		}
+		options.EntryPointsAdvanced = entryPoints
+		options.Splitting = true
+		options.ChunkNames = ctx.getBuildArgsPrefix(false) + ctx.target + "/_chunks/[hash]"
+		options.OutExtension = map[string]string{".js": ".mjs"}
+		options.Metafile = true
+	} else if entryPoint != "" {
 		options.EntryPoints = []string{entryPoint}
 	} else {
 		options.Stdin = &stdin
@@ -1052,6 +1225,21 @@ func (ctx *BuildContext) buildModule(analyzeMode bool) (meta *BuildMeta, include
 	if ctx.target == "node" {
 		options.Platform = esbuild.PlatformNode
 	}
+	if ctx.args.format == "cjs" {
+		options.Format = esbuild.FormatCommonJS
+	} else if ctx.args.format != "" {
+		options.Format = esbuild.FormatIIFE
+		options.GlobalName = ctx.args.globalName
+		if ctx.args.format == "umd" {
+			// the umd wrapper returns the exports from the factory function
+			options.GlobalName = "__exports$"
+		}
+	}
+	if ctx.args.format != "" {
+		if ctx.target != "node" {
+			options.Inject = []string{"<node-polyfills>"}
+		}
+	}
 	if config.SourceMap {
 		options.Sourcemap = esbuild.SourceMapExternal
 	}
@@ -1082,7 +1270,11 @@ REBUILD:
 		if strings.HasPrefix(msg, "Could not resolve \"") {
 			// current module can not be marked as an external
 			if strings.HasPrefix(msg, fmt.Sprintf("Could not resolve \"%s\"", entrySpecifier)) {
-				err = fmt.Errorf("could not resolve \"%s\"", entrySpecifier)
+				err = &BuildError{
+					Kind:    "resolve",
+					Message: fmt.Sprintf("could not resolve \"%s\"", entrySpecifier),
+					Package: ctx.esm.Specifier(),
+				}
 				return
 			}
 			name := strings.Split(msg, "\"")[1]
@@ -1110,7 +1302,13 @@ REBUILD:
 				}
 			}
 		}
-		err = errors.New("esbuild: " + msg)
+		if len(splitEntries) > 0 {
+			// other entries may break the build, fall back to build the module alone
+			ctx.logger.Warnf("build(%s): failed to build with shared chunks, %s", ctx.Path(), msg)
+			noSplitBuildDirs.Store(ctx.npmrc.zoneId+":"+ctx.getPackageBuildDir(), true)
+			return ctx.buildModule(analyzeMode)
+		}
+		err = newEsbuildError(ctx.esm.Specifier(), res.Errors)
 		return
 	}
 
@@ -1122,40 +1320,98 @@ REBUILD:
 		ctx.logger.Warnf("esbuild(%s): %s", ctx.Path(), w.Text)
 	}
 
-	imports := set.New[string]()
+	pkgBuildDir := ctx.getPackageBuildDir()
+
+	// the output path of current entry in the split build
+	var entryOutPath string
+	for _, e := range splitEntries {
+		if e.build == ctx {
+			entryOutPath = e.outPath
+		}
+	}
+
+	// the outputs of other entries are saved by their own builds
+	isOtherEntryOutput := func(filename string) bool {
+		for _, e := range splitEntries {
+			if e.build != ctx {
+				basename := "/esbuild/" + e.outPath
+				if filename == basename+".mjs" || filename == basename+".css" || filename == basename+".mjs.map" {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	// returns the url path of the output file
+	getOutputPath := func(filename string) string {
+		if len(splitEntries) > 0 {
+			return pkgBuildDir + strings.TrimPrefix(filename, "/esbuild")
+		}
+		if strings.HasSuffix(filename, ".css") {
+			return strings.TrimSuffix(ctx.Path(), path.Ext(ctx.Path())) + ".css"
+		}
+		if strings.HasSuffix(filename, ".map") {
+			return ctx.Path() + ".map"
+		}
+		return ctx.Path()
+	}
+
+	// the source map offsets and the imports of the js outputs
+	smOffsets := map[string]int{}
+	outputImports := map[string]*set.Set[string]{}
 
 	for _, file := range res.OutputFiles {
-		if strings.HasSuffix(file.Path, ".js") {
+		if isOtherEntryOutput(file.Path) {
+			continue
+		}
+		if endsWith(file.Path, ".js", ".mjs") {
 			jsContent := file.Contents
+			outputPath := getOutputPath(file.Path)
+			smOffset := 0
+			imports := set.New[string]()
+			outputImports[file.Path] = imports
+
+			// the shared chunks are not entries
+			esm := ctx.esm
+			isEntry := true
+			if len(splitEntries) > 0 && file.Path != "/esbuild/"+entryOutPath+".mjs" {
+				esm.SubModuleName = ""
+				isEntry = false
+			}
+
 			header, recycle := NewBuffer()
 			defer recycle()
 			header.WriteString("/* esm.sh - ")
-			if ctx.esm.GhPrefix {
+			if esm.GhPrefix {
 				header.WriteString("github:")
-			} else if ctx.esm.PrPrefix {
+			} else if esm.GitPrefix {
+				header.WriteString("git:")
+			} else if esm.PrPrefix {
 				header.WriteString("pkg.pr.new/")
 			}
-			header.WriteString(ctx.esm.PkgName)
-			if ctx.esm.GhPrefix {
+			header.WriteString(esm.PkgName)
+			if esm.GhPrefix || esm.GitPrefix {
 				header.WriteByte('#')
 			} else {
 				header.WriteByte('@')
 			}
-			header.WriteString(ctx.esm.PkgVersion)
-			if ctx.esm.SubModuleName != "" {
+			header.WriteString(esm.PkgVersion)
+			if esm.SubModuleName != "" {
 				header.WriteByte('/')
-				header.WriteString(ctx.esm.SubModuleName)
+				header.WriteString(esm.SubModuleName)
 			}
 			header.WriteString(" */\n")
 
 			// remove shebang
 			if bytes.HasPrefix(jsContent, []byte("#!/")) {
 				jsContent = jsContent[bytes.IndexByte(jsContent, '\n')+1:]
-				ctx.smOffset--
+				smOffset--
 			}
 
 			// add nodejs compatibility
-			if ctx.target != "node" {
+			// the polyfills are injected by esbuild in `iife`, `umd` and `cjs` format
+			if ctx.target != "node" && ctx.args.format == "" {
 				ids := set.New[string]()
 				for _, r := range regexpESMInternalIdent.FindAll(jsContent, -1) {
 					ids.Add(string(r))
@@ -1237,7 +1493,8 @@ REBUILD:
 			}
 
 			// apply cjs requires
-			if len(ctx.cjsRequires) > 0 {
+			// the split outputs only need the `require` function if they use it
+			if len(ctx.cjsRequires) > 0 && (len(splitEntries) == 0 || bytes.Contains(jsContent, []byte("require"))) {
 				requires := make([][3]string, 0, len(ctx.cjsRequires))
 				set := set.New[string]()
 				for _, r := range ctx.cjsRequires {
@@ -1333,8 +1590,23 @@ REBUILD:
 				}
 			}
 
+			// use the default export as the `module.exports` if the module only exports default
+			if ctx.args.format == "cjs" && entry.module && meta.ExportDefault {
+				jsContent = concatBytes(jsContent, []byte("\nif(Object.keys(module.exports).length===1&&\"default\"in module.exports)module.exports=module.exports.default;\n"))
+			}
+
+			// wrap the iife with the umd header and footer
+			if ctx.args.format == "umd" {
+				header.WriteString(getUMDHeader(ctx.args.globalName))
+				jsContent = concatBytes(jsContent, []byte("return __exports$;\n});\n"))
+			}
+
 			// to fix the source map
-			ctx.smOffset += strings.Count(header.String(), "\n")
+			smOffset += strings.Count(header.String(), "\n")
+			smOffsets[file.Path] = smOffset
+
+			// use source phase imports for wasm modules
+			jsContent = rewriteWasmSourceImports(jsContent)
 
 			// apply rewrites
 			jsContent, dropSourceMap := ctx.rewriteJS(jsContent)
@@ -1346,7 +1618,7 @@ REBUILD:
 			finalJS.Write(jsContent)
 
 			// check if the package is deprecated
-			if !ctx.esm.GhPrefix && !ctx.esm.PrPrefix {
+			if isEntry && !ctx.esm.GhPrefix && !ctx.esm.GitPrefix && !ctx.esm.PrPrefix {
 				deprecated, _ := ctx.npmrc.isDeprecated(ctx.pkgJson.Name, ctx.pkgJson.Version)
 				if deprecated != "" {
 					fmt.Fprintf(finalJS, `console.warn("%%c[esm.sh]%%c %%cdeprecated%%c %s@%s: " + %s, "color:grey", "", "color:red", "");%s`, ctx.esm.PkgName, ctx.esm.PkgVersion, utils.MustEncodeJSON(deprecated), "\n")
@@ -1356,13 +1628,14 @@ REBUILD:
 			// add sourcemap Url
 			if config.SourceMap && !dropSourceMap {
 				finalJS.WriteString("//# sourceMappingURL=")
-				finalJS.WriteString(path.Base(ctx.Path()))
+				finalJS.WriteString(path.Base(outputPath))
 				finalJS.WriteString(".map")
 			}
 
-			err = ctx.storage.Put(ctx.getSavepath(), finalJS)
+			savePath := normalizeSavePath(ctx.npmrc.zoneId, path.Join("modules", outputPath))
+			err = ctx.storage.Put(savePath, finalJS)
 			if err != nil {
-				ctx.logger.Errorf("storage.put(%s): %v", ctx.getSavepath(), err)
+				ctx.logger.Errorf("storage.put(%s): %v", savePath, err)
 				err = errors.New("storage: " + err.Error())
 				return
 			}
@@ -1370,33 +1643,39 @@ REBUILD:
 	}
 
 	for _, file := range res.OutputFiles {
+		if isOtherEntryOutput(file.Path) {
+			continue
+		}
 		if strings.HasSuffix(file.Path, ".css") {
-			savePath := ctx.getSavepath()
-			savePath = strings.TrimSuffix(savePath, path.Ext(savePath)) + ".css"
+			savePath := normalizeSavePath(ctx.npmrc.zoneId, path.Join("modules", getOutputPath(file.Path)))
 			err = ctx.storage.Put(savePath, bytes.NewReader(file.Contents))
 			if err != nil {
 				ctx.logger.Errorf("storage.put(%s): %v", savePath, err)
 				err = errors.New("storage: " + err.Error())
 				return
 			}
-			meta.CSSInJS = true
-		} else if config.SourceMap && strings.HasSuffix(file.Path, ".js.map") {
+			if len(splitEntries) == 0 || file.Path == "/esbuild/"+entryOutPath+".css" {
+				meta.CSSInJS = true
+			}
+		} else if config.SourceMap && endsWith(file.Path, ".js.map", ".mjs.map") {
 			var sourceMap map[string]interface{}
 			if json.Unmarshal(file.Contents, &sourceMap) == nil {
+				smOffset := smOffsets[strings.TrimSuffix(file.Path, ".map")]
 				if mapping, ok := sourceMap["mappings"].(string); ok {
-					fixedMapping := make([]byte, ctx.smOffset+len(mapping))
-					for i := 0; i < ctx.smOffset; i++ {
+					fixedMapping := make([]byte, smOffset+len(mapping))
+					for i := 0; i < smOffset; i++ {
 						fixedMapping[i] = ';'
 					}
-					copy(fixedMapping[ctx.smOffset:], mapping)
+					copy(fixedMapping[smOffset:], mapping)
 					sourceMap["mappings"] = string(fixedMapping)
 				}
 				buf, recycle := NewBuffer()
 				defer recycle()
 				if json.NewEncoder(buf).Encode(sourceMap) == nil {
-					err = ctx.storage.Put(ctx.getSavepath()+".map", buf)
+					savePath := normalizeSavePath(ctx.npmrc.zoneId, path.Join("modules", getOutputPath(file.Path)))
+					err = ctx.storage.Put(savePath, buf)
 					if err != nil {
-						ctx.logger.Errorf("storage.put(%s): %v", ctx.getSavepath()+".map", err)
+						ctx.logger.Errorf("storage.put(%s): %v", savePath, err)
 						err = errors.New("storage: " + err.Error())
 						return
 					}
@@ -1405,16 +1684,76 @@ REBUILD:
 		}
 	}
 
-	// sort imports
-	for _, path := range imports.Values() {
-		if strings.HasPrefix(path, "/") {
-			meta.Imports = append(meta.Imports, path)
+	if len(splitEntries) > 0 {
+		// resolve the chunk imports of the entries with the metafile
+		var metafile struct {
+			Outputs map[string]struct {
+				Imports []struct {
+					Path     string `json:"path"`
+					Kind     string `json:"kind"`
+					External bool   `json:"external"`
+				} `json:"imports"`
+			} `json:"outputs"`
+		}
+		err = json.Unmarshal([]byte(res.Metafile), &metafile)
+		if err != nil {
+			err = errors.New("esbuild: invalid metafile")
+			return
+		}
+		chunkImports := map[string][]string{}
+		for outputPath, output := range metafile.Outputs {
+			// the paths in the metafile are relative to the working directory
+			filename := path.Join(ctx.wd, outputPath)
+			for _, imp := range output.Imports {
+				if !imp.External && imp.Kind == "import-statement" {
+					chunkImports[filename] = append(chunkImports[filename], path.Join(ctx.wd, imp.Path))
+				}
+			}
 		}
+		imports := set.New[string]()
+		seen := set.New[string]()
+		var walk func(filename string)
+		walk = func(filename string) {
+			if seen.Has(filename) {
+				return
+			}
+			seen.Add(filename)
+			if s, ok := outputImports[filename]; ok {
+				for _, path := range s.Values() {
+					imports.Add(path)
+				}
+			}
+			for _, chunk := range chunkImports[filename] {
+				imports.Add(getOutputPath(chunk))
+				walk(chunk)
+			}
+		}
+		walk("/esbuild/" + entryOutPath + ".mjs")
+		for _, path := range imports.Values() {
+			if strings.HasPrefix(path, "/") {
+				meta.Imports = append(meta.Imports, path)
+			}
+		}
+		sort.Strings(meta.Imports)
+	} else {
+		// sort imports
+		for _, imports := range outputImports {
+			for _, path := range imports.Values() {
+				if strings.HasPrefix(path, "/") {
+					meta.Imports = append(meta.Imports, path)
+				}
+			}
+		}
+		sort.Strings(meta.Imports)
 	}
-	sort.Strings(meta.Imports)
 
 	// resolve types(dts)
 	meta.Dts, err = ctx.resolveDTS(entry)
+	if err == nil && meta.Dts == "" && ctx.pkgJson.Types == "" && ctx.pkgJson.Typings == "" {
+		// synthesize the types from the export names if the package doesn't provide types
+		meta.Dts, err = ctx.synthesizeDTS(entry, meta, cjsExports)
+		meta.StubDts = meta.Dts != ""
+	}
 	return
 }
 
@@ -1426,6 +1765,10 @@ func (ctx *BuildContext) buildTypes() (ret *BuildMeta, err error) {
 		return
 	}
 
+	if strings.HasSuffix(ctx.esm.SubPath, stubDtsExt) && !ctx.existsPkgFile(ctx.esm.SubPath) {
+		return ctx.buildStubTypes()
+	}
+
 	var dts string
 	if endsWith(ctx.esm.SubPath, ".ts", ".mts", ".tsx", ".cts") {
 		dts = "./" + ctx.esm.SubPath
@@ -1455,7 +1798,7 @@ func (ctx *BuildContext) install() (err error) {
 			return err
 		}
 
-		if ctx.esm.GhPrefix || ctx.esm.PrPrefix {
+		if ctx.esm.GhPrefix || ctx.esm.GitPrefix || ctx.esm.PrPrefix {
 			// if the name in package.json is not the same as the repository name
 			if p.Name != ctx.esm.PkgName {
 				p.PkgName = p.Name
@@ -1506,10 +1849,10 @@ func (ctx *BuildContext) install() (err error) {
 		ctx.pkgJson = p
 	}
 
-	// - install dependencies in `BundleDeps` mode
+	// - install dependencies in `BundleDeps` mode, including peer dependencies for the `iife`, `umd` and `cjs` format
 	// - install '@babel/runtime' and '@swc/helpers' if they are present in the dependencies in `BundleDefault` mode
 	if ctx.bundleMode == BundleDeps {
-		ctx.npmrc.installDependencies(ctx.wd, ctx.pkgJson, false, nil)
+		ctx.npmrc.installDependencies(ctx.wd, ctx.pkgJson, ctx.args.format != "", nil)
 	} else if ctx.bundleMode == BundleDefault {
 		if v, ok := ctx.pkgJson.Dependencies["@babel/runtime"]; ok {
 			ctx.npmrc.installDependencies(ctx.wd, &PackageJSON{Dependencies: map[string]string{"@babel/runtime": v}}, false, nil)
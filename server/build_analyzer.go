@@ -6,8 +6,10 @@ import (
 	"io"
 	"os"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/ije/gox/set"
 )
@@ -19,28 +21,7 @@ type Ref struct {
 
 func (ctx *BuildContext) analyzeSplitting() (err error) {
 	if ctx.bundleMode == BundleDefault && ctx.pkgJson.Exports.Len() > 1 {
-		exportNames := set.New[string]()
-		for _, exportName := range ctx.pkgJson.Exports.keys {
-			exportName := stripEntryModuleExt(exportName)
-			if (exportName == "." || (strings.HasPrefix(exportName, "./") && !strings.ContainsRune(exportName, '*'))) && !endsWith(exportName, ".json", ".css", ".wasm", ".d.ts", ".d.mts", ".d.cts") {
-				v := ctx.pkgJson.Exports.values[exportName]
-				if s, ok := v.(string); ok {
-					if endsWith(s, ".json", ".css", ".wasm", ".d.ts", ".d.mts", ".d.cts") {
-						continue
-					}
-				} else if obj, ok := v.(JSONObject); ok {
-					// ignore types only exports
-					if len(obj.keys) == 1 && obj.keys[0] == "types" {
-						continue
-					}
-				}
-				if exportName == "." {
-					exportNames.Add("")
-				} else if strings.HasPrefix(exportName, "./") {
-					exportNames.Add(exportName[2:])
-				}
-			}
-		}
+		exportNames := ctx.getExportEntryNames()
 		if exportNames.Len() > 1 {
 			splittingTxtPath := path.Join(ctx.wd, "splitting.txt")
 			readSplittingTxt := func() bool {
@@ -203,3 +184,105 @@ func (ctx *BuildContext) analyzeSplitting() (err error) {
 	}
 	return
 }
+
+// getExportEntryNames returns the js entries defined in the `exports` field of package.json,
+// the wildcard exports and the types-only exports are ignored.
+func (ctx *BuildContext) getExportEntryNames() *set.Set[string] {
+	exportNames := set.New[string]()
+	for _, exportName := range ctx.pkgJson.Exports.keys {
+		exportName := stripEntryModuleExt(exportName)
+		if (exportName == "." || (strings.HasPrefix(exportName, "./") && !strings.ContainsRune(exportName, '*'))) && !endsWith(exportName, ".json", ".css", ".wasm", ".d.ts", ".d.mts", ".d.cts") {
+			v := ctx.pkgJson.Exports.values[exportName]
+			if s, ok := v.(string); ok {
+				if endsWith(s, ".json", ".css", ".wasm", ".d.ts", ".d.mts", ".d.cts") {
+					continue
+				}
+			} else if obj, ok := v.(JSONObject); ok {
+				// ignore types only exports
+				if len(obj.keys) == 1 && obj.keys[0] == "types" {
+					continue
+				}
+			}
+			if exportName == "." {
+				exportNames.Add("")
+			} else if strings.HasPrefix(exportName, "./") {
+				exportNames.Add(exportName[2:])
+			}
+		}
+	}
+	return exportNames
+}
+
+// splitEntry is an exported entry of the package that shares chunks with other entries in `bundle` mode.
+type splitEntry struct {
+	build      *BuildContext
+	entry      BuildEntry
+	cjsExports []string
+	// the output path relative to the package build directory, without the `.mjs` extension
+	outPath string
+}
+
+// the max number of the exported entries to build with shared chunks, every request of an entry builds
+// all the entries of the package, so only the packages with a few entries are split.
+const maxSplitEntries = 8
+
+// the build directories of the packages that failed to build with shared chunks, the entries of the
+// packages are built alone without trying the split build again.
+var noSplitBuildDirs sync.Map
+
+// resolveSplitEntries resolves the exported entries of the package to build them with shared chunks in `bundle` mode,
+// it returns nil if there are less than two entries, too many entries or the current module is not one of them.
+// The entries that fail to be analyzed are skipped.
+func (ctx *BuildContext) resolveSplitEntries(entry BuildEntry, cjsExports []string) (entries []*splitEntry) {
+	pkgBuildDir := ctx.getPackageBuildDir()
+	if _, ok := noSplitBuildDirs.Load(ctx.npmrc.zoneId + ":" + pkgBuildDir); ok {
+		return nil
+	}
+	exportNames := ctx.getExportEntryNames()
+	if exportNames.Len() < 2 || exportNames.Len() > maxSplitEntries || !exportNames.Has(ctx.esm.SubModuleName) {
+		return nil
+	}
+	names := exportNames.Values()
+	sort.Strings(names)
+	for _, name := range names {
+		e := &splitEntry{build: ctx, entry: entry, cjsExports: cjsExports}
+		if name != ctx.esm.SubModuleName {
+			esm := ctx.esm
+			esm.SubPath = name
+			esm.SubModuleName = name
+			e.build = &BuildContext{
+				npmrc:       ctx.npmrc,
+				logger:      ctx.logger,
+				db:          ctx.db,
+				storage:     ctx.storage,
+				esm:         esm,
+				args:        ctx.args,
+				bundleMode:  ctx.bundleMode,
+				externalAll: ctx.externalAll,
+				target:      ctx.target,
+				dev:         ctx.dev,
+				wd:          ctx.wd,
+				pkgJson:     ctx.pkgJson,
+			}
+			e.entry = e.build.resolveEntry(esm)
+			if e.entry.main == "" || endsWith(e.entry.main, ".css", ".json") || e.entry.isTypesOnly() {
+				continue
+			}
+			_, cjsExports, cjsReexport, err := e.build.lexer(&e.entry)
+			if err != nil {
+				ctx.logger.Warnf("build(%s): skip the split entry %s, %v", ctx.Path(), esm.Specifier(), err)
+				continue
+			}
+			e.cjsExports = cjsExports
+			if cjsReexport != "" {
+				continue
+			}
+		}
+		e.outPath = strings.TrimSuffix(strings.TrimPrefix(e.build.Path(), pkgBuildDir+"/"), ".mjs")
+		entries = append(entries, e)
+	}
+	if len(entries) < 2 {
+		return nil
+	}
+	return
+}
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"path"
 	"sort"
@@ -15,9 +17,12 @@ type BuildArgs struct {
 	deps              map[string]string
 	external          set.ReadOnlySet[string]
 	conditions        []string
+	define            map[string]string
 	keepNames         bool
 	ignoreAnnotations bool
 	externalRequire   bool
+	format            string // "iife", "umd" or "cjs"
+	globalName        string
 }
 
 func decodeBuildArgs(argsString string) (args BuildArgs, err error) {
@@ -46,6 +51,13 @@ func decodeBuildArgs(argsString string) (args BuildArgs, err error) {
 				args.external = *set.NewReadOnly(strings.Split(p[1:], ",")...)
 			} else if strings.HasPrefix(p, "c") {
 				args.conditions = append(args.conditions, strings.Split(p[1:], ",")...)
+			} else if strings.HasPrefix(p, "D") {
+				args.define, err = parseDefine(p[1:])
+				if err != nil {
+					return
+				}
+			} else if strings.HasPrefix(p, "f") {
+				args.format, args.globalName = utils.SplitByFirstByte(p[1:], ':')
 			} else {
 				switch p {
 				case "r":
@@ -114,6 +126,17 @@ func encodeBuildArgs(args BuildArgs, isDts bool) string {
 		if args.ignoreAnnotations {
 			lines = append(lines, "i")
 		}
+		if args.format != "" {
+			lines = append(lines, fmt.Sprintf("f%s:%s", args.format, args.globalName))
+		}
+		if len(args.define) > 0 {
+			var ss sort.StringSlice
+			for key, value := range args.define {
+				ss = append(ss, fmt.Sprintf("%s:%s", key, value))
+			}
+			ss.Sort()
+			lines = append(lines, fmt.Sprintf("D%s", strings.Join(ss, ",")))
+		}
 	}
 	if len(lines) > 0 {
 		return btoaUrl(strings.Join(lines, "\n"))
@@ -121,6 +144,42 @@ func encodeBuildArgs(args BuildArgs, isDts bool) string {
 	return ""
 }
 
+// parseDefine parses the `?define` query in format `KEY:JSON,...`, e.g. `__DEV__:false,process.env.API:"/api"`.
+// The values must be JSON literals and are re-encoded to prevent code injection.
+func parseDefine(s string) (define map[string]string, err error) {
+	define = map[string]string{}
+	for s != "" {
+		key, rest := utils.SplitByFirstByte(s, ':')
+		key = strings.TrimSpace(key)
+		if !isGlobalName(key) {
+			return nil, fmt.Errorf("invalid define key '%s'", key)
+		}
+		dec := json.NewDecoder(strings.NewReader(rest))
+		dec.UseNumber()
+		var value any
+		if err = dec.Decode(&value); err != nil {
+			return nil, fmt.Errorf("invalid define value of '%s'", key)
+		}
+		var data []byte
+		data, err = json.Marshal(value)
+		if err != nil {
+			return
+		}
+		define[key] = string(data)
+		s = strings.TrimSpace(rest[dec.InputOffset():])
+		if s != "" {
+			if s[0] != ',' {
+				return nil, errors.New("invalid define: unexpected '" + s[:1] + "'")
+			}
+			s = s[1:]
+		}
+	}
+	if len(define) > 32 {
+		return nil, errors.New("too many define keys")
+	}
+	return define, nil
+}
+
 // resolveBuildArgs resolves `alias`, `deps`, `external` of the build args
 func resolveBuildArgs(npmrc *NpmRC, installDir string, args *BuildArgs, esm EsmPath) error {
 	if len(args.alias) > 0 || len(args.deps) > 0 || args.external.Len() > 0 {
@@ -134,7 +193,7 @@ func resolveBuildArgs(npmrc *NpmRC, installDir string, args *BuildArgs, esm EsmP
 				if err == nil {
 					p = raw.ToNpmPackage()
 				}
-			} else if esm.GhPrefix || esm.PrPrefix {
+			} else if esm.GhPrefix || esm.GitPrefix || esm.PrPrefix {
 				p, err = npmrc.installPackage(esm.Package())
 			} else {
 				p, err = npmrc.getPackageInfo(esm.PkgName, esm.PkgVersion)
@@ -252,7 +311,7 @@ func walkDeps(npmrc *NpmRC, installDir string, pkg Package, mark *set.Set[string
 		if err == nil {
 			p = raw.ToNpmPackage()
 		}
-	} else if pkg.Github || pkg.PkgPrNew {
+	} else if pkg.Github || pkg.Git || pkg.PkgPrNew {
 		p, err = npmrc.installPackage(pkg)
 	} else {
 		p, err = npmrc.getPackageInfo(pkg.Name, pkg.Version)
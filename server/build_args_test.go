@@ -18,9 +18,12 @@ func TestEncodeBuildArgs(t *testing.T) {
 			},
 			external:          *set.NewReadOnly("baz", "bar"),
 			conditions:        conditions,
+			define:            map[string]string{"__DEV__": "false", "process.env.API": `"/a,b"`},
 			externalRequire:   true,
 			keepNames:         true,
 			ignoreAnnotations: true,
+			format:            "umd",
+			globalName:        "Foo.Bar",
 		},
 		false,
 	)
@@ -49,4 +52,31 @@ func TestEncodeBuildArgs(t *testing.T) {
 	if !args.ignoreAnnotations {
 		t.Fatal("ignoreAnnotations should be true")
 	}
+	if args.format != "umd" || args.globalName != "Foo.Bar" {
+		t.Fatal("invalid format")
+	}
+	if len(args.define) != 2 || args.define["__DEV__"] != "false" || args.define["process.env.API"] != `"/a,b"` {
+		t.Fatal("invalid define")
+	}
+}
+
+func TestParseDefine(t *testing.T) {
+	define, err := parseDefine(`__DEV__:false, process.env.API : "/api,v1" ,FLAGS:{"b":[1, 2],"a":null},N:1e3`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(define) != 4 || define["__DEV__"] != "false" || define["process.env.API"] != `"/api,v1"` || define["FLAGS"] != `{"a":null,"b":[1,2]}` || define["N"] != "1e3" {
+		t.Fatalf("unexpected define: %v", define)
+	}
+	for _, s := range []string{
+		`__DEV__:alert(1)`,
+		`__DEV__:false;alert(1)`,
+		`a-b:1`,
+		`foo:"bar"baz:1`,
+		`foo`,
+	} {
+		if _, err := parseDefine(s); err == nil {
+			t.Fatalf("parseDefine(%s) should fail", s)
+		}
+	}
 }
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	esbuild "github.com/evanw/esbuild/pkg/api"
+)
+
+// BuildError is a structured error of the build, it's returned as JSON for the clients that accept
+// `application/json` or request with the `?diagnostics` query.
+type BuildError struct {
+	Code        int          `json:"code,omitempty"`
+	Kind        string       `json:"kind"`
+	Message     string       `json:"message"`
+	Package     string       `json:"package,omitempty"`
+	Importer    string       `json:"importer,omitempty"`
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
+	Suggestions []string     `json:"suggestions,omitempty"`
+}
+
+// Diagnostic is a message reported by esbuild.
+type Diagnostic struct {
+	Text       string `json:"text"`
+	File       string `json:"file,omitempty"`
+	Line       int    `json:"line,omitempty"`
+	Column     int    `json:"column,omitempty"`
+	LineText   string `json:"lineText,omitempty"`
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
+func (e *BuildError) Error() string {
+	return e.Message
+}
+
+// newEsbuildError creates a build error with the esbuild error messages.
+func newEsbuildError(pkg string, messages []esbuild.Message) *BuildError {
+	e := &BuildError{
+		Kind:    "build",
+		Message: "esbuild: " + messages[0].Text,
+		Package: pkg,
+	}
+	for _, msg := range messages {
+		d := Diagnostic{Text: msg.Text}
+		if loc := msg.Location; loc != nil {
+			d.File = loc.File
+			d.Line = loc.Line
+			d.Column = loc.Column
+			d.LineText = loc.LineText
+			d.Suggestion = loc.Suggestion
+		}
+		e.Diagnostics = append(e.Diagnostics, d)
+	}
+	if loc := messages[0].Location; loc != nil {
+		e.Importer = loc.File
+	}
+	if name, ok := parseQuotedName(messages[0].Text, "Could not resolve "); ok {
+		e.Kind = "resolve"
+		e.Suggestions = getErrorSuggestions("resolve", toPackageName(name))
+	} else if strings.HasPrefix(messages[0].Text, "No matching export in ") {
+		e.Kind = "missing-export"
+		if file, ok := parseQuotedName(messages[0].Text, "No matching export in "); ok {
+			if i := strings.LastIndex(file, "node_modules/"); i >= 0 {
+				e.Suggestions = getErrorSuggestions("missing-export", toPackageName(file[i+13:]))
+			}
+		}
+	} else if strings.Contains(messages[0].Text, "the configured target environment") {
+		e.Kind = "unsupported-target"
+		e.Suggestions = getErrorSuggestions("unsupported-target", "")
+	}
+	return e
+}
+
+// toBuildError converts the error to a build error.
+func toBuildError(err error, pkg string) *BuildError {
+	var e *BuildError
+	if errors.As(err, &e) {
+		return e
+	}
+	msg := err.Error()
+	e = &BuildError{
+		Kind:    "build",
+		Message: msg,
+		Package: pkg,
+	}
+	if name, ok := parseQuotedName(msg, "could not resolve "); ok {
+		e.Kind = "resolve"
+		e.Suggestions = getErrorSuggestions("resolve", toPackageName(name))
+	} else if msg == "could not resolve build entry" || strings.HasSuffix(msg, " not found") || strings.Contains(msg, "is not exported from package") || strings.Contains(msg, "no such file or directory") {
+		e.Kind = "not-found"
+	} else if strings.HasPrefix(msg, "invalid") {
+		e.Kind = "invalid"
+	}
+	return e
+}
+
+// getErrorSuggestions returns the suggested fixes of the error kind.
+func getErrorSuggestions(kind string, name string) []string {
+	switch kind {
+	case "resolve":
+		if name != "" {
+			return []string{
+				fmt.Sprintf("try `?external=%s` to import \"%s\" from the import map", name, name),
+				fmt.Sprintf("use `?deps=%s@<version>` to specify the version of \"%s\"", name, name),
+			}
+		}
+	case "missing-export":
+		if name != "" {
+			return []string{
+				fmt.Sprintf("use `?deps=%s@<version>` to pin a version of \"%s\" that provides the export", name, name),
+			}
+		}
+	case "unsupported-target":
+		return []string{"try a newer build target, e.g. `?target=es2022`"}
+	case "unsupported-node-builtin-module":
+		return []string{"try `?target=denonext` or `?target=node` to use the native node builtin modules"}
+	case "unsupported-npm-package", "unsupported-file-dependency", "unsupported-git-dependency":
+		if name != "" {
+			return []string{
+				fmt.Sprintf("use `?deps=%s@<version>` to install \"%s\" from the npm registry", name, name),
+				fmt.Sprintf("use `?alias=%s:<package>` to replace \"%s\" with another package", name, name),
+			}
+		}
+	}
+	return nil
+}
+
+// parseQuotedName returns the quoted name after the prefix of the message,
+// e.g. `Could not resolve "react"` -> "react".
+func parseQuotedName(message string, prefix string) (string, bool) {
+	if !strings.HasPrefix(message, prefix+"\"") {
+		return "", false
+	}
+	name, _, ok := strings.Cut(message[len(prefix)+1:], "\"")
+	return name, ok && name != ""
+}
+
+// formatConsoleMessage formats the build error as a console message.
+func (e *BuildError) formatConsoleMessage() string {
+	var b strings.Builder
+	b.WriteString("[esm.sh] ")
+	b.WriteString(e.Message)
+	if e.Package != "" {
+		b.WriteString("\n  package: ")
+		b.WriteString(e.Package)
+	}
+	if e.Importer != "" {
+		b.WriteString("\n  importer: ")
+		b.WriteString(e.Importer)
+	}
+	for _, d := range e.Diagnostics {
+		b.WriteString("\n  ")
+		if d.File != "" {
+			fmt.Fprintf(&b, "%s:%d:%d: ", d.File, d.Line, d.Column)
+		}
+		b.WriteString(d.Text)
+		if d.LineText != "" {
+			b.WriteString("\n    ")
+			b.WriteString(strings.TrimSpace(d.LineText))
+		}
+	}
+	for _, s := range e.Suggestions {
+		b.WriteString("\n  hint: ")
+		b.WriteString(s)
+	}
+	return b.String()
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	esbuild "github.com/evanw/esbuild/pkg/api"
+)
+
+func TestBuildError(t *testing.T) {
+	e := newEsbuildError("foo@1.0.0", []esbuild.Message{
+		{
+			Text: `Could not resolve "bar/baz"`,
+			Location: &esbuild.Location{
+				File:     "node_modules/foo/index.js",
+				Line:     1,
+				Column:   20,
+				LineText: `import baz from "bar/baz";`,
+			},
+		},
+	})
+	if e.Kind != "resolve" {
+		t.Fatalf("expected kind 'resolve', got %q", e.Kind)
+	}
+	if e.Message != `esbuild: Could not resolve "bar/baz"` {
+		t.Fatalf("unexpected message %q", e.Message)
+	}
+	if e.Importer != "node_modules/foo/index.js" {
+		t.Fatalf("unexpected importer %q", e.Importer)
+	}
+	if len(e.Diagnostics) != 1 || e.Diagnostics[0].Line != 1 || e.Diagnostics[0].Column != 20 {
+		t.Fatalf("unexpected diagnostics %v", e.Diagnostics)
+	}
+	if len(e.Suggestions) != 2 || !strings.Contains(e.Suggestions[0], "?external=bar") {
+		t.Fatalf("unexpected suggestions %v", e.Suggestions)
+	}
+	msg := e.formatConsoleMessage()
+	if !strings.HasPrefix(msg, "[esm.sh] esbuild: Could not resolve") || !strings.Contains(msg, "node_modules/foo/index.js:1:20: ") {
+		t.Fatalf("unexpected console message %q", msg)
+	}
+
+	e = newEsbuildError("foo@1.0.0", []esbuild.Message{
+		{Text: `No matching export in "node_modules/@scope/bar/index.js" for import "qux"`},
+	})
+	if e.Kind != "missing-export" || len(e.Suggestions) != 1 || !strings.Contains(e.Suggestions[0], "?deps=@scope/bar@") {
+		t.Fatalf("unexpected error %v", e)
+	}
+
+	if e2 := toBuildError(fmt.Errorf("wrapped: %w", e), ""); e2 != e {
+		t.Fatalf("expected the wrapped build error")
+	}
+	if e2 := toBuildError(errors.New("invalid package name"), "foo"); e2.Kind != "invalid" || e2.Package != "foo" {
+		t.Fatalf("unexpected error %v", e2)
+	}
+	if e2 := toBuildError(errors.New("package 'foo' not found"), "foo"); e2.Kind != "not-found" {
+		t.Fatalf("unexpected error %v", e2)
+	}
+}
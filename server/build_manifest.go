@@ -0,0 +1,158 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/esm-dev/esm.sh/server/common"
+	"github.com/esm-dev/esm.sh/server/storage"
+)
+
+const manifestExt = ".manifest.json"
+
+var esbuildVersion = sync.OnceValue(func() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == "github.com/evanw/esbuild" {
+				if dep.Replace != nil {
+					return dep.Replace.Version
+				}
+				return dep.Version
+			}
+		}
+	}
+	return "unknown"
+})
+
+// saveManifest saves the provenance manifest of the build next to the build output, the manifest
+// is signed with the `signingKey` of the config if it's set. The shared chunks and the css that are
+// loaded by the build are recorded as well, so a verified module never loads unverified code.
+func (ctx *BuildContext) saveManifest(meta *BuildMeta) (err error) {
+	savePath := ctx.getSavepath()
+	output, err := ctx.readOutput(savePath)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			// no js output for the css/types-only builds
+			err = nil
+		}
+		return
+	}
+
+	var files []common.ManifestFile
+	var filePaths []string
+	if meta.CSSInJS {
+		filePaths = append(filePaths, strings.TrimSuffix(ctx.Path(), path.Ext(ctx.Path()))+".css")
+	}
+	for _, importPath := range meta.Imports {
+		if strings.HasPrefix(importPath, ctx.getPackageBuildDir()+"/") && strings.Contains(importPath, "/_chunks/") {
+			filePaths = append(filePaths, importPath)
+		}
+	}
+	for _, filePath := range filePaths {
+		data, err := ctx.readOutput(normalizeSavePath(ctx.npmrc.zoneId, path.Join("modules", filePath)))
+		if err != nil {
+			return err
+		}
+		files = append(files, common.ManifestFile{Path: filePath, Digest: common.Digest(data), Size: int64(len(data))})
+	}
+
+	pkg := common.ManifestPackage{
+		Name:    ctx.esm.PkgName,
+		Version: ctx.esm.PkgVersion,
+	}
+	if !ctx.esm.GhPrefix && !ctx.esm.GitPrefix && !ctx.esm.PrPrefix {
+		dist, err := ctx.npmrc.getInstalledPackageDist(ctx.esm.PkgName, ctx.esm.PkgVersion)
+		if err != nil {
+			return err
+		}
+		pkg.Tarball = dist.Tarball
+		pkg.Integrity = dist.Integrity
+	}
+
+	manifest := &common.BuildManifest{
+		Package:   pkg,
+		Esbuild:   esbuildVersion(),
+		Target:    ctx.target,
+		BuildArgs: ctx.getManifestBuildArgs(),
+		Path:      ctx.Path(),
+		Digest:    common.Digest(output),
+		Size:      int64(len(output)),
+		Files:     files,
+	}
+	data, err := common.SignManifest(manifest, config.SigningKey)
+	if err != nil {
+		return
+	}
+
+	err = ctx.storage.Put(savePath+manifestExt, bytes.NewReader(data))
+	if err != nil {
+		ctx.logger.Errorf("storage.put(%s): %v", savePath+manifestExt, err)
+		err = errors.New("storage: " + err.Error())
+	}
+	return
+}
+
+func (ctx *BuildContext) readOutput(savePath string) ([]byte, error) {
+	r, _, err := ctx.storage.Get(savePath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// getManifestBuildArgs returns the build args of the build in a readable form.
+func (ctx *BuildContext) getManifestBuildArgs() map[string]any {
+	args := map[string]any{}
+	if len(ctx.args.alias) > 0 {
+		args["alias"] = ctx.args.alias
+	}
+	if len(ctx.args.deps) > 0 {
+		args["deps"] = ctx.args.deps
+	}
+	if ctx.args.external.Len() > 0 {
+		external := ctx.args.external.Values()
+		sort.Strings(external)
+		args["external"] = external
+	}
+	if ctx.externalAll {
+		args["externalAll"] = true
+	}
+	if len(ctx.args.conditions) > 0 {
+		args["conditions"] = ctx.args.conditions
+	}
+	if len(ctx.args.define) > 0 {
+		args["define"] = ctx.args.define
+	}
+	if ctx.args.keepNames {
+		args["keepNames"] = true
+	}
+	if ctx.args.ignoreAnnotations {
+		args["ignoreAnnotations"] = true
+	}
+	if ctx.args.externalRequire {
+		args["externalRequire"] = true
+	}
+	if ctx.args.format != "" {
+		args["format"] = ctx.args.format
+	}
+	if ctx.args.globalName != "" {
+		args["globalName"] = ctx.args.globalName
+	}
+	switch ctx.bundleMode {
+	case BundleDeps:
+		args["bundle"] = true
+	case BundleFalse:
+		args["bundle"] = false
+	}
+	if ctx.dev {
+		args["dev"] = true
+	}
+	return args
+}
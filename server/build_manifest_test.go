@@ -0,0 +1,123 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"io"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/esm-dev/esm.sh/server/common"
+	"github.com/esm-dev/esm.sh/server/storage"
+)
+
+func TestBuildManifest(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	module := []byte("export default 42;\n")
+	manifest := &common.BuildManifest{
+		Package: common.ManifestPackage{
+			Name:      "foo",
+			Version:   "1.0.0",
+			Tarball:   "https://registry.npmjs.org/foo/-/foo-1.0.0.tgz",
+			Integrity: "sha512-xxx",
+		},
+		Esbuild:   esbuildVersion(),
+		Target:    "es2022",
+		BuildArgs: map[string]any{"deps": map[string]string{"bar": "2.0.0"}, "bundle": true},
+		Path:      "/foo@1.0.0/es2022/foo.mjs",
+		Digest:    common.Digest(module),
+		Size:      int64(len(module)),
+	}
+	data, err := common.SignManifest(manifest, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ret, err := common.VerifyManifest(data, publicKey, module)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret.Package.Integrity != "sha512-xxx" || ret.Path != manifest.Path {
+		t.Fatalf("unexpected manifest %v", ret)
+	}
+
+	// the module is modified
+	_, err = common.VerifyManifest(data, publicKey, []byte("export default 43;\n"))
+	if err == nil {
+		t.Fatal("expected digest mismatch")
+	}
+
+	// the manifest is modified
+	_, err = common.VerifyManifest(bytes.Replace(data, []byte("es2022"), []byte("es2023"), 1), publicKey, nil)
+	if err == nil || err.Error() != "bad signature" {
+		t.Fatalf("expected bad signature, got %v", err)
+	}
+
+	// signed by another key
+	otherKey, _, _ := ed25519.GenerateKey(nil)
+	_, err = common.VerifyManifest(data, otherKey, nil)
+	if err == nil {
+		t.Fatal("expected key mismatch")
+	}
+
+	// unsigned manifest
+	data, err = common.SignManifest(manifest, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = common.VerifyManifest(data, nil, module); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = common.VerifyManifest(data, publicKey, module); err == nil {
+		t.Fatal("expected unsigned manifest error")
+	}
+}
+
+func TestSaveManifestFiles(t *testing.T) {
+	workDir := config.WorkDir
+	defer func() { config.WorkDir = workDir }()
+	config.WorkDir = t.TempDir()
+
+	buildStorage, err := storage.New(&storage.StorageOptions{Type: "fs", Endpoint: path.Join(config.WorkDir, "storage")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := &BuildContext{npmrc: &NpmRC{}, storage: buildStorage, esm: EsmPath{PkgName: "app", PkgVersion: "1.0.0"}, target: "es2022", path: "/app@1.0.0/es2022/app.mjs"}
+	for filePath, content := range map[string]string{
+		"/app@1.0.0/es2022/app.mjs":         `import "/app@1.0.0/es2022/_chunks/abc.mjs";`,
+		"/app@1.0.0/es2022/app.css":         `a{color:red}`,
+		"/app@1.0.0/es2022/_chunks/abc.mjs": `export const a = 1;`,
+		"/react@19.0.0/es2022/react.mjs":    `export default {};`,
+	} {
+		buildStorage.Put(normalizeSavePath("", path.Join("modules", filePath)), strings.NewReader(content))
+	}
+
+	meta := &BuildMeta{CSSInJS: true, Imports: []string{"/app@1.0.0/es2022/_chunks/abc.mjs", "/react@19.0.0/es2022/react.mjs"}}
+	if err := ctx.saveManifest(meta); err != nil {
+		t.Fatal(err)
+	}
+	r, _, err := buildStorage.Get(ctx.getSavepath() + manifestExt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	manifest, err := common.VerifyManifest(data, nil, []byte(`import "/app@1.0.0/es2022/_chunks/abc.mjs";`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the imported packages have their own manifests
+	if len(manifest.Files) != 2 || manifest.Files[0].Path != "/app@1.0.0/es2022/app.css" || manifest.Files[1].Path != "/app@1.0.0/es2022/_chunks/abc.mjs" {
+		t.Fatalf("unexpected files %v", manifest.Files)
+	}
+	if err := common.VerifyManifestFile(manifest.Files[1], []byte(`export const a = 1;`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := common.VerifyManifestFile(manifest.Files[1], []byte(`export const a = 2;`)); err == nil {
+		t.Fatal("expected digest mismatch")
+	}
+}
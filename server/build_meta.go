@@ -5,6 +5,7 @@ import (
 	"errors"
 	"strings"
 
+	"github.com/esm-dev/esm.sh/server/common"
 	"github.com/ije/gox/utils"
 )
 
@@ -15,7 +16,11 @@ type BuildMeta struct {
 	ExportDefault bool
 	CSSEntry      string
 	Dts           string
+	StubDts       bool
 	Imports       []string
+	// the dependencies that are bundled into the build, they are checked with the current license policy
+	// and advisory database when the build is served
+	BundledDeps []*common.SBOMComponent
 }
 
 func encodeBuildMeta(meta *BuildMeta) []byte {
@@ -43,6 +48,9 @@ func encodeBuildMeta(meta *BuildMeta) []byte {
 		buf.WriteString(meta.Dts)
 		buf.WriteByte('\n')
 	}
+	if meta.StubDts {
+		buf.Write([]byte{'s', '\n'})
+	}
 	if len(meta.Imports) > 0 {
 		for _, path := range meta.Imports {
 			buf.Write([]byte{'i', ':'})
@@ -50,6 +58,15 @@ func encodeBuildMeta(meta *BuildMeta) []byte {
 			buf.WriteByte('\n')
 		}
 	}
+	for _, c := range meta.BundledDeps {
+		buf.Write([]byte{'b', ':'})
+		buf.WriteString(c.Name + "@" + c.Version)
+		if c.License != "" {
+			buf.WriteByte(' ')
+			buf.WriteString(c.License)
+		}
+		buf.WriteByte('\n')
+	}
 	return buf.Bytes()
 }
 
@@ -80,6 +97,8 @@ func decodeBuildMeta(data []byte) (*BuildMeta, error) {
 			meta.TypesOnly = true
 		case ll == 1 && line[0] == 'e':
 			meta.ExportDefault = true
+		case ll == 1 && line[0] == 's':
+			meta.StubDts = true
 		case ll > 2 && line[0] == '.' && line[1] == ':':
 			meta.CSSEntry = string(line[2:])
 		case ll > 2 && line[0] == 'd' && line[1] == ':':
@@ -96,6 +115,14 @@ func decodeBuildMeta(data []byte) (*BuildMeta, error) {
 				}
 			}
 			meta.Imports = append(meta.Imports, importSepcifier)
+		case ll > 2 && line[0] == 'b' && line[1] == ':':
+			// e.g. "b:lodash@4.17.21 MIT"
+			pkg, license := utils.SplitByFirstByte(string(line[2:]), ' ')
+			name, version := utils.SplitByLastByte(pkg, '@')
+			if name == "" || version == "" {
+				return nil, errors.New("invalid bundled dependency")
+			}
+			meta.BundledDeps = append(meta.BundledDeps, &common.SBOMComponent{Name: name, Version: version, License: license})
 		default:
 			return nil, errors.New("invalid build meta")
 		}
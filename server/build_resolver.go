@@ -234,7 +234,7 @@ func (ctx *BuildContext) resolveEntry(esm EsmPath) (entry BuildEntry) {
 		}
 
 		// lookup entry main from `src` directory
-		if entry.main == "" && esm.GhPrefix {
+		if entry.main == "" && (esm.GhPrefix || esm.GitPrefix) {
 			for _, ext := range []string{"mts", "ts", "mjs", "js", "tsx", "cts", "cjs"} {
 				isModule := ext != "cjs" && ext != "cts"
 				if filename := "./src/" + subModuleName + "/index." + ext; ctx.existsPkgFile(filename) {
@@ -336,7 +336,7 @@ func (ctx *BuildContext) resolveEntry(esm EsmPath) (entry BuildEntry) {
 		}
 
 		// lookup entry main from `src` directory
-		if entry.main == "" && esm.GhPrefix {
+		if entry.main == "" && (esm.GhPrefix || esm.GitPrefix) {
 			for _, ext := range []string{"mts", "ts", "mjs", "js", "tsx", "cts", "cjs"} {
 				filename := "./src/index." + ext
 				if ctx.existsPkgFile(filename) {
@@ -385,74 +385,8 @@ func (ctx *BuildContext) resolveEntry(esm EsmPath) (entry BuildEntry) {
 		}
 	}
 
-	// resolve types from `typesVersions` field if it's defined
-	// see https://www.typescriptlang.org/docs/handbook/declaration-files/publishing.html#version-selection-with-typesversions
-	if typesVersions := pkgJson.TypesVersions; len(typesVersions) > 0 && entry.types != "" {
-		versions := make(sort.StringSlice, len(typesVersions))
-		i := 0
-		for c := range typesVersions {
-			if strings.HasPrefix(c, ">") {
-				versions[i] = c
-				i++
-			}
-		}
-		versions = versions[:i]
-		if versions.Len() > 0 {
-			versions.Sort()
-			latestVersion := typesVersions[versions[versions.Len()-1]]
-			if mapping, ok := latestVersion.(map[string]any); ok {
-				var paths any
-				var matched bool
-				var exact bool
-				var suffix string
-				types := entry.types
-				paths, matched = mapping[entry.types]
-				if !matched {
-					// try to match the dts wihout leading "./"
-					paths, matched = mapping[strings.TrimPrefix(types, "./")]
-				}
-				if matched {
-					exact = true
-				}
-				if !matched {
-					for key, value := range mapping {
-						if strings.HasSuffix(key, "/*") {
-							key = normalizeEntryPath(key)
-							if strings.HasPrefix(types, strings.TrimSuffix(key, "/*")) {
-								paths = value
-								matched = true
-								suffix = strings.TrimPrefix(types, strings.TrimSuffix(key, "*"))
-								break
-							}
-						}
-					}
-				}
-				if !matched {
-					paths, matched = mapping["*"]
-				}
-				if matched {
-					if a, ok := paths.([]any); ok && len(a) > 0 {
-						if path, ok := a[0].(string); ok {
-							path = normalizeEntryPath(path)
-							if exact {
-								entry.types = path
-							} else {
-								prefix, _ := utils.SplitByLastByte(path, '*')
-								if suffix != "" {
-									entry.types = prefix + suffix
-								} else if strings.HasPrefix(types, prefix) {
-									diff := strings.TrimPrefix(types, prefix)
-									entry.types = strings.ReplaceAll(path, "*", diff)
-								} else {
-									entry.types = prefix + types[2:]
-								}
-							}
-						}
-					}
-				}
-			}
-		}
-	}
+	// resolve types from `exports` and `typesVersions` fields as TypeScript does
+	entry.types = ctx.resolveEntryTypes(esm.SubModuleName, entry.types)
 
 	ctx.finalizeBuildEntry(&entry)
 	return
@@ -700,6 +634,7 @@ func (ctx *BuildContext) resolveExternalModule(specifier string, kind api.Resolv
 			PkgName:    pkgJson.Name,
 			PkgVersion: pkgJson.Version,
 			GhPrefix:   ctx.esm.GhPrefix,
+			GitPrefix:  ctx.esm.GitPrefix,
 			PrPrefix:   ctx.esm.PrPrefix,
 		}, ctx.getBuildArgsPrefix(false), ctx.externalAll)
 		return
@@ -710,6 +645,7 @@ func (ctx *BuildContext) resolveExternalModule(specifier string, kind api.Resolv
 		subPath := strings.TrimPrefix(specifier, ctx.pkgJson.Name+"/")
 		subModule := EsmPath{
 			GhPrefix:      ctx.esm.GhPrefix,
+			GitPrefix:     ctx.esm.GitPrefix,
 			PrPrefix:      ctx.esm.PrPrefix,
 			PkgName:       ctx.esm.PkgName,
 			PkgVersion:    ctx.esm.PkgVersion,
@@ -780,6 +716,7 @@ func (ctx *BuildContext) resolveExternalModule(specifier string, kind api.Resolv
 	}
 	if p.Name != "" {
 		dep.GhPrefix = p.Github
+		dep.GitPrefix = p.Git
 		dep.PrPrefix = p.PkgPrNew
 		dep.PkgName = p.Name
 		dep.PkgVersion = p.Version
@@ -800,6 +737,18 @@ func (ctx *BuildContext) resolveExternalModule(specifier string, kind api.Resolv
 		}
 	}
 
+	// resolve the ref of the git repository
+	if dep.GitPrefix {
+		gitHost, repo, _, e := validateGitPackageName(dep.PkgName)
+		if e != nil {
+			return specifier, e
+		}
+		dep.PkgVersion, _, err = resolveGitVersion(gitHost, repo, dep.PkgVersion)
+		if err != nil {
+			return
+		}
+	}
+
 	// [workaround] force the dependency version of `react` equals to react-dom
 	if ctx.esm.PkgName == "react-dom" && dep.PkgName == "react" {
 		dep.PkgVersion = ctx.esm.PkgVersion
@@ -830,6 +779,7 @@ func (ctx *BuildContext) resolveExternalModule(specifier string, kind api.Resolv
 		deps:       ctx.args.deps,
 		external:   ctx.args.external,
 		conditions: ctx.args.conditions,
+		define:     ctx.args.define,
 	}
 	err = resolveBuildArgs(ctx.npmrc, ctx.wd, &args, dep)
 	if err != nil {
@@ -837,7 +787,7 @@ func (ctx *BuildContext) resolveExternalModule(specifier string, kind api.Resolv
 	}
 
 	var exactVersion bool
-	if dep.GhPrefix {
+	if dep.GhPrefix || dep.GitPrefix {
 		exactVersion = isCommitish(dep.PkgVersion) || isExactVersion(strings.TrimPrefix(dep.PkgVersion, "v"))
 	} else if dep.PrPrefix {
 		exactVersion = true
@@ -1014,6 +964,27 @@ func (ctx *BuildContext) getSavepath() string {
 	return normalizeSavePath(ctx.npmrc.zoneId, path.Join("modules", ctx.Path()))
 }
 
+// getPackageBuildDir returns the build directory of the package, e.g. "/react@19.0.0"
+func (ctx *BuildContext) getPackageBuildDir() string {
+	if ctx.externalAll {
+		return "/*" + ctx.esm.Name()
+	}
+	return "/" + ctx.esm.Name()
+}
+
+// getWasmURL returns the raw file url of the wasm file that is loaded with streaming compilation,
+// an empty string is returned if the wasm file should be embedded in the module.
+func (ctx *BuildContext) getWasmURL(filename string) string {
+	if ctx.args.format != "" || !supportsTopLevelAwait(ctx.target) {
+		return ""
+	}
+	pkgDir := path.Join(ctx.wd, "node_modules", ctx.esm.PkgName)
+	if !strings.HasPrefix(filename, pkgDir+"/") {
+		return ""
+	}
+	return "/" + ctx.esm.Name() + strings.TrimPrefix(filename, pkgDir)
+}
+
 func (ctx *BuildContext) getBuildArgsPrefix(isDts bool) string {
 	if a := encodeBuildArgs(ctx.args, isDts); a != "" {
 		return "X-" + a + "/"
@@ -1033,7 +1004,35 @@ func (ctx *BuildContext) isDenoTarget() bool {
 }
 
 func (ctx *BuildContext) isBrowserTarget() bool {
-	return strings.HasPrefix(ctx.target, "es")
+	return strings.HasPrefix(ctx.target, "es") || getBuildEngines(ctx.target) != nil
+}
+
+// getNodePolyfills returns the nodejs polyfills module that is injected into the `iife`, `umd` and `cjs` builds.
+func (ctx *BuildContext) getNodePolyfills() string {
+	isBrowserExcluded := func(name string) bool {
+		if v, ok := ctx.pkgJson.Browser[name]; ok {
+			return v == ""
+		}
+		if v, ok := ctx.pkgJson.Browser["node:"+name]; ok {
+			return v == ""
+		}
+		return false
+	}
+	var buf strings.Builder
+	if ctx.isBrowserTarget() && !isBrowserExcluded("process") {
+		buf.WriteString(`export { default as __Process$ } from "node:process";`)
+	} else {
+		buf.WriteString(`export var __Process$ = globalThis.process;`)
+	}
+	if ctx.isBrowserTarget() && !isBrowserExcluded("buffer") {
+		buf.WriteString(`export { Buffer as __Buffer$ } from "node:buffer";`)
+	} else {
+		buf.WriteString(`export var __Buffer$ = globalThis.Buffer;`)
+	}
+	buf.WriteString(`export var __setImmediate$ = (cb, ...args) => ( { $t: setTimeout(cb, 0, ...args), [Symbol.dispose](){ clearTimeout(this.t) } });`)
+	buf.WriteString(`export var __clearImmediate$ = i => clearTimeout(i.$t);`)
+	buf.WriteString(`export var __rResolve$ = p => p;`)
+	return buf.String()
 }
 
 func (ctx *BuildContext) existsPkgFile(fp ...string) bool {
@@ -1282,6 +1281,60 @@ func isNodeBuiltInModule(specifier string) bool {
 	return strings.HasPrefix(specifier, "node:") && nodeBuiltinModules[specifier[5:]]
 }
 
+// toGlobalName converts the module specifier to a global variable name,
+// e.g. "react-dom/client" -> "ReactDomClient", "@preact/signals" -> "PreactSignals".
+func toGlobalName(specifier string) string {
+	var sb strings.Builder
+	upper := true
+	for _, c := range specifier {
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') {
+			if sb.Len() == 0 && c >= '0' && c <= '9' {
+				sb.WriteByte('_')
+			}
+			if upper && c >= 'a' && c <= 'z' {
+				c -= 'a' - 'A'
+			}
+			sb.WriteRune(c)
+			upper = false
+		} else {
+			upper = true
+		}
+	}
+	if sb.Len() == 0 {
+		return "Module"
+	}
+	return sb.String()
+}
+
+// isGlobalName returns true if the given string is a valid global variable name,
+// the dot-separated path like "MyLib.utils" is allowed.
+func isGlobalName(s string) bool {
+	for _, part := range strings.Split(s, ".") {
+		if !isJsIdentifier(part) {
+			return false
+		}
+	}
+	return true
+}
+
+// getUMDHeader returns the umd header that wraps the iife build, the factory function returns the exports.
+func getUMDHeader(globalName string) string {
+	root := "r"
+	assign := ""
+	parts := strings.Split(globalName, ".")
+	for i, part := range parts {
+		root += "." + part
+		if i < len(parts)-1 {
+			assign += root + "=" + root + "||{};"
+		}
+	}
+	return fmt.Sprintf(
+		`(function(r,f){if(typeof exports=="object"&&typeof module<"u")module.exports=f();else if(typeof define=="function"&&define.amd)define([],f);else{%s%s=f()}})(typeof globalThis<"u"?globalThis:typeof self<"u"?self:this,function(){`+"\n",
+		assign,
+		root,
+	)
+}
+
 // isCommitish returns true if the given string is a commit hash.
 func isCommitish(s string) bool {
 	return len(s) >= 7 && len(s) <= 40 && valid.IsHexString(s)
@@ -15,7 +15,7 @@ var (
 func (ctx *BuildContext) rewriteJS(in []byte) (out []byte, dropSourceMap bool) {
 	switch ctx.esm.PkgName {
 	case "axios", "cross-fetch", "whatwg-fetch":
-		if ctx.isDenoTarget() {
+		if ctx.isDenoTarget() && ctx.args.format == "" {
 			xhr := []byte("\nimport \"https://deno.land/x/xhr@0.3.0/mod.ts\";")
 			return concatBytes(in, xhr), false
 		}
@@ -1,14 +1,36 @@
 package server
 
 import (
+	"bytes"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/Masterminds/semver/v3"
 	esbuild "github.com/evanw/esbuild/pkg/api"
+	"github.com/ije/esbuild-internal/compat"
 )
 
 var v1_33_2 = semver.MustParse("1.33.2")
 
+var regexpEngineTarget = regexp.MustCompile(`^([a-z_]+?)\s*(?:>=)?\s*(\d+)(?:\.(\d+))?(?:\.\d+)*$`)
+
+// engine names of the browserslist queries
+var engineAliases = map[string]string{
+	"chrome":  "chrome",
+	"and_chr": "chrome",
+	"edge":    "edge",
+	"firefox": "firefox",
+	"ff":      "firefox",
+	"and_ff":  "firefox",
+	"ios":     "ios",
+	"ios_saf": "ios",
+	"opera":   "opera",
+	"safari":  "safari",
+}
+
 var targets = map[string]esbuild.Target{
 	"es2015":   esbuild.ES2015,
 	"es2016":   esbuild.ES2016,
@@ -43,5 +65,150 @@ func getBuildTargetByUA(ua string) string {
 	if ua == "undici" || strings.HasPrefix(ua, "Node.js/") || strings.HasPrefix(ua, "Node/") || strings.HasPrefix(ua, "Bun/") {
 		return "node"
 	}
+	// map browsers to the nearest es target to keep the cache small,
+	// the engine targets are only used with the `?target` query
+	name, version := getBrowserInfo(ua)
+	if name != "" && version != "" {
+		if engine, ok := browsers[strings.ToLower(name)]; ok {
+			return getESTargetByEngine(esbuild.Engine{Name: engine, Version: version})
+		}
+	}
 	return "es2022"
 }
+
+// getESTargetByEngine returns the highest es target whose syntax is fully supported by the engine.
+func getESTargetByEngine(engine esbuild.Engine) string {
+	// drop the patch and build numbers, e.g. "100.0.4896.127" -> "100.0"
+	if parts := strings.SplitN(engine.Version, ".", 3); len(parts) == 3 {
+		engine.Version = parts[0] + "." + parts[1]
+	}
+	unsupported := getUnsupportedEngineFeatures(engine) & esFeatures
+	if unsupported == 0 {
+		return "esnext"
+	}
+	for year := 2024; year > 2015; year-- {
+		if unsupported&^getUnsupportedESFeatures(year) == 0 {
+			return "es" + strconv.Itoa(year)
+		}
+	}
+	return "es2015"
+}
+
+// esFeatures is the set of the syntax features that can be lowered by esbuild, the features that are
+// not in any es version (e.g. decorators) and the regexp features (which esbuild can't lower) are ignored.
+var esFeatures = getUnsupportedESFeatures(5) &^ (compat.RegexpDotAllFlag | compat.RegexpLookbehindAssertions |
+	compat.RegexpMatchIndices | compat.RegexpNamedCaptureGroups | compat.RegexpSetNotation |
+	compat.RegexpStickyAndUnicodeFlags | compat.RegexpUnicodePropertyEscapes)
+
+func getUnsupportedESFeatures(year int) compat.JSFeature {
+	return compat.UnsupportedJSFeatures(map[compat.Engine]compat.Semver{compat.ES: {Parts: []int{year}}})
+}
+
+// normalizeBuildTarget normalizes the build target, returns an empty string if the target is invalid.
+// Apart from the `targets`, a list of browser engines is accepted, which can be either the esbuild style
+// or the browserslist style, e.g. "chrome100,safari15.4" or "Chrome >= 100, ios_saf 15.4".
+// The engines are sorted and the lowest version of each engine is used, e.g. "safari15.4,chrome100".
+func normalizeBuildTarget(target string) string {
+	target = strings.ToLower(strings.TrimSpace(target))
+	if _, ok := targets[target]; ok {
+		return target
+	}
+	if target == "" {
+		return ""
+	}
+	versions := map[string][2]int{}
+	for _, p := range strings.Split(target, ",") {
+		m := regexpEngineTarget.FindStringSubmatch(strings.TrimSpace(p))
+		if m == nil {
+			return ""
+		}
+		name, ok := engineAliases[m[1]]
+		if !ok {
+			return ""
+		}
+		major, _ := strconv.Atoi(m[2])
+		minor, _ := strconv.Atoi(m[3])
+		if major == 0 {
+			return ""
+		}
+		// only safari has minor releases with new language features
+		if name != "safari" && name != "ios" {
+			minor = 0
+		}
+		if v, ok := versions[name]; !ok || major < v[0] || (major == v[0] && minor < v[1]) {
+			versions[name] = [2]int{major, minor}
+		}
+	}
+	engines := make([]string, 0, len(versions))
+	for name, v := range versions {
+		engine := name + strconv.Itoa(v[0])
+		if v[1] > 0 {
+			engine += "." + strconv.Itoa(v[1])
+		}
+		engines = append(engines, engine)
+	}
+	sort.Strings(engines)
+	return strings.Join(engines, ",")
+}
+
+// isBuildTarget checks if the given string is a valid build target in the pathname.
+func isBuildTarget(target string) bool {
+	if _, ok := targets[target]; ok {
+		return true
+	}
+	return target != "" && normalizeBuildTarget(target) == target
+}
+
+// getBuildEngines returns the esbuild engines of the normalized engine target.
+func getBuildEngines(target string) []esbuild.Engine {
+	if _, ok := targets[target]; ok {
+		return nil
+	}
+	var engines []esbuild.Engine
+	for _, p := range strings.Split(target, ",") {
+		m := regexpEngineTarget.FindStringSubmatch(p)
+		if m == nil {
+			continue
+		}
+		version := m[2]
+		if m[3] != "" {
+			version += "." + m[3]
+		}
+		engines = append(engines, esbuild.Engine{Name: browsers[m[1]], Version: version})
+	}
+	return engines
+}
+
+// the top-level await support of the build targets
+var topLevelAwaitSupports sync.Map
+
+// supportsTopLevelAwait checks if the build target supports top-level await.
+func supportsTopLevelAwait(target string) bool {
+	if v, ok := topLevelAwaitSupports.Load(target); ok {
+		return v.(bool)
+	}
+	opts := esbuild.TransformOptions{Format: esbuild.FormatESModule}
+	if t, ok := targets[target]; ok {
+		opts.Target = t
+	} else {
+		opts.Engines = getBuildEngines(target)
+	}
+	ok := len(esbuild.Transform("await 0", opts).Errors) == 0
+	topLevelAwaitSupports.Store(target, ok)
+	return ok
+}
+
+// supportsSourcePhaseImports checks if the build target supports the source phase imports proposal,
+// e.g. `import source mod from "./mod.wasm"`.
+func supportsSourcePhaseImports(target string) bool {
+	return target == "denonext"
+}
+
+// rewriteWasmSourceImports rewrites the `wasm-source:` imports that are marked as external in the build
+// to the source phase imports, since esbuild doesn't support the syntax.
+func rewriteWasmSourceImports(js []byte) []byte {
+	if !bytes.Contains(js, []byte(`"wasm-source:`)) {
+		return js
+	}
+	return regexpWasmSourceImport.ReplaceAll(js, []byte(`import source $1 from "`))
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	esbuild "github.com/evanw/esbuild/pkg/api"
+)
+
+func TestNormalizeBuildTarget(t *testing.T) {
+	for input, expected := range map[string]string{
+		"es2022":                    "es2022",
+		"ESNext":                    "esnext",
+		"denonext":                  "denonext",
+		"chrome100":                 "chrome100",
+		"chrome100.0.4896":          "chrome100",
+		"safari15.4,chrome100":      "chrome100,safari15.4",
+		"Chrome >= 100, ios_saf 15": "chrome100,ios15",
+		"safari 16, safari 15.4":    "safari15.4",
+		"and_chr 120,ff 115":        "chrome120,firefox115",
+		"":                          "",
+		"es2099":                    "",
+		"ie11":                      "",
+		"chrome":                    "",
+		"chrome0":                   "",
+	} {
+		if ret := normalizeBuildTarget(input); ret != expected {
+			t.Fatalf("normalizeBuildTarget(%q): expected %q, got %q", input, expected, ret)
+		}
+	}
+
+	if !isBuildTarget("chrome100,safari15.4") || isBuildTarget("safari15.4,chrome100") || isBuildTarget("chrome 100") {
+		t.Fatal("isBuildTarget should only accept normalized targets")
+	}
+
+	engines := getBuildEngines("chrome100,safari15.4")
+	if len(engines) != 2 || engines[0].Version != "100" || engines[1].Version != "15.4" {
+		t.Fatalf("unexpected engines: %v", engines)
+	}
+}
+
+func TestGetBuildTargetByUA(t *testing.T) {
+	for ua, expected := range map[string]string{
+		"ES/2020":     "es2020",
+		"Deno/2.1.0":  "denonext",
+		"Node/22.0.0": "node",
+		"curl/8.0.0":  "es2022",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/15.4 Safari/605.1.15":                   "es2021",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/100.0.4896.127 Safari/537.36":                    "es2024",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 14_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/14.0 Mobile/15E148 Safari/604.1": "es2019",
+	} {
+		if ret := getBuildTargetByUA(ua); ret != expected {
+			t.Fatalf("getBuildTargetByUA(%q): expected %q, got %q", ua, expected, ret)
+		}
+	}
+}
+
+func TestSupportsTopLevelAwait(t *testing.T) {
+	for target, expected := range map[string]bool{
+		"es2020":     false,
+		"es2022":     true,
+		"esnext":     true,
+		"denonext":   true,
+		"chrome88":   false,
+		"chrome100":  true,
+		"safari15":   true,
+		"safari14.1": false,
+	} {
+		if ret := supportsTopLevelAwait(target); ret != expected {
+			t.Fatalf("supportsTopLevelAwait(%q): expected %v, got %v", target, expected, ret)
+		}
+	}
+}
+
+func TestRewriteWasmSourceImports(t *testing.T) {
+	for _, minify := range []bool{false, true} {
+		res := esbuild.Build(esbuild.BuildOptions{
+			Stdin: &esbuild.StdinOptions{
+				Contents: `import mod from "./mod.wasm"; export const instance = await WebAssembly.instantiate(mod);`,
+			},
+			Bundle:            true,
+			Format:            esbuild.FormatESModule,
+			Target:            esbuild.ESNext,
+			MinifyWhitespace:  minify,
+			MinifyIdentifiers: minify,
+			MinifySyntax:      minify,
+			Write:             false,
+			Plugins: []esbuild.Plugin{{
+				Name: "wasm",
+				Setup: func(build esbuild.PluginBuild) {
+					build.OnResolve(esbuild.OnResolveOptions{Filter: ".*"}, func(args esbuild.OnResolveArgs) (esbuild.OnResolveResult, error) {
+						if strings.HasPrefix(args.Path, "wasm-source:") {
+							return esbuild.OnResolveResult{Path: args.Path, External: true}, nil
+						}
+						return esbuild.OnResolveResult{Path: args.Path, Namespace: "wasm"}, nil
+					})
+					build.OnLoad(esbuild.OnLoadOptions{Filter: ".*", Namespace: "wasm"}, func(args esbuild.OnLoadArgs) (esbuild.OnLoadResult, error) {
+						code := `import mod from "wasm-source:https://esm.sh/pkg@1.0.0/mod.wasm";export default mod;`
+						return esbuild.OnLoadResult{Contents: &code, Loader: esbuild.LoaderJS}, nil
+					})
+				},
+			}},
+		})
+		if len(res.Errors) > 0 {
+			t.Fatal(res.Errors[0].Text)
+		}
+		js := string(rewriteWasmSourceImports(res.OutputFiles[0].Contents))
+		if strings.Contains(js, "wasm-source:") || !regexp.MustCompile(`import source [\w$]+ from "https://esm.sh/pkg@1.0.0/mod.wasm"`).MatchString(js) {
+			t.Fatalf("unexpected output (minify=%v): %s", minify, js)
+		}
+	}
+}
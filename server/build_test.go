@@ -0,0 +1,200 @@
+package server
+
+import (
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/esm-dev/esm.sh/server/storage"
+	"github.com/ije/gox/log"
+)
+
+// newTestBuildContext returns a build context of the package installed in the store with the files, the package
+// is built offline with the bolt database and the fs storage in the temporary work directory.
+func newTestBuildContext(t *testing.T, pkgName string, files map[string]string) *BuildContext {
+	workDir := config.WorkDir
+	offline := config.Offline
+	t.Cleanup(func() {
+		config.WorkDir = workDir
+		config.Offline = offline
+	})
+	config.WorkDir = t.TempDir()
+	config.Offline = true
+
+	npmrc := &NpmRC{}
+	dir := path.Join(npmrc.StoreDir(), pkgName+"@1.0.0", "node_modules", pkgName)
+	for filename, content := range files {
+		os.MkdirAll(path.Dir(path.Join(dir, filename)), 0755)
+		os.WriteFile(path.Join(dir, filename), []byte(content), 0644)
+	}
+	db, err := OpenBoltDB(path.Join(config.WorkDir, "esm.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	buildStorage, err := storage.New(&storage.StorageOptions{Type: "fs", Endpoint: path.Join(config.WorkDir, "storage")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &BuildContext{
+		npmrc:   npmrc,
+		logger:  &log.Logger{},
+		db:      db,
+		storage: buildStorage,
+		esm:     EsmPath{PkgName: pkgName, PkgVersion: "1.0.0"},
+		target:  "es2022",
+	}
+}
+
+// readTestBuild reads the build file from the storage of the context.
+func readTestBuild(t *testing.T, ctx *BuildContext, filePath string) string {
+	r, _, err := ctx.storage.Get(normalizeSavePath(ctx.npmrc.zoneId, path.Join("modules", filePath)))
+	if err != nil {
+		t.Fatalf("%s: %v", filePath, err)
+	}
+	defer r.Close()
+	data, _ := io.ReadAll(r)
+	return string(data)
+}
+
+func TestBuildSplitEntries(t *testing.T) {
+	ctx := newTestBuildContext(t, "split-pkg", map[string]string{
+		"package.json": `{"name":"split-pkg","version":"1.0.0","type":"module","exports":{".":"./index.js","./a":"./a.js","./b":"./b.js"}}`,
+		"index.js":     `export const name = "split-pkg";`,
+		"a.js":         `import { shared } from "./shared.js"; export const a = shared("a");`,
+		"b.js":         `import { shared } from "./shared.js"; export const b = shared("b");`,
+		"shared.js":    `export function shared(s) { return "shared:" + s; }`,
+	})
+	ctx.esm.SubPath = "a"
+	ctx.esm.SubModuleName = "a"
+	ctx.bundleMode = BundleDeps
+
+	meta, err := ctx.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Path() != "/split-pkg@1.0.0/es2022/a.bundle.mjs" {
+		t.Fatalf("unexpected build path %s", ctx.Path())
+	}
+	if len(meta.Imports) != 1 || !strings.HasPrefix(meta.Imports[0], "/split-pkg@1.0.0/es2022/_chunks/") {
+		t.Fatalf("the entry should import the shared chunk, got %v", meta.Imports)
+	}
+	if code := readTestBuild(t, ctx, ctx.Path()); !strings.Contains(code, "/_chunks/"+path.Base(meta.Imports[0])) || strings.Contains(code, "shared:") {
+		t.Fatalf("unexpected output of the entry: %s", code)
+	}
+	if code := readTestBuild(t, ctx, meta.Imports[0]); !strings.Contains(code, "shared:") {
+		t.Fatalf("unexpected output of the shared chunk: %s", code)
+	}
+	// the other entries are saved by their own builds
+	if _, err := ctx.storage.Stat(normalizeSavePath(ctx.npmrc.zoneId, "modules/split-pkg@1.0.0/es2022/b.bundle.mjs")); err != storage.ErrNotFound {
+		t.Fatalf("the entry b should not be saved, got %v", err)
+	}
+}
+
+func TestBuildSplitEntriesFallback(t *testing.T) {
+	ctx := newTestBuildContext(t, "split-broken", map[string]string{
+		"package.json": `{"name":"split-broken","version":"1.0.0","type":"module","exports":{"./a":"./a.js","./b":"./b.js"}}`,
+		"a.js":         `import { shared } from "./shared.js"; export const a = shared("a");`,
+		"b.js":         `import { broken } from "./broken.js"; export const b = broken;`,
+		"broken.js":    `export const broken = ;`,
+		"shared.js":    `export function shared(s) { return "shared:" + s; }`,
+	})
+	ctx.esm.SubPath = "a"
+	ctx.esm.SubModuleName = "a"
+	ctx.bundleMode = BundleDeps
+
+	// the broken entry b must not break the build of the entry a
+	meta, err := ctx.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(meta.Imports) != 0 {
+		t.Fatalf("the entry should be built alone, got %v", meta.Imports)
+	}
+	if code := readTestBuild(t, ctx, ctx.Path()); !strings.Contains(code, "shared:") {
+		t.Fatalf("unexpected output of the entry: %s", code)
+	}
+	if _, ok := noSplitBuildDirs.Load(ctx.npmrc.zoneId + ":" + ctx.getPackageBuildDir()); !ok {
+		t.Fatal("the package should not be split again")
+	}
+}
+
+// the files of the package that imports a dependency and a peer dependency for the `iife`, `umd` and `cjs` builds
+var testFormatPkgFiles = map[string]string{
+	"package.json":             `{"name":"fmt-pkg","version":"1.0.0","type":"module","main":"index.js","dependencies":{"fmt-dep":"1.0.0"},"peerDependencies":{"fmt-peer":"1.0.0"}}`,
+	"index.js":                 `import { dep } from "fmt-dep"; import peer from "fmt-peer"; export const hello = dep + peer;`,
+	"../fmt-dep/package.json":  `{"name":"fmt-dep","version":"1.0.0","type":"module","main":"index.js"}`,
+	"../fmt-dep/index.js":      `export const dep = "fmt-dep:";`,
+	"../fmt-peer/package.json": `{"name":"fmt-peer","version":"1.0.0","type":"module","main":"index.js"}`,
+	"../fmt-peer/index.js":     `export default "fmt-peer";`,
+}
+
+func TestBuildFormatIIFE(t *testing.T) {
+	for _, format := range []string{"iife", "umd"} {
+		ctx := newTestBuildContext(t, "fmt-pkg", testFormatPkgFiles)
+		ctx.bundleMode = BundleDeps
+		ctx.args = BuildArgs{format: format, globalName: "Fmt.Pkg"}
+
+		meta, err := ctx.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ctx.Path() != "/fmt-pkg@1.0.0/X-"+encodeBuildArgs(ctx.args, false)+"/es2022/fmt-pkg."+format+".mjs" {
+			t.Fatalf("unexpected build path %s", ctx.Path())
+		}
+		if len(meta.Imports) != 0 {
+			t.Fatalf("the %s build should bundle all dependencies, got %v", format, meta.Imports)
+		}
+		code := readTestBuild(t, ctx, ctx.Path())
+		// the dependencies and peer dependencies are bundled
+		if strings.Contains(code, "import ") || !strings.Contains(code, `"fmt-dep:"`) || !strings.Contains(code, `"fmt-peer"`) {
+			t.Fatalf("unexpected output of the %s build: %s", format, code)
+		}
+		if format == "iife" && !strings.Contains(code, "var Fmt;(Fmt||={}).Pkg=(()=>{") {
+			t.Fatalf("the iife build should assign the global name: %s", code)
+		}
+		if format == "umd" && (!strings.Contains(code, "r.Fmt=r.Fmt||{};r.Fmt.Pkg=f()") || !strings.Contains(code, "return __exports$;\n});")) {
+			t.Fatalf("the umd build should be wrapped with the umd header and footer: %s", code)
+		}
+	}
+}
+
+func TestBuildFormatCJS(t *testing.T) {
+	ctx := newTestBuildContext(t, "fmt-pkg", testFormatPkgFiles)
+	ctx.bundleMode = BundleDeps
+	ctx.args = BuildArgs{format: "cjs"}
+
+	meta, err := ctx.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Path() != "/fmt-pkg@1.0.0/X-"+encodeBuildArgs(ctx.args, false)+"/es2022/fmt-pkg.cjs" {
+		t.Fatalf("unexpected build path %s", ctx.Path())
+	}
+	if len(meta.Imports) != 0 {
+		t.Fatalf("the cjs build should bundle all dependencies, got %v", meta.Imports)
+	}
+	code := readTestBuild(t, ctx, ctx.Path())
+	if strings.Contains(code, "import ") || strings.Contains(code, "require(") || !strings.Contains(code, `"fmt-dep:"`) || !strings.Contains(code, `"fmt-peer"`) {
+		t.Fatalf("unexpected output of the cjs build: %s", code)
+	}
+	if !strings.Contains(code, "module.exports=") {
+		t.Fatalf("the cjs build should assign the module.exports: %s", code)
+	}
+
+	// the default export is used as the `module.exports` if the module only exports default
+	ctx = newTestBuildContext(t, "fmt-default", map[string]string{
+		"package.json": `{"name":"fmt-default","version":"1.0.0","type":"module","main":"index.js"}`,
+		"index.js":     `export default function hello() { return "hello"; }`,
+	})
+	ctx.bundleMode = BundleDeps
+	ctx.args = BuildArgs{format: "cjs"}
+	if _, err = ctx.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if code := readTestBuild(t, ctx, ctx.Path()); !strings.Contains(code, `module.exports=module.exports.default;`) {
+		t.Fatalf("the default export should be used as the module.exports: %s", code)
+	}
+}
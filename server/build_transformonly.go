@@ -3,6 +3,7 @@ package server
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"regexp"
 	"strings"
 
@@ -20,6 +21,8 @@ type TransformOptions struct {
 	Target          string          `json:"target"`
 	SourceMap       string          `json:"sourceMap"`
 	Minify          bool            `json:"minify"`
+	// emit the declarations of the typescript code, it's opt-in since the emitter loads typescript
+	Dts bool `json:"dts"`
 }
 
 type ResolvedTransformOptions struct {
@@ -31,6 +34,7 @@ type ResolvedTransformOptions struct {
 type TransformOutput struct {
 	Code string `json:"code"`
 	Map  string `json:"map"`
+	Dts  string `json:"dts"`
 }
 
 func transform(options *ResolvedTransformOptions) (out *TransformOutput, err error) {
@@ -106,6 +110,10 @@ func transform(options *ResolvedTransformOptions) (out *TransformOutput, err err
 	if filename == "" {
 		filename = "source." + options.Lang
 	}
+	if loader == esbuild.LoaderCSS && strings.HasSuffix(filename, ".module.css") {
+		return transformCSSModule(filename, sourceCode, target, options.Minify)
+	}
+
 	stdin := &esbuild.StdinOptions{
 		Sourcefile: filename,
 		Contents:   sourceCode,
@@ -157,6 +165,62 @@ func transform(options *ResolvedTransformOptions) (out *TransformOutput, err err
 	return
 }
 
+// transformCSSModule compiles the css module into a js module that exports the class names,
+// the css is injected into the document when the module is imported.
+func transformCSSModule(filename string, code string, target esbuild.Target, minify bool) (out *TransformOutput, err error) {
+	specifier := string(utils.MustEncodeJSON(filename))
+	ret := esbuild.Build(esbuild.BuildOptions{
+		Stdin: &esbuild.StdinOptions{
+			Contents: fmt.Sprintf(`export { default } from %s;`, specifier),
+			Loader:   esbuild.LoaderJS,
+		},
+		Platform:          esbuild.PlatformBrowser,
+		Format:            esbuild.FormatESModule,
+		Target:            target,
+		MinifyWhitespace:  minify,
+		MinifySyntax:      minify,
+		MinifyIdentifiers: minify,
+		Bundle:            true,
+		Outdir:            "/esbuild",
+		Write:             false,
+		Plugins: []esbuild.Plugin{
+			{
+				Name: "css-module",
+				Setup: func(build esbuild.PluginBuild) {
+					build.OnResolve(esbuild.OnResolveOptions{Filter: ".*"}, func(args esbuild.OnResolveArgs) (esbuild.OnResolveResult, error) {
+						return esbuild.OnResolveResult{Path: args.Path, Namespace: "css-module"}, nil
+					})
+					build.OnLoad(esbuild.OnLoadOptions{Filter: ".*", Namespace: "css-module"}, func(args esbuild.OnLoadArgs) (esbuild.OnLoadResult, error) {
+						return esbuild.OnLoadResult{Contents: &code, Loader: esbuild.LoaderLocalCSS}, nil
+					})
+				},
+			},
+		},
+	})
+	if len(ret.Errors) > 0 {
+		err = errors.New("failed to validate code: " + ret.Errors[0].Text)
+		return
+	}
+	var js, css []byte
+	for _, file := range ret.OutputFiles {
+		if strings.HasSuffix(file.Path, ".js") {
+			js = file.Contents
+		} else if strings.HasSuffix(file.Path, ".css") {
+			css = file.Contents
+		}
+	}
+	out = &TransformOutput{Code: string(js) + injectCSS(css)}
+	return
+}
+
+// injectCSS returns the js code that injects the css into the document.
+func injectCSS(css []byte) string {
+	if len(css) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(`globalThis.document.head.insertAdjacentHTML("beforeend","<style>"+%s+"</style>")`, utils.MustEncodeJSON(string(css)))
+}
+
 // extractReactImportFromCode 从代码中提取React导入URL，用于HTTP模块处理
 func extractReactImportFromCode(code string) string {
 	// 首先尝试标准的import语法格式
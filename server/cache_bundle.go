@@ -0,0 +1,560 @@
+package server
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/esm-dev/esm.sh/server/storage"
+	"github.com/ije/gox/set"
+	"github.com/ije/gox/utils"
+)
+
+const cacheBundleUsage = `Usage:
+  esmd export [options] [...package]   Export the cache of packages to a bundle file.
+  esmd import [options] <bundle>       Import a cache bundle into the storage and database.
+
+Export Options:
+  -config <file>      The config file path (default: "config.json").
+  -o <file>           The output bundle file (default: "esm-cache.tgz").
+  -importmap <file>   Select packages from an import map file.
+  -zone <id>          The zone id of the packages.
+  -no-deps            Don't include the dependencies of the selected packages.
+
+Import Options:
+  -config <file>      The config file path (default: "config.json").
+
+Note: the server must be stopped before importing a bundle.
+`
+
+// CacheBundleManifest is the manifest of a cache bundle
+type CacheBundleManifest struct {
+	Version  string   `json:"version"`
+	Created  string   `json:"created"`
+	ZoneId   string   `json:"zoneId,omitempty"`
+	Packages []string `json:"packages"`
+}
+
+// CacheBundleOptions are the options to export a cache bundle
+type CacheBundleOptions struct {
+	ZoneId    string
+	Packages  []string
+	ImportMap []byte
+	NoDeps    bool
+}
+
+// ExportCache exports a cache bundle of packages, used by the `esmd export` command.
+func ExportCache(args []string) {
+	var cfile, output, importMapFile, zoneId string
+	var noDeps bool
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	fs.StringVar(&cfile, "config", "config.json", "the config file path")
+	fs.StringVar(&output, "o", "esm-cache.tgz", "the output bundle file")
+	fs.StringVar(&importMapFile, "importmap", "", "select packages from an import map file")
+	fs.StringVar(&zoneId, "zone", "", "the zone id of the packages")
+	fs.BoolVar(&noDeps, "no-deps", false, "don't include the dependencies of the selected packages")
+	fs.Usage = func() { fmt.Print(cacheBundleUsage) }
+	fs.Parse(args)
+
+	options := CacheBundleOptions{ZoneId: zoneId, Packages: fs.Args(), NoDeps: noDeps}
+	if importMapFile != "" {
+		data, err := os.ReadFile(importMapFile)
+		if err != nil {
+			exitWithError(err)
+		}
+		options.ImportMap = data
+	}
+	if len(options.Packages) == 0 && options.ImportMap == nil {
+		fmt.Print(cacheBundleUsage)
+		os.Exit(1)
+	}
+
+	db, buildStorage := openCacheBundleEnv(cfile)
+	defer db.Close()
+
+	f, err := os.Create(output)
+	if err != nil {
+		exitWithError(err)
+	}
+	defer f.Close()
+
+	manifest, err := exportCacheBundle(f, db, buildStorage, options)
+	if err != nil {
+		os.Remove(output)
+		exitWithError(err)
+	}
+	fmt.Printf("Exported %d packages to %s\n", len(manifest.Packages), output)
+}
+
+// ImportCache imports a cache bundle, used by the `esmd import` command.
+func ImportCache(args []string) {
+	var cfile string
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	fs.StringVar(&cfile, "config", "config.json", "the config file path")
+	fs.Usage = func() { fmt.Print(cacheBundleUsage) }
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fmt.Print(cacheBundleUsage)
+		os.Exit(1)
+	}
+
+	db, buildStorage := openCacheBundleEnv(cfile)
+	defer db.Close()
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		exitWithError(err)
+	}
+	defer f.Close()
+
+	manifest, err := importCacheBundle(f, db, buildStorage)
+	if err != nil {
+		exitWithError(err)
+	}
+	fmt.Printf("Imported %d packages from %s\n", len(manifest.Packages), fs.Arg(0))
+}
+
+func openCacheBundleEnv(cfile string) (Database, storage.Storage) {
+	if existsFile(cfile) {
+		var err error
+		config, err = LoadConfig(cfile)
+		if err != nil {
+			exitWithError(err)
+		}
+	}
+	db, err := OpenBoltDB(path.Join(config.WorkDir, "esm.db"))
+	if err != nil {
+		exitWithError(fmt.Errorf("init db: %v", err))
+	}
+	buildStorage, err := storage.New(&config.Storage)
+	if err != nil {
+		exitWithError(fmt.Errorf("failed to initialize build storage(%s): %v", config.Storage.Type, err))
+	}
+	return db, buildStorage
+}
+
+func exitWithError(err error) {
+	fmt.Println("[error]", err.Error())
+	os.Exit(1)
+}
+
+// exportCacheBundle writes the npm store directories, build files, dts files and build metadata
+// of the selected packages into a gzipped tarball.
+func exportCacheBundle(w io.Writer, db Database, buildStorage storage.Storage, options CacheBundleOptions) (manifest *CacheBundleManifest, err error) {
+	npmrc := &NpmRC{zoneId: options.ZoneId}
+	packages, err := npmrc.resolveCacheBundlePackages(options)
+	if err != nil {
+		return
+	}
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	manifest = &CacheBundleManifest{
+		Version:  VERSION,
+		Created:  time.Now().UTC().Format(time.RFC3339),
+		ZoneId:   options.ZoneId,
+		Packages: packages,
+	}
+	err = writeTarFile(tw, "manifest.json", utils.MustEncodeJSON(manifest))
+	if err != nil {
+		return
+	}
+
+	metadata := map[string]string{}
+	for _, pkgId := range packages {
+		// the npm store directory
+		err = writeTarDir(tw, npmrc.StoreDir(), pkgId)
+		if err != nil {
+			return
+		}
+
+		// the build files and dts files in the storage
+		for _, prefix := range getCacheBundleStoragePrefixes(options.ZoneId, pkgId) {
+			var keys []string
+			keys, err = buildStorage.List(prefix)
+			if err != nil {
+				return
+			}
+			for _, key := range keys {
+				err = writeTarStorageFile(tw, buildStorage, key)
+				if err != nil {
+					return
+				}
+			}
+		}
+
+		// the build metadata in the database
+		for _, prefix := range []string{options.ZoneId + ":/" + pkgId + "/", options.ZoneId + ":/*" + pkgId + "/"} {
+			var keys []string
+			keys, err = db.List(prefix)
+			if err != nil {
+				return
+			}
+			for _, key := range keys {
+				var value []byte
+				value, err = db.Get(key)
+				if err != nil {
+					return
+				}
+				if value != nil {
+					metadata[key] = string(value)
+				}
+			}
+		}
+	}
+	err = writeTarFile(tw, "metadata.json", utils.MustEncodeJSON(metadata))
+	if err != nil {
+		return
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return
+	}
+	err = gw.Close()
+	return
+}
+
+// importCacheBundle imports a cache bundle created by the `exportCacheBundle` function.
+func importCacheBundle(r io.Reader, db Database, buildStorage storage.Storage) (manifest *CacheBundleManifest, err error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return
+	}
+	defer gr.Close()
+
+	var storeDir string
+	tr := tar.NewReader(gr)
+	for {
+		var h *tar.Header
+		h, err = tr.Next()
+		if err == io.EOF {
+			err = nil
+			break
+		}
+		if err != nil {
+			return
+		}
+		name := path.Clean(h.Name)
+		if strings.HasPrefix(name, "../") || strings.HasPrefix(name, "/") {
+			return nil, fmt.Errorf("invalid bundle entry: %s", h.Name)
+		}
+		switch {
+		case name == "manifest.json":
+			manifest = &CacheBundleManifest{}
+			err = json.NewDecoder(tr).Decode(manifest)
+			if err != nil {
+				return
+			}
+			storeDir = (&NpmRC{zoneId: manifest.ZoneId}).StoreDir()
+		case name == "metadata.json":
+			if manifest == nil {
+				return nil, errors.New("invalid bundle: missing manifest")
+			}
+			var metadata map[string]string
+			err = json.NewDecoder(tr).Decode(&metadata)
+			if err != nil {
+				return
+			}
+			for key, value := range metadata {
+				err = db.Put(key, []byte(value))
+				if err != nil {
+					return
+				}
+			}
+		case strings.HasPrefix(name, "npm/"):
+			if manifest == nil {
+				return nil, errors.New("invalid bundle: missing manifest")
+			}
+			filename := path.Join(storeDir, strings.TrimPrefix(name, "npm/"))
+			// never write through a symlink that is created by the previous entries
+			err = checkSymlinkFreePath(storeDir, path.Dir(filename))
+			if err != nil {
+				return
+			}
+			err = ensureDir(path.Dir(filename))
+			if err != nil {
+				return
+			}
+			if fi, e := os.Lstat(filename); e == nil && fi.Mode()&os.ModeSymlink != 0 {
+				os.Remove(filename)
+			}
+			switch h.Typeflag {
+			case tar.TypeDir:
+				err = ensureDir(filename)
+			case tar.TypeSymlink:
+				// the link target must be a relative path inside the npm store
+				target := path.Join(path.Dir(filename), h.Linkname)
+				if path.IsAbs(h.Linkname) || (target != storeDir && !strings.HasPrefix(target, storeDir+"/")) {
+					return nil, fmt.Errorf("invalid bundle entry: %s links to %s", h.Name, h.Linkname)
+				}
+				os.Remove(filename)
+				err = os.Symlink(h.Linkname, filename)
+			case tar.TypeReg:
+				var f *os.File
+				f, err = os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+				if err != nil {
+					return
+				}
+				_, err = io.Copy(f, tr)
+				f.Close()
+			}
+			if err != nil {
+				return
+			}
+		case strings.HasPrefix(name, "storage/"):
+			err = buildStorage.Put(strings.TrimPrefix(name, "storage/"), tr)
+			if err != nil {
+				return
+			}
+		}
+	}
+	if manifest == nil {
+		return nil, errors.New("invalid bundle: missing manifest")
+	}
+	return
+}
+
+// checkSymlinkFreePath returns an error if any directory of the path under the root is a symlink.
+func checkSymlinkFreePath(root string, dir string) error {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return fmt.Errorf("invalid bundle entry: %s", dir)
+	}
+	if rel == "." {
+		return nil
+	}
+	for _, part := range strings.Split(filepath.ToSlash(rel), "/") {
+		root = path.Join(root, part)
+		fi, err := os.Lstat(root)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("invalid bundle entry: %s is a symlink", root)
+		}
+	}
+	return nil
+}
+
+// resolveCacheBundlePackages resolves the packages to export from the package list and the import map,
+// returns the sorted package ids (`name@version`) including dependencies.
+func (npmrc *NpmRC) resolveCacheBundlePackages(options CacheBundleOptions) (packages []string, err error) {
+	specifiers := append([]string{}, options.Packages...)
+	if options.ImportMap != nil {
+		var importMap struct {
+			Imports map[string]string            `json:"imports"`
+			Scopes  map[string]map[string]string `json:"scopes"`
+		}
+		err = json.Unmarshal(options.ImportMap, &importMap)
+		if err != nil {
+			return nil, fmt.Errorf("invalid import map: %v", err)
+		}
+		urls := []string{}
+		for _, v := range importMap.Imports {
+			urls = append(urls, v)
+		}
+		for _, imports := range importMap.Scopes {
+			for _, v := range imports {
+				urls = append(urls, v)
+			}
+		}
+		for _, v := range urls {
+			u, e := url.Parse(v)
+			if e != nil || !isHttpSepcifier(v) {
+				continue
+			}
+			pathname := strings.TrimPrefix(u.Path, "/*")
+			if pkgName, version, _, _ := splitEsmPath(pathname); pkgName != "" && version != "" {
+				specifiers = append(specifiers, pkgName+"@"+version)
+			}
+		}
+	}
+
+	pkgIds := set.New[string]()
+	for _, specifier := range specifiers {
+		specifier = strings.TrimPrefix(specifier, "/")
+		if strings.HasPrefix(specifier, "gh/") || strings.HasPrefix(specifier, "git/") || strings.HasPrefix(specifier, "pr/") {
+			// git and pkg.pr.new packages require the exact version
+			if !existsDir(path.Join(npmrc.StoreDir(), specifier)) {
+				return nil, fmt.Errorf("package '%s' not found in the npm store", specifier)
+			}
+			pkgIds.Add(specifier)
+			continue
+		}
+		pkgName, version, _, _ := splitEsmPath(specifier)
+		if version == "" {
+			version = "latest"
+		}
+		p, e := npmrc.resolveInstalledPackage(pkgName, version)
+		if e != nil {
+			return nil, e
+		}
+		pkgIds.Add(pkgName + "@" + p.Version)
+	}
+
+	if !options.NoDeps {
+		queue := pkgIds.Values()
+		for len(queue) > 0 {
+			pkgId := queue[0]
+			queue = queue[1:]
+			for _, dep := range npmrc.getLinkedDependencies(pkgId) {
+				if !pkgIds.Has(dep) {
+					pkgIds.Add(dep)
+					queue = append(queue, dep)
+				}
+			}
+		}
+	}
+
+	packages = pkgIds.Values()
+	sort.Strings(packages)
+	return
+}
+
+// getLinkedDependencies returns the ids of dependencies linked in the `node_modules` directory of the package
+func (npmrc *NpmRC) getLinkedDependencies(pkgId string) (deps []string) {
+	storeDir := npmrc.StoreDir()
+	nodeModulesDir := path.Join(storeDir, pkgId, "node_modules")
+	var links []string
+	entries, _ := os.ReadDir(nodeModulesDir)
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), "@") && entry.IsDir() {
+			scopedEntries, _ := os.ReadDir(path.Join(nodeModulesDir, entry.Name()))
+			for _, e := range scopedEntries {
+				links = append(links, path.Join(nodeModulesDir, entry.Name(), e.Name()))
+			}
+		} else {
+			links = append(links, path.Join(nodeModulesDir, entry.Name()))
+		}
+	}
+	for _, link := range links {
+		target, err := os.Readlink(link)
+		if err != nil {
+			continue
+		}
+		if !filepath.IsAbs(target) {
+			target = path.Join(path.Dir(link), target)
+		}
+		// the link target is `{storeDir}/{pkgId}/node_modules/{pkgName}`
+		rel, err := filepath.Rel(storeDir, target)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		if depId, _ := utils.SplitByLastByte(filepath.ToSlash(rel), '/'); strings.HasSuffix(depId, "/node_modules") {
+			deps = append(deps, strings.TrimSuffix(depId, "/node_modules"))
+		} else if strings.HasSuffix(path.Dir(depId), "/node_modules") {
+			// scoped package
+			deps = append(deps, strings.TrimSuffix(path.Dir(depId), "/node_modules"))
+		}
+	}
+	return
+}
+
+// getCacheBundleStoragePrefixes returns the storage prefixes of the build files and dts files of a package,
+// see the `normalizeSavePath` function.
+func getCacheBundleStoragePrefixes(zoneId string, pkgId string) []string {
+	prefixes := []string{"modules/" + pkgId + "/", "types/" + pkgId + "/"}
+	if strings.HasPrefix(pkgId, "@") {
+		// build files with the `*` prefix (external all) of scoped packages
+		scope, name := utils.SplitByFirstByte(pkgId, '/')
+		prefixes = append(prefixes, "modules/"+scope+"/ea/"+name+"/")
+	}
+	if zoneId != "" {
+		for i, prefix := range prefixes {
+			prefixes[i] = zoneId + "/" + prefix
+		}
+	}
+	return prefixes
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+func writeTarStorageFile(tw *tar.Writer, buildStorage storage.Storage, key string) error {
+	r, stat, err := buildStorage.Get(key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	err = tw.WriteHeader(&tar.Header{
+		Name:    "storage/" + key,
+		Mode:    0644,
+		Size:    stat.Size(),
+		ModTime: stat.ModTime(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, r)
+	return err
+}
+
+// writeTarDir writes the directory `{root}/{dir}` into the tarball, symlinks into the root are rewritten
+// to relative links so that they still work after the bundle is imported into another work directory.
+func writeTarDir(tw *tar.Writer, root string, dir string) error {
+	return filepath.Walk(path.Join(root, dir), func(filename string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, filename)
+		if err != nil {
+			return err
+		}
+		name := "npm/" + filepath.ToSlash(rel)
+		switch {
+		case fi.IsDir():
+			return tw.WriteHeader(&tar.Header{Name: name + "/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: fi.ModTime()})
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(filename)
+			if err != nil {
+				return err
+			}
+			if filepath.IsAbs(target) {
+				if r, err := filepath.Rel(filepath.Dir(filename), target); err == nil {
+					target = r
+				}
+			}
+			return tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeSymlink, Linkname: target, Mode: 0644, ModTime: fi.ModTime()})
+		case fi.Mode().IsRegular():
+			f, err := os.Open(filename)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			err = tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: fi.Size(), ModTime: fi.ModTime()})
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(tw, f)
+			return err
+		}
+		return nil
+	})
+}
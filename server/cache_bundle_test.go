@@ -0,0 +1,148 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/esm-dev/esm.sh/server/storage"
+)
+
+func TestCacheBundle(t *testing.T) {
+	workDir := config.WorkDir
+	defer func() { config.WorkDir = workDir }()
+
+	// setup the source server
+	config.WorkDir = t.TempDir()
+	npmrc := &NpmRC{}
+	for _, pkgId := range []string{"react@19.0.0", "react-dom@19.0.0", "scheduler@0.25.0", "vue@3.5.0"} {
+		pkgName, version, _, _ := splitEsmPath(pkgId)
+		dir := path.Join(npmrc.StoreDir(), pkgId, "node_modules", pkgName)
+		os.MkdirAll(dir, 0755)
+		os.WriteFile(path.Join(dir, "package.json"), []byte(`{"name":"`+pkgName+`","version":"`+version+`"}`), 0644)
+	}
+	os.Symlink(path.Join(npmrc.StoreDir(), "scheduler@0.25.0", "node_modules", "scheduler"), path.Join(npmrc.StoreDir(), "react-dom@19.0.0", "node_modules", "scheduler"))
+	db, err := OpenBoltDB(path.Join(config.WorkDir, "esm.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	buildStorage, err := storage.New(&storage.StorageOptions{Type: "fs", Endpoint: path.Join(config.WorkDir, "storage")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buildStorage.Put("modules/react-dom@19.0.0/es2022/react-dom.mjs", bytes.NewReader([]byte("export default {}")))
+	buildStorage.Put("types/react-dom@19.0.0/index.d.ts", bytes.NewReader([]byte("export {}")))
+	buildStorage.Put("modules/scheduler@0.25.0/es2022/scheduler.mjs", bytes.NewReader([]byte("export {}")))
+	buildStorage.Put("modules/vue@3.5.0/es2022/vue.mjs", bytes.NewReader([]byte("export {}")))
+	db.Put(":/react-dom@19.0.0/es2022/react-dom.mjs", []byte("d"))
+	db.Put(":/vue@3.5.0/es2022/vue.mjs", []byte("d"))
+
+	buf := bytes.NewBuffer(nil)
+	manifest, err := exportCacheBundle(buf, db, buildStorage, CacheBundleOptions{
+		ImportMap: []byte(`{"imports":{"react-dom":"https://esm.sh/react-dom@^19.0.0"}}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Packages) != 2 || manifest.Packages[0] != "react-dom@19.0.0" || manifest.Packages[1] != "scheduler@0.25.0" {
+		t.Fatalf("unexpected packages: %v", manifest.Packages)
+	}
+
+	// import the bundle into another server
+	config.WorkDir = t.TempDir()
+	db2, err := OpenBoltDB(path.Join(config.WorkDir, "esm.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db2.Close()
+	buildStorage2, err := storage.New(&storage.StorageOptions{Type: "fs", Endpoint: path.Join(config.WorkDir, "storage")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = importCacheBundle(buf, db2, buildStorage2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, _ := db2.Get(":/react-dom@19.0.0/es2022/react-dom.mjs"); string(v) != "d" {
+		t.Fatal("missing build metadata")
+	}
+	if v, _ := db2.Get(":/vue@3.5.0/es2022/vue.mjs"); v != nil {
+		t.Fatal("unexpected build metadata")
+	}
+	for _, key := range []string{"modules/react-dom@19.0.0/es2022/react-dom.mjs", "types/react-dom@19.0.0/index.d.ts", "modules/scheduler@0.25.0/es2022/scheduler.mjs"} {
+		r, _, err := buildStorage2.Get(key)
+		if err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+		io.Copy(io.Discard, r)
+		r.Close()
+	}
+	if _, _, err := buildStorage2.Get("modules/vue@3.5.0/es2022/vue.mjs"); err != storage.ErrNotFound {
+		t.Fatal("unexpected build file")
+	}
+	p, err := npmrc.resolveInstalledPackage("scheduler", "latest")
+	if err != nil || p.Version != "0.25.0" {
+		t.Fatalf("unexpected package: %v", err)
+	}
+	// the symlink should be rewritten to the new work directory
+	if !existsFile(path.Join(npmrc.StoreDir(), "react-dom@19.0.0", "node_modules", "scheduler", "package.json")) {
+		t.Fatal("broken dependency link")
+	}
+}
+
+func TestImportCacheBundleSymlinks(t *testing.T) {
+	workDir := config.WorkDir
+	defer func() { config.WorkDir = workDir }()
+	config.WorkDir = t.TempDir()
+
+	createBundle := func(entries ...tar.Header) *bytes.Buffer {
+		buf := bytes.NewBuffer(nil)
+		gw := gzip.NewWriter(buf)
+		tw := tar.NewWriter(gw)
+		manifest := []byte(`{"version":1}`)
+		tw.WriteHeader(&tar.Header{Name: "manifest.json", Mode: 0644, Size: int64(len(manifest))})
+		tw.Write(manifest)
+		for _, h := range entries {
+			tw.WriteHeader(&h)
+			if h.Size > 0 {
+				tw.Write(bytes.Repeat([]byte("x"), int(h.Size)))
+			}
+		}
+		tw.Close()
+		gw.Close()
+		return buf
+	}
+
+	db, err := OpenBoltDB(path.Join(config.WorkDir, "esm.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	buildStorage, err := storage.New(&storage.StorageOptions{Type: "fs", Endpoint: path.Join(config.WorkDir, "storage")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bundles := map[string]*bytes.Buffer{
+		"absolute link": createBundle(tar.Header{Name: "npm/foo@1.0.0/node_modules/bar", Typeflag: tar.TypeSymlink, Linkname: "/etc"}),
+		"escaping link": createBundle(tar.Header{Name: "npm/foo@1.0.0/node_modules/bar", Typeflag: tar.TypeSymlink, Linkname: "../../../.."}),
+		"write through link": createBundle(
+			tar.Header{Name: "npm/foo@1.0.0/node_modules/bar", Typeflag: tar.TypeSymlink, Linkname: "../../bar@1.0.0/node_modules/bar"},
+			tar.Header{Name: "npm/foo@1.0.0/node_modules/bar/index.js", Typeflag: tar.TypeReg, Mode: 0644, Size: 1},
+		),
+	}
+	for name, bundle := range bundles {
+		if _, err := importCacheBundle(bundle, db, buildStorage); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+	if _, err := os.Stat(path.Join(config.WorkDir, "npm", "bar@1.0.0", "node_modules", "bar", "index.js")); err == nil {
+		t.Fatal("the file should not be written through the symlink")
+	}
+}
@@ -79,7 +79,12 @@ func cjsModuleLexer(b *BuildContext, cjsEntry string) (ret cjsModuleLexerResult,
 			return
 		}
 		var data []byte
-		data, err = run("deno", "run", "--no-config", "--no-lock", "--no-prompt", "--quiet", js)
+		args := []string{"run", "--no-config", "--no-lock", "--no-prompt", "--quiet"}
+		if config.Offline {
+			// only use the packages cached by deno
+			args = append(args, "--cached-only")
+		}
+		data, err = run("deno", append(args, js)...)
 		if err != nil {
 			return
 		}
@@ -168,6 +173,10 @@ func installCjsModuleLexer() (err error) {
 		return
 	}
 
+	if config.Offline {
+		return fmt.Errorf("cjs-module-lexer not found, please put the cjs-module-lexer binary in %s (offline mode)", binDir)
+	}
+
 	url, err := getCjsModuleLexerDownloadURL()
 	if err != nil {
 		return
@@ -1,9 +1,21 @@
 package main
 
 import (
+	"os"
+
 	"github.com/esm-dev/esm.sh/server"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "export":
+			server.ExportCache(os.Args[2:])
+			return
+		case "import":
+			server.ImportCache(os.Args[2:])
+			return
+		}
+	}
 	server.Serve()
 }
@@ -0,0 +1,154 @@
+package common
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+)
+
+// BuildManifest records the provenance of a build output.
+type BuildManifest struct {
+	Package   ManifestPackage `json:"package"`
+	Esbuild   string          `json:"esbuild"`
+	Target    string          `json:"target"`
+	BuildArgs map[string]any  `json:"buildArgs,omitempty"`
+	Path      string          `json:"path"`
+	Digest    string          `json:"digest"`
+	Size      int64           `json:"size"`
+	// the output files that are loaded by the build, e.g. the shared chunks and the css
+	Files []ManifestFile `json:"files,omitempty"`
+}
+
+// ManifestFile is an output file of the build.
+type ManifestFile struct {
+	Path   string `json:"path"`
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+}
+
+// ManifestPackage is the upstream package of the build.
+type ManifestPackage struct {
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	Tarball   string `json:"tarball,omitempty"`
+	Integrity string `json:"integrity,omitempty"`
+}
+
+// SignedBuildManifest wraps the manifest with its signature, the signature is computed over the
+// exact bytes of the `manifest` field.
+type SignedBuildManifest struct {
+	Manifest  json.RawMessage    `json:"manifest"`
+	Signature *ManifestSignature `json:"signature,omitempty"`
+}
+
+// ManifestSignature is the ed25519 signature of the manifest.
+type ManifestSignature struct {
+	Algorithm string `json:"algorithm"`
+	KeyId     string `json:"keyId"`
+	Value     string `json:"value"`
+}
+
+// Digest returns the SRI-style sha256 digest of the data, e.g. "sha256-47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="
+func Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// KeyId returns the id of the public key, which is the first 8 bytes of its sha256 hash in hex.
+func KeyId(publicKey ed25519.PublicKey) string {
+	sum := sha256.Sum256(publicKey)
+	return hex.EncodeToString(sum[:8])
+}
+
+// ParseSigningKey parses the base64 encoded ed25519 private key, both the 32-byte seed and the
+// 64-byte private key are accepted.
+func ParseSigningKey(s string) (ed25519.PrivateKey, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid signing key: " + err.Error())
+	}
+	switch len(data) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(data), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(data), nil
+	}
+	return nil, errors.New("invalid signing key: bad key size")
+}
+
+// ParsePublicKey parses the base64 encoded ed25519 public key.
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid public key: " + err.Error())
+	}
+	if len(data) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid public key: bad key size")
+	}
+	return ed25519.PublicKey(data), nil
+}
+
+// SignManifest encodes the manifest and signs it with the key, the signature is omitted if the key is nil.
+func SignManifest(manifest *BuildManifest, key ed25519.PrivateKey) ([]byte, error) {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	signed := SignedBuildManifest{Manifest: data}
+	if key != nil {
+		signed.Signature = &ManifestSignature{
+			Algorithm: "ed25519",
+			KeyId:     KeyId(key.Public().(ed25519.PublicKey)),
+			Value:     base64.StdEncoding.EncodeToString(ed25519.Sign(key, data)),
+		}
+	}
+	// don't indent the output, that changes the signed bytes of the manifest
+	return json.Marshal(signed)
+}
+
+// VerifyManifest decodes the signed manifest and checks its signature with the public key, and checks
+// the digest of the module if it's not nil.
+func VerifyManifest(data []byte, publicKey ed25519.PublicKey, module []byte) (*BuildManifest, error) {
+	var signed SignedBuildManifest
+	err := json.Unmarshal(data, &signed)
+	if err != nil {
+		return nil, errors.New("invalid manifest: " + err.Error())
+	}
+	var manifest BuildManifest
+	err = json.Unmarshal(signed.Manifest, &manifest)
+	if err != nil {
+		return nil, errors.New("invalid manifest: " + err.Error())
+	}
+	if publicKey != nil {
+		if signed.Signature == nil {
+			return &manifest, errors.New("manifest is not signed")
+		}
+		if signed.Signature.Algorithm != "ed25519" {
+			return &manifest, errors.New("unsupported signature algorithm: " + signed.Signature.Algorithm)
+		}
+		if signed.Signature.KeyId != KeyId(publicKey) {
+			return &manifest, errors.New("manifest is signed by another key: " + signed.Signature.KeyId)
+		}
+		sig, err := base64.StdEncoding.DecodeString(signed.Signature.Value)
+		if err != nil || !ed25519.Verify(publicKey, signed.Manifest, sig) {
+			return &manifest, errors.New("bad signature")
+		}
+	}
+	if module != nil {
+		if digest := Digest(module); digest != manifest.Digest {
+			return &manifest, errors.New("digest mismatch: expected " + manifest.Digest + ", got " + digest)
+		}
+	}
+	return &manifest, nil
+}
+
+// VerifyManifestFile checks the output file with the digest that is recorded in the manifest.
+func VerifyManifestFile(file ManifestFile, data []byte) error {
+	if digest := Digest(data); digest != file.Digest {
+		return errors.New(file.Path + ": digest mismatch: expected " + file.Digest + ", got " + digest)
+	}
+	return nil
+}
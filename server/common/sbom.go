@@ -0,0 +1,459 @@
+package common
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SBOM is the software bill of materials of a build, the components are identified by their purl.
+type SBOM struct {
+	Root       string
+	Components []*SBOMComponent
+}
+
+// SBOMComponent is a package in the SBOM.
+type SBOMComponent struct {
+	Name      string
+	Version   string
+	Purl      string
+	License   string
+	Tarball   string
+	Integrity string
+	// Scope is "bundled" if the package is bundled into the build, or "imported" if it's imported by the build,
+	// it's empty for the root component.
+	Scope     string
+	DependsOn []string
+}
+
+var regexpSpdxIdChars = regexp.MustCompile(`[^a-zA-Z0-9.\-]+`)
+
+// NpmPurl returns the package url of the npm package, e.g. "pkg:npm/%40scope/name@1.0.0"
+func NpmPurl(name string, version string) string {
+	return "pkg:npm/" + strings.Replace(name, "@", "%40", 1) + "@" + url.PathEscape(version)
+}
+
+// Get returns the component by the purl.
+func (s *SBOM) Get(purl string) *SBOMComponent {
+	for _, c := range s.Components {
+		if c.Purl == purl {
+			return c
+		}
+	}
+	return nil
+}
+
+// Add adds the component to the SBOM, the dependencies are merged if the component exists.
+func (s *SBOM) Add(c *SBOMComponent) *SBOMComponent {
+	if exists := s.Get(c.Purl); exists != nil {
+		for _, dep := range c.DependsOn {
+			exists.AddDependency(dep)
+		}
+		// "bundled" wins
+		if exists.Scope == "imported" && c.Scope == "bundled" {
+			exists.Scope = c.Scope
+		}
+		return exists
+	}
+	s.Components = append(s.Components, c)
+	return c
+}
+
+// AddDependency adds the dependency to the component.
+func (c *SBOMComponent) AddDependency(purl string) {
+	if purl == c.Purl {
+		return
+	}
+	for _, dep := range c.DependsOn {
+		if dep == purl {
+			return
+		}
+	}
+	c.DependsOn = append(c.DependsOn, purl)
+}
+
+// Merge merges another SBOM into the SBOM, the root of the other SBOM becomes a dependency of the root.
+func (s *SBOM) Merge(other *SBOM) {
+	for _, c := range other.Components {
+		copy := *c
+		copy.DependsOn = append([]string{}, c.DependsOn...)
+		if copy.Purl == other.Root {
+			copy.Scope = "imported"
+		}
+		s.Add(&copy)
+	}
+	if root := s.Get(s.Root); root != nil {
+		root.AddDependency(other.Root)
+	}
+}
+
+// serialNumber returns a stable uuid of the SBOM.
+func (s *SBOM) serialNumber() string {
+	h := sha1.New()
+	for _, c := range s.Components {
+		h.Write([]byte(c.Purl))
+		h.Write([]byte{'\n'})
+	}
+	sum := h.Sum(nil)
+	// uuid version 5
+	sum[6] = (sum[6] & 0x0f) | 0x50
+	sum[8] = (sum[8] & 0x3f) | 0x80
+	hexStr := hex.EncodeToString(sum[:16])
+	return fmt.Sprintf("%s-%s-%s-%s-%s", hexStr[0:8], hexStr[8:12], hexStr[12:16], hexStr[16:20], hexStr[20:32])
+}
+
+// sorted returns the non-root components sorted by purl.
+func (s *SBOM) sorted() []*SBOMComponent {
+	components := make([]*SBOMComponent, 0, len(s.Components))
+	for _, c := range s.Components {
+		if c.Purl != s.Root {
+			components = append(components, c)
+		}
+	}
+	sort.Slice(components, func(i, j int) bool {
+		return components[i].Purl < components[j].Purl
+	})
+	return components
+}
+
+type cdxBOM struct {
+	BomFormat    string          `json:"bomFormat"`
+	SpecVersion  string          `json:"specVersion"`
+	SerialNumber string          `json:"serialNumber,omitempty"`
+	Version      int             `json:"version"`
+	Metadata     cdxMetadata     `json:"metadata"`
+	Components   []cdxComponent  `json:"components"`
+	Dependencies []cdxDependency `json:"dependencies,omitempty"`
+}
+
+type cdxMetadata struct {
+	Timestamp string        `json:"timestamp,omitempty"`
+	Tools     *cdxTools     `json:"tools,omitempty"`
+	Component *cdxComponent `json:"component,omitempty"`
+}
+
+type cdxTools struct {
+	Components []cdxComponent `json:"components"`
+}
+
+type cdxComponent struct {
+	Type               string           `json:"type"`
+	BomRef             string           `json:"bom-ref,omitempty"`
+	Group              string           `json:"group,omitempty"`
+	Name               string           `json:"name"`
+	Version            string           `json:"version,omitempty"`
+	Scope              string           `json:"scope,omitempty"`
+	Purl               string           `json:"purl,omitempty"`
+	Licenses           []cdxLicense     `json:"licenses,omitempty"`
+	Hashes             []cdxHash        `json:"hashes,omitempty"`
+	ExternalReferences []cdxExternalRef `json:"externalReferences,omitempty"`
+	Properties         []cdxProperty    `json:"properties,omitempty"`
+}
+
+type cdxLicense struct {
+	License    *cdxLicenseChoice `json:"license,omitempty"`
+	Expression string            `json:"expression,omitempty"`
+}
+
+type cdxLicenseChoice struct {
+	Id   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+type cdxHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cdxExternalRef struct {
+	Type string `json:"type"`
+	Url  string `json:"url"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cdxDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
+
+// CycloneDX encodes the SBOM in the CycloneDX 1.5 JSON format.
+// see https://cyclonedx.org/docs/1.5/json/
+func (s *SBOM) CycloneDX(tool string, toolVersion string) ([]byte, error) {
+	root := s.Get(s.Root)
+	if root == nil {
+		return nil, errors.New("root component not found")
+	}
+	rootComponent := toCdxComponent(root, "application")
+	bom := cdxBOM{
+		BomFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + s.serialNumber(),
+		Version:      1,
+		Metadata: cdxMetadata{
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			Tools: &cdxTools{
+				Components: []cdxComponent{{Type: "application", Name: tool, Version: toolVersion}},
+			},
+			Component: &rootComponent,
+		},
+		Components: []cdxComponent{},
+	}
+	bom.Dependencies = append(bom.Dependencies, cdxDependency{Ref: root.Purl, DependsOn: root.DependsOn})
+	for _, c := range s.sorted() {
+		bom.Components = append(bom.Components, toCdxComponent(c, "library"))
+		bom.Dependencies = append(bom.Dependencies, cdxDependency{Ref: c.Purl, DependsOn: c.DependsOn})
+	}
+	return json.MarshalIndent(bom, "", "  ")
+}
+
+func toCdxComponent(c *SBOMComponent, componentType string) cdxComponent {
+	ret := cdxComponent{
+		Type:    componentType,
+		BomRef:  c.Purl,
+		Name:    c.Name,
+		Version: c.Version,
+		Purl:    c.Purl,
+	}
+	if strings.HasPrefix(c.Name, "@") {
+		ret.Group, ret.Name, _ = strings.Cut(c.Name, "/")
+	}
+	if c.Scope != "" {
+		ret.Scope = "required"
+		ret.Properties = []cdxProperty{{Name: "esm.sh:scope", Value: c.Scope}}
+	}
+	if c.License != "" {
+		if isSpdxExpression(c.License) {
+			ret.Licenses = []cdxLicense{{Expression: c.License}}
+		} else if isSpdxId(c.License) {
+			ret.Licenses = []cdxLicense{{License: &cdxLicenseChoice{Id: c.License}}}
+		} else {
+			ret.Licenses = []cdxLicense{{License: &cdxLicenseChoice{Name: c.License}}}
+		}
+	}
+	if alg, digest, ok := strings.Cut(c.Integrity, "-"); ok && len(alg) > 3 {
+		if data, err := base64.StdEncoding.DecodeString(digest); err == nil {
+			ret.Hashes = []cdxHash{{Alg: strings.ToUpper(alg[:3]) + "-" + alg[3:], Content: hex.EncodeToString(data)}}
+		}
+	}
+	if c.Tarball != "" {
+		ret.ExternalReferences = []cdxExternalRef{{Type: "distribution", Url: c.Tarball}}
+	}
+	return ret
+}
+
+// ParseCycloneDX parses the SBOM from the CycloneDX JSON.
+func ParseCycloneDX(data []byte) (*SBOM, error) {
+	var bom cdxBOM
+	err := json.Unmarshal(data, &bom)
+	if err != nil {
+		return nil, err
+	}
+	if bom.BomFormat != "CycloneDX" || bom.Metadata.Component == nil {
+		return nil, errors.New("invalid CycloneDX SBOM")
+	}
+	s := &SBOM{Root: bom.Metadata.Component.Purl}
+	s.Add(fromCdxComponent(*bom.Metadata.Component))
+	for _, c := range bom.Components {
+		s.Add(fromCdxComponent(c))
+	}
+	for _, dep := range bom.Dependencies {
+		if c := s.Get(dep.Ref); c != nil {
+			for _, purl := range dep.DependsOn {
+				c.AddDependency(purl)
+			}
+		}
+	}
+	return s, nil
+}
+
+func fromCdxComponent(c cdxComponent) *SBOMComponent {
+	ret := &SBOMComponent{
+		Name:    c.Name,
+		Version: c.Version,
+		Purl:    c.Purl,
+	}
+	if c.Group != "" {
+		ret.Name = c.Group + "/" + c.Name
+	}
+	for _, p := range c.Properties {
+		if p.Name == "esm.sh:scope" {
+			ret.Scope = p.Value
+		}
+	}
+	for _, l := range c.Licenses {
+		if l.Expression != "" {
+			ret.License = l.Expression
+		} else if l.License != nil {
+			ret.License = l.License.Id
+			if ret.License == "" {
+				ret.License = l.License.Name
+			}
+		}
+	}
+	for _, h := range c.Hashes {
+		if data, err := hex.DecodeString(h.Content); err == nil {
+			ret.Integrity = strings.ToLower(strings.ReplaceAll(h.Alg, "-", "")) + "-" + base64.StdEncoding.EncodeToString(data)
+		}
+	}
+	for _, r := range c.ExternalReferences {
+		if r.Type == "distribution" {
+			ret.Tarball = r.Url
+		}
+	}
+	return ret
+}
+
+type spdxDocument struct {
+	SpdxVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name             string            `json:"name"`
+	SPDXID           string            `json:"SPDXID"`
+	VersionInfo      string            `json:"versionInfo,omitempty"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	LicenseConcluded string            `json:"licenseConcluded"`
+	LicenseDeclared  string            `json:"licenseDeclared"`
+	Checksums        []spdxChecksum    `json:"checksums,omitempty"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SpdxElementId      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSpdxElement string `json:"relatedSpdxElement"`
+}
+
+// SPDX encodes the SBOM in the SPDX 2.3 JSON format, the bundled packages are `CONTAINS` relationships and
+// the imported packages are `DEPENDS_ON` relationships.
+// see https://spdx.github.io/spdx-spec/v2.3/
+func (s *SBOM) SPDX(tool string, toolVersion string, namespace string) ([]byte, error) {
+	root := s.Get(s.Root)
+	if root == nil {
+		return nil, errors.New("root component not found")
+	}
+	name := root.Name
+	if root.Version != "" {
+		name += "@" + root.Version
+	}
+	doc := spdxDocument{
+		SpdxVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              name,
+		DocumentNamespace: strings.TrimSuffix(namespace, "/") + "/" + s.serialNumber(),
+		CreationInfo: spdxCreationInfo{
+			Created:  time.Now().UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: " + tool + "-" + toolVersion},
+		},
+		Packages: []spdxPackage{toSpdxPackage(root)},
+		Relationships: []spdxRelationship{
+			{SpdxElementId: "SPDXRef-DOCUMENT", RelationshipType: "DESCRIBES", RelatedSpdxElement: spdxId(root)},
+		},
+	}
+	components := s.sorted()
+	for _, c := range components {
+		doc.Packages = append(doc.Packages, toSpdxPackage(c))
+	}
+	for _, c := range append([]*SBOMComponent{root}, components...) {
+		deps := append([]string{}, c.DependsOn...)
+		sort.Strings(deps)
+		for _, purl := range deps {
+			dep := s.Get(purl)
+			if dep == nil {
+				continue
+			}
+			relationshipType := "DEPENDS_ON"
+			if dep.Scope == "bundled" {
+				relationshipType = "CONTAINS"
+			}
+			doc.Relationships = append(doc.Relationships, spdxRelationship{
+				SpdxElementId:      spdxId(c),
+				RelationshipType:   relationshipType,
+				RelatedSpdxElement: spdxId(dep),
+			})
+		}
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+func toSpdxPackage(c *SBOMComponent) spdxPackage {
+	ret := spdxPackage{
+		Name:             c.Name,
+		SPDXID:           spdxId(c),
+		VersionInfo:      c.Version,
+		DownloadLocation: "NOASSERTION",
+		LicenseConcluded: "NOASSERTION",
+		LicenseDeclared:  "NOASSERTION",
+	}
+	if c.Tarball != "" {
+		ret.DownloadLocation = c.Tarball
+	}
+	if c.License != "" && (isSpdxId(c.License) || isSpdxExpression(c.License)) {
+		ret.LicenseDeclared = c.License
+	}
+	if alg, digest, ok := strings.Cut(c.Integrity, "-"); ok {
+		if data, err := base64.StdEncoding.DecodeString(digest); err == nil {
+			ret.Checksums = []spdxChecksum{{Algorithm: strings.ToUpper(alg), ChecksumValue: hex.EncodeToString(data)}}
+		}
+	}
+	if c.Purl != "" {
+		ret.ExternalRefs = []spdxExternalRef{{ReferenceCategory: "PACKAGE-MANAGER", ReferenceType: "purl", ReferenceLocator: c.Purl}}
+	}
+	return ret
+}
+
+func spdxId(c *SBOMComponent) string {
+	return "SPDXRef-Package-" + strings.Trim(regexpSpdxIdChars.ReplaceAllString(c.Name+"-"+c.Version, "-"), "-")
+}
+
+// isSpdxId checks if the license is a single SPDX license identifier, e.g. "MIT", "Apache-2.0".
+func isSpdxId(license string) bool {
+	for _, c := range license {
+		if !((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-' || c == '.' || c == '+') {
+			return false
+		}
+	}
+	return license != "" && !strings.EqualFold(license, "UNLICENSED")
+}
+
+// isSpdxExpression checks if the license is a SPDX license expression, e.g. "(MIT OR Apache-2.0)".
+func isSpdxExpression(license string) bool {
+	return strings.Contains(license, " OR ") || strings.Contains(license, " AND ") || strings.Contains(license, " WITH ")
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"bytes"
+	"crypto/ed25519"
 	"encoding/json"
 	"fmt"
 	"net/url"
@@ -11,9 +12,13 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/esm-dev/esm.sh/server/storage"
 	"github.com/ije/gox/term"
+	"github.com/ije/gox/utils"
+	"github.com/ije/gox/valid"
 )
 
 var (
@@ -31,25 +36,34 @@ type Config struct {
 	CorsAllowOrigins    []string               `json:"corsAllowOrigins"`
 	AllowList           AllowList              `json:"allowList"`
 	BanList             BanList                `json:"banList"`
+	LicensePolicy       LicensePolicy          `json:"licensePolicy"`
+	Advisories          AdvisoryOptions        `json:"advisories"`
 	BuildConcurrency    uint16                 `json:"buildConcurrency"`
 	BuildWaitTime       uint16                 `json:"buildWaitTime"`
 	Storage             storage.StorageOptions `json:"storage"`
 	CacheRawFile        bool                   `json:"cacheRawFile"`
+	Offline             bool                   `json:"offline"`
 	LogDir              string                 `json:"logDir"`
 	LogLevel            string                 `json:"logLevel"`
 	AccessLog           bool                   `json:"accessLog"`
+	Npmrc               string                 `json:"npmrc"`
 	NpmRegistry         string                 `json:"npmRegistry"`
+	NpmRegistryMirrors  []string               `json:"npmRegistryMirrors"`
 	NpmToken            string                 `json:"npmToken"`
 	NpmUser             string                 `json:"npmUser"`
 	NpmPassword         string                 `json:"npmPassword"`
 	NpmScopedRegistries map[string]NpmRegistry `json:"npmScopedRegistries"`
 	NpmQueryCacheTTL    uint32                 `json:"npmQueryCacheTTL"`
+	GitHosts            map[string]GitHost     `json:"gitHosts"`
+	TypeScriptVersion   string                 `json:"typescriptVersion"`
 	MinifyRaw           json.RawMessage        `json:"minify"`
 	SourceMapRaw        json.RawMessage        `json:"sourceMap"`
 	CompressRaw         json.RawMessage        `json:"compress"`
+	SigningKeyRaw       string                 `json:"signingKey"`
 	Minify              bool                   `json:"-"`
 	SourceMap           bool                   `json:"-"`
 	Compress            bool                   `json:"-"`
+	SigningKey          ed25519.PrivateKey     `json:"-"`
 }
 
 type LandingPageOptions struct {
@@ -58,8 +72,9 @@ type LandingPageOptions struct {
 }
 
 type BanList struct {
-	Packages []string   `json:"packages"`
-	Scopes   []BanScope `json:"scopes"`
+	Packages      []string   `json:"packages"`
+	Scopes        []BanScope `json:"scopes"`
+	MinPublishAge string     `json:"minPublishAge"`
 }
 
 type BanScope struct {
@@ -76,6 +91,18 @@ type AllowScope struct {
 	Name string `json:"name"`
 }
 
+type AdvisoryOptions struct {
+	Path           string `json:"path"`
+	ReloadInterval uint32 `json:"reloadInterval"`
+	Block          string `json:"block"`
+}
+
+type LicensePolicy struct {
+	Deny      []string          `json:"deny"`
+	Warn      []string          `json:"warn"`
+	Overrides map[string]string `json:"overrides"`
+}
+
 // LoadConfig loads config from the given file. Panic if failed to load.
 func LoadConfig(filename string) (*Config, error) {
 	file, err := os.Open(filename)
@@ -198,9 +225,16 @@ func normalizeConfig(config *Config) {
 			config.LogLevel = "info"
 		}
 	}
+	if !config.Offline {
+		config.Offline = os.Getenv("OFFLINE") == "true"
+	}
 	if !config.AccessLog {
 		config.AccessLog = os.Getenv("ACCESS_LOG") == "true"
 	}
+	if config.Npmrc == "" {
+		config.Npmrc = os.Getenv("NPMRC")
+	}
+	// the precedence of the npm registry options: config file > env > `.npmrc` file
 	if config.NpmRegistry != "" {
 		if isHttpSepcifier(config.NpmRegistry) {
 			config.NpmRegistry = strings.TrimRight(config.NpmRegistry, "/") + "/"
@@ -209,10 +243,14 @@ func normalizeConfig(config *Config) {
 		v := os.Getenv("NPM_REGISTRY")
 		if v != "" && isHttpSepcifier(v) {
 			config.NpmRegistry = strings.TrimRight(v, "/") + "/"
-		} else {
-			config.NpmRegistry = npmRegistry
 		}
 	}
+	if len(config.NpmRegistryMirrors) == 0 {
+		if v := os.Getenv("NPM_REGISTRY_MIRRORS"); v != "" {
+			config.NpmRegistryMirrors = strings.Split(v, ",")
+		}
+	}
+	config.NpmRegistryMirrors = normalizeRegistryMirrors(config.NpmRegistryMirrors)
 	if config.NpmToken == "" {
 		config.NpmToken = os.Getenv("NPM_TOKEN")
 	}
@@ -222,11 +260,18 @@ func normalizeConfig(config *Config) {
 	if config.NpmPassword == "" {
 		config.NpmPassword = os.Getenv("NPM_PASSWORD")
 	}
+	if config.Npmrc != "" {
+		loadNpmrcFile(config)
+	}
+	if config.NpmRegistry == "" {
+		config.NpmRegistry = npmRegistry
+	}
 	if len(config.NpmScopedRegistries) > 0 {
 		regs := make(map[string]NpmRegistry)
 		for scope, rc := range config.NpmScopedRegistries {
 			if strings.HasPrefix(scope, "@") && isHttpSepcifier(rc.Registry) {
 				rc.Registry = strings.TrimRight(rc.Registry, "/") + "/"
+				rc.Mirrors = normalizeRegistryMirrors(rc.Mirrors)
 				regs[scope] = rc
 			} else {
 				fmt.Printf("[error] invalid npm registry for scope %s: %s\n", scope, rc.Registry)
@@ -234,6 +279,19 @@ func normalizeConfig(config *Config) {
 		}
 		config.NpmScopedRegistries = regs
 	}
+	if len(config.GitHosts) > 0 {
+		hosts := make(map[string]GitHost)
+		for host, h := range config.GitHosts {
+			u, err := url.Parse(h.Url)
+			if err != nil || !valid.IsDomain(host) || (u.Scheme != "https" && u.Scheme != "http" && u.Scheme != "ssh" && u.Scheme != "file") {
+				fmt.Printf("[error] invalid git host %s: %s\n", host, h.Url)
+				continue
+			}
+			h.Url = strings.TrimRight(h.Url, "/") + "/"
+			hosts[host] = h
+		}
+		config.GitHosts = hosts
+	}
 	if config.NpmQueryCacheTTL == 0 {
 		v := os.Getenv("NPM_QUERY_CACHE_TTL")
 		if v != "" {
@@ -246,6 +304,32 @@ func normalizeConfig(config *Config) {
 		}
 		config.NpmQueryCacheTTL = 600
 	}
+	if config.TypeScriptVersion == "" {
+		config.TypeScriptVersion = os.Getenv("TYPESCRIPT_VERSION")
+		if config.TypeScriptVersion == "" {
+			config.TypeScriptVersion = "5.7"
+		}
+	}
+	if _, err := semver.NewVersion(config.TypeScriptVersion); err != nil {
+		fmt.Println(term.Red("[error] invalid typescriptVersion: " + config.TypeScriptVersion))
+	}
+	if config.BanList.MinPublishAge != "" {
+		if _, err := time.ParseDuration(config.BanList.MinPublishAge); err != nil {
+			fmt.Println(term.Red("[error] invalid minPublishAge of banList: " + config.BanList.MinPublishAge))
+		}
+	}
+	if config.Advisories.Path == "" {
+		config.Advisories.Path = os.Getenv("ADVISORIES_PATH")
+	}
+	if config.Advisories.ReloadInterval == 0 {
+		config.Advisories.ReloadInterval = 300 // 5 minutes
+	}
+	if config.Advisories.Block != "" && getSeverityLevel(config.Advisories.Block) == 0 {
+		fmt.Println(term.Red("[error] invalid block severity of advisories: " + config.Advisories.Block))
+	}
+	if config.SigningKeyRaw == "" {
+		config.SigningKeyRaw = os.Getenv("SIGNING_KEY")
+	}
 	config.Compress = !(bytes.Equal(config.CompressRaw, []byte("false")) || os.Getenv("COMPRESS") == "false")
 	config.SourceMap = !(bytes.Equal(config.SourceMapRaw, []byte("false")) || (os.Getenv("SOURCEMAP") == "false" || os.Getenv("SOURCE_MAP") == "false"))
 	config.Minify = !(bytes.Equal(config.MinifyRaw, []byte("false")) || os.Getenv("MINIFY") == "false")
@@ -276,9 +360,10 @@ func extractPackageName(packageName string) (fullNameWithoutVersion string, scop
 // so the `excludes` list in the `scopes` list won't take effect if the package is banned in `packages` list
 func (banList *BanList) IsPackageBanned(fullName string) bool {
 	fullNameWithoutVersion, scope, nameWithoutVersionScope := extractPackageName(fullName)
+	version := extractPackageVersion(fullName)
 
 	for _, p := range banList.Packages {
-		if fullNameWithoutVersion == p {
+		if matchPackageRule(p, fullNameWithoutVersion, version) {
 			return true
 		}
 	}
@@ -301,9 +386,10 @@ func (allowList *AllowList) IsPackageAllowed(fullName string) bool {
 	}
 
 	fullNameWithoutVersion, scope, _ := extractPackageName(fullName)
+	version := extractPackageVersion(fullName)
 
 	for _, p := range allowList.Packages {
-		if fullNameWithoutVersion == p {
+		if matchPackageRule(p, fullNameWithoutVersion, version) {
 			return true
 		}
 	}
@@ -317,6 +403,81 @@ func (allowList *AllowList) IsPackageAllowed(fullName string) bool {
 	return false
 }
 
+// GetMinPublishAge returns the `minPublishAge` option of the ban list, e.g. "24h".
+func (banList *BanList) GetMinPublishAge() time.Duration {
+	if banList.MinPublishAge == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(banList.MinPublishAge)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// extractPackageVersion returns the version of the package name, e.g. "@github/faker@1.5.0/es2022/faker.mjs" -> "1.5.0"
+func extractPackageVersion(packageName string) string {
+	_, version := splitPackageRule(packageName)
+	version, _ = utils.SplitByFirstByte(version, '/')
+	return version
+}
+
+// splitPackageRule splits the rule into the name pattern and the version range, e.g. "lodash@<4.17.21" -> "lodash", "<4.17.21"
+func splitPackageRule(rule string) (name string, version string) {
+	if len(rule) > 1 {
+		if i := strings.IndexByte(rule[1:], '@'); i >= 0 {
+			return rule[:i+1], rule[i+2:]
+		}
+	}
+	return rule, ""
+}
+
+// matchPackageRule checks if the package matches the rule of the `packages` list. The name of the rule can be
+// a glob pattern that `*` matches any characters, e.g. "@evil/*" or "*-malware-*", and the rule with a semver
+// range only matches the versions in the range, e.g. "lodash@<4.17.21".
+func matchPackageRule(rule string, name string, version string) bool {
+	pattern, versionRange := splitPackageRule(strings.TrimSpace(rule))
+	if !matchGlob(pattern, name) {
+		return false
+	}
+	if versionRange == "" {
+		return true
+	}
+	if version == "" {
+		return false
+	}
+	c, err := semver.NewConstraint(versionRange)
+	if err != nil {
+		return false
+	}
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return false
+	}
+	return c.Check(v)
+}
+
+// matchGlob checks if the name matches the glob pattern, the `*` matches any characters including `/`.
+func matchGlob(pattern string, name string) bool {
+	if !strings.ContainsRune(pattern, '*') {
+		return pattern == name
+	}
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(name, parts[0]) {
+		return false
+	}
+	name = name[len(parts[0]):]
+	last := len(parts) - 1
+	for _, part := range parts[1:last] {
+		i := strings.Index(name, part)
+		if i < 0 {
+			return false
+		}
+		name = name[i+len(part):]
+	}
+	return strings.HasSuffix(name, parts[last])
+}
+
 func isPackageExcluded(name string, excludes []string) bool {
 	for _, exclude := range excludes {
 		if name == exclude {
@@ -326,6 +487,51 @@ func isPackageExcluded(name string, excludes []string) bool {
 	return false
 }
 
+// loadNpmrcFile loads the `.npmrc` file, only the options that are present in the file and are not set
+// by the config or the env are taken from the file.
+func loadNpmrcFile(config *Config) {
+	filename := config.Npmrc
+	if strings.HasPrefix(filename, "~/") {
+		homeDir, err := os.UserHomeDir()
+		if err == nil {
+			filename = path.Join(homeDir, filename[2:])
+		}
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Printf("[error] failed to read npmrc file: %v\n", err)
+		return
+	}
+	rc, auths, err := parseNpmrcIni(data, true)
+	if err != nil {
+		fmt.Printf("[error] invalid npmrc file %s: %v\n", config.Npmrc, err)
+		return
+	}
+	if config.NpmRegistry == "" {
+		// empty if the `registry` key is not present in the file
+		config.NpmRegistry = rc.Registry
+	}
+	if config.NpmToken == "" && config.NpmUser == "" {
+		reg := NpmRegistry{Registry: config.NpmRegistry}
+		if reg.Registry == "" {
+			reg.Registry = npmRegistry
+		}
+		applyNpmrcAuths(&reg, auths)
+		config.NpmToken = reg.Token
+		config.NpmUser = reg.User
+		config.NpmPassword = reg.Password
+	}
+	for scope, reg := range rc.ScopedRegistries {
+		reg.applyAuth(findNpmrcAuth(auths, reg.Registry))
+		if config.NpmScopedRegistries == nil {
+			config.NpmScopedRegistries = map[string]NpmRegistry{}
+		}
+		if _, ok := config.NpmScopedRegistries[scope]; !ok {
+			config.NpmScopedRegistries[scope] = reg
+		}
+	}
+}
+
 func init() {
 	config = DefaultConfig()
 }
@@ -282,3 +282,38 @@ func TestBanList_IsPackageBanned(t *testing.T) {
 		})
 	}
 }
+
+func TestMatchPackageRule(t *testing.T) {
+	tests := []struct {
+		rule    string
+		name    string
+		version string
+		want    bool
+	}{
+		{"lodash", "lodash", "4.17.20", true},
+		{"lodash", "lodash-es", "4.17.20", false},
+		{"lodash@<4.17.21", "lodash", "4.17.20", true},
+		{"lodash@<4.17.21", "lodash", "4.17.21", false},
+		{"lodash@<4.17.21", "lodash", "", false},
+		{"@evil/*", "@evil/foo", "1.0.0", true},
+		{"@evil/*", "@good/foo", "1.0.0", false},
+		{"@evil/*@>=2", "@evil/foo", "1.0.0", false},
+		{"*-malware-*", "foo-malware-bar", "1.0.0", true},
+		{"*-malware-*", "@scope/foo-malware-bar", "1.0.0", true},
+		{"*-malware-*", "foo-malware", "1.0.0", false},
+	}
+	for _, tt := range tests {
+		if got := matchPackageRule(tt.rule, tt.name, tt.version); got != tt.want {
+			t.Errorf("matchPackageRule(%q, %q, %q) = %v, want %v", tt.rule, tt.name, tt.version, got, tt.want)
+		}
+	}
+
+	banList := BanList{Packages: []string{"lodash@<4.17.21"}}
+	if !banList.IsPackageBanned("lodash@4.17.20/es2022/lodash.mjs") || banList.IsPackageBanned("lodash@4.17.21") {
+		t.Error("IsPackageBanned() should match the version range")
+	}
+	allowList := AllowList{Packages: []string{"react@^19.0.0"}}
+	if !allowList.IsPackageAllowed("react@19.1.0") || allowList.IsPackageAllowed("react@18.3.1") {
+		t.Error("IsPackageAllowed() should match the version range")
+	}
+}
@@ -0,0 +1,303 @@
+package server
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// data files that can be imported as es modules with the `?module` query
+var dataModuleExts = map[string]bool{
+	".yaml": true,
+	".yml":  true,
+	".toml": true,
+	".csv":  true,
+	".txt":  true,
+	".glsl": true,
+	".wgsl": true,
+	".frag": true,
+	".vert": true,
+	".html": true,
+}
+
+// isDataModuleFile returns true if the file can be imported as an es module with the `?module` query.
+func isDataModuleFile(filename string) bool {
+	return dataModuleExts[path.Ext(filename)]
+}
+
+// toDataModule converts the data file to an es module, the structured formats(yaml, toml and csv)
+// are parsed to JSON, and the text formats are exported as string.
+func toDataModule(filename string, data []byte) ([]byte, error) {
+	buf := bytes.NewBufferString("export default ")
+	switch path.Ext(filename) {
+	case ".yaml", ".yml":
+		// decode the document first to apply the alias limits(e.g. "billion laughs") and the recursion checks
+		// of the yaml decoder, the nodes are used to keep the keys order of the mappings.
+		var v any
+		if err := yaml.Unmarshal(data, &v); err != nil {
+			return nil, fmt.Errorf("invalid yaml: %v", err)
+		}
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("invalid yaml: %v", err)
+		}
+		if err := writeYAMLJSON(buf, &doc); err != nil {
+			return nil, fmt.Errorf("invalid yaml: %v", err)
+		}
+	case ".toml":
+		v, err := parseTOML(data)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeDataJSON(buf, v); err != nil {
+			return nil, err
+		}
+	case ".csv":
+		if err := writeCSVJSON(buf, data); err != nil {
+			return nil, err
+		}
+	default:
+		if !utf8.Valid(data) {
+			return nil, errors.New("invalid utf-8 text")
+		}
+		s, err := json.Marshal(string(data))
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(s)
+	}
+	buf.WriteString(";\n")
+	return buf.Bytes(), nil
+}
+
+// writeCSVJSON writes the csv data as an array of objects, the first row is used as the keys.
+func writeCSVJSON(buf *bytes.Buffer, data []byte) error {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return fmt.Errorf("invalid csv: %v", err)
+	}
+	buf.WriteByte('[')
+	if len(records) > 0 {
+		header := records[0]
+		for i, record := range records[1:] {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('{')
+			for j, key := range header {
+				if j > 0 {
+					buf.WriteByte(',')
+				}
+				value := ""
+				if j < len(record) {
+					value = record[j]
+				}
+				writeJSONString(buf, key)
+				buf.WriteByte(':')
+				writeJSONString(buf, value)
+			}
+			buf.WriteByte('}')
+		}
+	}
+	buf.WriteByte(']')
+	return nil
+}
+
+// writeDataJSON writes the value as JSON, the keys order of the objects is kept.
+func writeDataJSON(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case *JSONObject:
+		buf.WriteByte('{')
+		for i, key := range v.keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeJSONString(buf, key)
+			buf.WriteByte(':')
+			if err := writeDataJSON(buf, v.values[key]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case []any:
+		buf.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeDataJSON(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case float64:
+		// JSON doesn't support NaN and Infinity
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			buf.WriteString("null")
+			return nil
+		}
+		buf.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+	}
+	return nil
+}
+
+// writeYAMLJSON writes the yaml node as JSON, the keys order of the mappings is kept.
+func writeYAMLJSON(buf *bytes.Buffer, node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			buf.WriteString("null")
+			return nil
+		}
+		return writeYAMLJSON(buf, node.Content[0])
+	case yaml.AliasNode:
+		return writeYAMLJSON(buf, node.Alias)
+	case yaml.SequenceNode:
+		buf.WriteByte('[')
+		for i, item := range node.Content {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeYAMLJSON(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case yaml.MappingNode:
+		keys, values := []string{}, map[string]*yaml.Node{}
+		var collect func(node *yaml.Node)
+		collect = func(node *yaml.Node) {
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key, value := node.Content[i], node.Content[i+1]
+				// merge key, e.g. `<<: *base`
+				if key.Tag == "!!merge" {
+					if value.Kind == yaml.AliasNode {
+						value = value.Alias
+					}
+					if value.Kind == yaml.SequenceNode {
+						for _, item := range value.Content {
+							if item.Kind == yaml.AliasNode {
+								item = item.Alias
+							}
+							collect(item)
+						}
+					} else {
+						collect(value)
+					}
+					continue
+				}
+				if _, ok := values[key.Value]; !ok {
+					keys = append(keys, key.Value)
+				}
+				values[key.Value] = value
+			}
+		}
+		collect(node)
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeJSONString(buf, key)
+			buf.WriteByte(':')
+			if err := writeYAMLJSON(buf, values[key]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		var v any
+		if err := node.Decode(&v); err != nil {
+			return err
+		}
+		return writeDataJSON(buf, v)
+	}
+	return nil
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) {
+	data, _ := json.Marshal(s)
+	buf.Write(data)
+}
+
+// parseTOML parses the TOML document, the tables are returned as `*JSONObject` with the keys in the document order,
+// and the date and time values are converted to strings.
+func parseTOML(data []byte) (*JSONObject, error) {
+	var v map[string]any
+	md, err := toml.Decode(string(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))), &v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid toml: %v", err)
+	}
+	order := map[string]int{}
+	for i, key := range md.Keys() {
+		// the implicit tables of the dotted keys are not in the keys, e.g. `a.b.c = 1`
+		for j := 1; j <= len(key); j++ {
+			if _, ok := order[key[:j].String()]; !ok {
+				order[key[:j].String()] = i
+			}
+		}
+	}
+	return toTOMLValue(v, nil, order).(*JSONObject), nil
+}
+
+// toTOMLValue converts the decoded TOML value, the `order` is the positions of the keys in the document.
+func toTOMLValue(v any, key toml.Key, order map[string]int) any {
+	switch v := v.(type) {
+	case map[string]any:
+		obj := &JSONObject{keys: make([]string, 0, len(v)), values: make(map[string]any, len(v))}
+		pos := make(map[string]int, len(v))
+		for k, value := range v {
+			subKey := append(key[:len(key):len(key)], k)
+			obj.keys = append(obj.keys, k)
+			obj.values[k] = toTOMLValue(value, subKey, order)
+			pos[k] = order[subKey.String()]
+		}
+		sort.Slice(obj.keys, func(i, j int) bool {
+			return pos[obj.keys[i]] < pos[obj.keys[j]]
+		})
+		return obj
+	case []map[string]any:
+		arr := make([]any, len(v))
+		for i, item := range v {
+			arr[i] = toTOMLValue(item, key, order)
+		}
+		return arr
+	case []any:
+		arr := make([]any, len(v))
+		for i, item := range v {
+			arr[i] = toTOMLValue(item, key, order)
+		}
+		return arr
+	case time.Time:
+		// the local date and time values have no offsets
+		switch v.Location().String() {
+		case "date-local":
+			return v.Format("2006-01-02")
+		case "time-local":
+			return v.Format("15:04:05.999999999")
+		case "datetime-local":
+			return v.Format("2006-01-02T15:04:05.999999999")
+		}
+		return v.Format(time.RFC3339Nano)
+	}
+	return v
+}
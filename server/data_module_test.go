@@ -0,0 +1,74 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDataModule(t *testing.T) {
+	tests := []struct {
+		filename string
+		data     string
+		expected string
+	}{
+		{
+			filename: "config.toml",
+			data: `# comment
+title = "TOML \"Example\""
+ports = [ 8000, 8001, 0x1F ]
+pi = 3.14
+dob = 1979-05-27 07:32:00
+inline = { a = 1, b.c = true }
+
+[owner]
+name = 'Tom'
+
+[[products]]
+name = "Hammer"
+
+[[products]]
+name = "Nail"
+`,
+			expected: `export default {"title":"TOML \"Example\"","ports":[8000,8001,31],"pi":3.14,"dob":"1979-05-27T07:32:00","inline":{"a":1,"b":{"c":true}},"owner":{"name":"Tom"},"products":[{"name":"Hammer"},{"name":"Nail"}]};` + "\n",
+		},
+		{
+			filename: "config.yaml",
+			data:     "b: 1\na:\n  - x\n  - y: true\n",
+			expected: `export default {"b":1,"a":["x",{"y":true}]};` + "\n",
+		},
+		{
+			filename: "data.csv",
+			data:     "name,age\nfoo,1\n\"bar, baz\",2\n",
+			expected: `export default [{"name":"foo","age":"1"},{"name":"bar, baz","age":"2"}];` + "\n",
+		},
+		{
+			filename: "shader.wgsl",
+			data:     "fn main() {\n}\n",
+			expected: `export default "fn main() {\n}\n";` + "\n",
+		},
+	}
+	for _, test := range tests {
+		js, err := toDataModule(test.filename, []byte(test.data))
+		if err != nil {
+			t.Fatalf("%s: %v", test.filename, err)
+		}
+		if string(js) != test.expected {
+			t.Fatalf("%s: unexpected output: %s", test.filename, js)
+		}
+	}
+
+	_, err := parseTOML([]byte("a = 1\na = 2\n"))
+	if err == nil || !strings.HasPrefix(err.Error(), "invalid toml: ") {
+		t.Fatalf("expected duplicate key error, got %v", err)
+	}
+
+	// the recursive aliases and the "billion laughs" documents should be rejected
+	for _, data := range []string{
+		"a: &a [*a]\n",
+		"a: &a [x, x, x, x, x, x, x, x, x]\nb: &b [*a, *a, *a, *a, *a, *a, *a, *a, *a]\nc: &c [*b, *b, *b, *b, *b, *b, *b, *b, *b]\nd: &d [*c, *c, *c, *c, *c, *c, *c, *c, *c]\ne: &e [*d, *d, *d, *d, *d, *d, *d, *d, *d]\nf: &f [*e, *e, *e, *e, *e, *e, *e, *e, *e]\ng: &g [*f, *f, *f, *f, *f, *f, *f, *f, *f]\n",
+	} {
+		if _, err := toDataModule("data.yaml", []byte(data)); err == nil {
+			t.Fatalf("expected an error for %q", data)
+		}
+	}
+}
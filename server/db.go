@@ -4,5 +4,6 @@ type Database interface {
 	Get(key string) (value []byte, err error)
 	Put(key string, value []byte) (err error)
 	Delete(key string) error
+	List(prefix string) (keys []string, err error)
 	Close() error
 }
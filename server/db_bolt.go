@@ -1,6 +1,8 @@
 package server
 
 import (
+	"bytes"
+
 	bolt "go.etcd.io/bbolt"
 )
 
@@ -45,6 +47,18 @@ func (db *boltDB) Delete(key string) error {
 	})
 }
 
+func (db *boltDB) List(prefix string) (keys []string, err error) {
+	err = db.bolt.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(defaultBucket)).Cursor()
+		p := []byte(prefix)
+		for k, _ := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, _ = c.Next() {
+			keys = append(keys, string(k))
+		}
+		return nil
+	})
+	return
+}
+
 func (db *boltDB) Close() error {
 	return db.bolt.Close()
 }
@@ -0,0 +1,960 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/esm-dev/esm.sh/server/storage"
+	"github.com/ije/gox/set"
+)
+
+var (
+	regexpDtsModuleStmt          = regexp.MustCompile(`^(import\s*[\w$*{'"]|import\s+type\b|export\b)`)
+	regexpDtsImportFrom          = regexp.MustCompile(`^import\s*(type\s+)?([\s\S]+?)\s*from\s*['"]([^'"]+)['"]`)
+	regexpDtsImportSideEffect    = regexp.MustCompile(`^import\s*['"]([^'"]+)['"]`)
+	regexpDtsImportRequire       = regexp.MustCompile(`^(export\s+)?import\s+([\w$]+)\s*=\s*require\s*\(\s*['"]([^'"]+)['"]\s*\)`)
+	regexpDtsImportEquals        = regexp.MustCompile(`^export\s+import\s+([\w$]+)\s*=`)
+	regexpDtsExportStar          = regexp.MustCompile(`^export\s+(type\s+)?\*\s*(?:as\s+([\w$]+)\s*)?from\s*['"]([^'"]+)['"]`)
+	regexpDtsExportFrom          = regexp.MustCompile(`^export\s+(type\s+)?\{([\s\S]*?)\}\s*from\s*['"]([^'"]+)['"]`)
+	regexpDtsExportLocal         = regexp.MustCompile(`^export\s+(type\s+)?\{([\s\S]*?)\}\s*;?$`)
+	regexpDtsExportDefault       = regexp.MustCompile(`^export\s+default\s+([\w$.]+)\s*;?$`)
+	regexpDtsExportDefaultPrefix = regexp.MustCompile(`^export\s+default\s+`)
+	regexpDtsExportDefaultDecl   = regexp.MustCompile(`^export\s+default\s+((?:abstract\s+)?(?:class|function|interface))\b\s*([\w$]*)`)
+	regexpDtsExportEquals        = regexp.MustCompile(`^export\s*=\s*([\w$.]+)`)
+	regexpDtsExportAsNamespace   = regexp.MustCompile(`^export\s+as\s+namespace\s`)
+	regexpDtsDeclareGlobal       = regexp.MustCompile(`^declare\s+(global\b|module\s*['"])`)
+	regexpDtsDeclareModule       = regexp.MustCompile(`^(declare\s+module\s*)(['"])([^'"]+)['"]`)
+	regexpDtsDecl                = regexp.MustCompile(`^(export\s+)?(declare\s+)?((?:abstract\s+)?(?:const\s+enum|const|let|var|function|class|interface|type|enum|namespace|module)\s+([\w$]+))`)
+	regexpDtsImportCall          = regexp.MustCompile(`import\s*\(\s*['"]([^'"]+)['"]\s*\)(\.default\b)?`)
+	regexpDtsReference           = regexp.MustCompile(`(?m)^[ \t]*///[ \t]*<reference\s+(path|types|lib)\s*=\s*['"]([^'"]+)['"][^>\n]*>[ \t]*\r?\n?`)
+	regexpDtsSourceMappingURL    = regexp.MustCompile(`(?m)^[ \t]*//# sourceMappingURL=.*\r?\n?`)
+	regexpDtsRelSpecifier        = regexp.MustCompile(`['"](\.\.?/[^'"\n]+)['"]`)
+	regexpDtsComment             = regexp.MustCompile(`/\*[\s\S]*?\*/|//[^\n]*`)
+	regexpDtsAs                  = regexp.MustCompile(`\s+as\s+`)
+)
+
+// keywords that start a new statement in a `.d.ts` file
+var dtsStmtKeywords = []string{"export", "import", "declare", "interface", "type", "class", "function", "const", "let", "var", "namespace", "module", "enum", "abstract"}
+
+// a top-level statement of a `.d.ts` file
+type dtsStmt struct {
+	trivia string // leading comments and whitespaces
+	body   string
+}
+
+// a `.d.ts` file in the bundle
+type dtsBundleFile struct {
+	key           string
+	ident         string
+	stmts         []dtsStmt
+	isModule      bool
+	exportEquals  bool
+	ambientModule bool
+	exportNames   *set.Set[string]
+	needsExport   *set.Set[string]
+	starFrom      []string
+	externalStars []string
+	defaultDone   bool
+}
+
+type dtsBundler struct {
+	readFile   func(key string) ([]byte, error)
+	entry      *dtsBundleFile
+	files      map[string]*dtsBundleFile
+	order      []*dtsBundleFile
+	references []string
+	imports    []string
+	externals  map[string]string
+	hoisted    []string
+	globals    *set.Set[string]
+	starNames  map[string][]string
+}
+
+// a module that is referenced by a `.d.ts` file, either an inlined file or an external url
+type dtsModuleRef struct {
+	file *dtsBundleFile
+	url  string
+}
+
+// getBundledDTS returns the single-file bundle of a transformed `.d.ts` file, the relative dependencies are
+// inlined as namespaces and the external packages are kept as CDN urls. The bundle is cached in the storage.
+func getBundledDTS(buildStorage storage.Storage, savePath string) ([]byte, error) {
+	bundlePath := savePath + ".bundle"
+	r, _, err := buildStorage.Get(bundlePath)
+	if err == nil {
+		defer r.Close()
+		return io.ReadAll(r)
+	}
+	if err != storage.ErrNotFound {
+		return nil, err
+	}
+	dts, err := bundleDTS(savePath, func(key string) ([]byte, error) {
+		r, _, err := buildStorage.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = buildStorage.Put(bundlePath, strings.NewReader(string(dts)))
+	if err != nil {
+		return nil, err
+	}
+	return dts, nil
+}
+
+// bundleDTS bundles the `.d.ts` file and its relative dependencies into a single file.
+func bundleDTS(entryKey string, readFile func(key string) ([]byte, error)) ([]byte, error) {
+	b := &dtsBundler{
+		readFile:  readFile,
+		files:     map[string]*dtsBundleFile{},
+		externals: map[string]string{},
+		globals:   set.New[string](),
+		starNames: map[string][]string{},
+	}
+	data, err := readFile(entryKey)
+	if err != nil {
+		return nil, err
+	}
+	b.entry = b.load(entryKey, data)
+	// a script file(global declarations) can't be bundled as a module
+	if !b.entry.isModule {
+		return data, nil
+	}
+	for i := 0; i < len(b.order); i++ {
+		f := b.order[i]
+		for _, stmt := range f.stmts {
+			for _, m := range regexpDtsReference.FindAllStringSubmatch(stmt.trivia, -1) {
+				if m[1] == "path" {
+					b.resolve(f, m[2])
+				}
+			}
+			for _, m := range regexpDtsRelSpecifier.FindAllStringSubmatch(stmt.body, -1) {
+				b.resolve(f, m[1])
+			}
+		}
+	}
+	for _, f := range b.order {
+		b.analyze(f)
+	}
+
+	entryCode := b.emit(b.entry, false)
+	var namespaces []string
+	for _, f := range b.order[1:] {
+		if f.isModule {
+			namespaces = append(namespaces, fmt.Sprintf("// %s\ndeclare namespace %s {\n%s\n}\n", b.rebase(f.key), f.ident, strings.TrimSpace(b.emit(f, true))))
+		}
+	}
+
+	buf, recycle := NewBuffer()
+	defer recycle()
+	fmt.Fprintf(buf, "/* esm.sh - bundled types of %s */\n", path.Base(entryKey))
+	for _, ref := range b.references {
+		buf.WriteString(ref)
+		buf.WriteByte('\n')
+	}
+	for _, imp := range b.imports {
+		buf.WriteString(imp)
+		buf.WriteByte('\n')
+	}
+	buf.WriteString(strings.TrimSpace(entryCode))
+	buf.WriteByte('\n')
+	for _, code := range b.hoisted {
+		buf.WriteByte('\n')
+		buf.WriteString(strings.TrimSpace(code))
+		buf.WriteByte('\n')
+	}
+	for _, code := range namespaces {
+		buf.WriteByte('\n')
+		buf.WriteString(code)
+	}
+	return []byte(buf.String()), nil
+}
+
+func (b *dtsBundler) load(key string, data []byte) *dtsBundleFile {
+	f := &dtsBundleFile{
+		key:         key,
+		ident:       fmt.Sprintf("__dts%d$", len(b.order)),
+		stmts:       splitDtsStmts(regexpDtsSourceMappingURL.ReplaceAllString(string(data), "")),
+		exportNames: set.New[string](),
+		needsExport: set.New[string](),
+	}
+	for _, stmt := range f.stmts {
+		if regexpDtsModuleStmt.MatchString(stmt.body) {
+			f.isModule = true
+		} else if regexpDtsDeclareModule.MatchString(stmt.body) {
+			f.ambientModule = true
+		}
+	}
+	b.files[key] = f
+	b.order = append(b.order, f)
+	return f
+}
+
+// resolve resolves the specifier imported by the file, only the relative `.d.ts` files are inlined.
+func (b *dtsBundler) resolve(from *dtsBundleFile, specifier string) dtsModuleRef {
+	if !isRelPathSpecifier(specifier) {
+		return dtsModuleRef{url: specifier}
+	}
+	key := path.Join(path.Dir(from.key), specifier)
+	f, ok := b.files[key]
+	if !ok {
+		b.files[key] = nil
+		if endsWith(key, ".d.ts", ".d.mts", ".d.cts") {
+			if data, err := b.readFile(key); err == nil {
+				f = b.load(key, data)
+			}
+		}
+	}
+	// script files with ambient modules can't be inlined
+	if f != nil && (f.isModule || !f.ambientModule) {
+		return dtsModuleRef{file: f}
+	}
+	return dtsModuleRef{url: b.rebase(key)}
+}
+
+// rebase returns the path of the file that is relative to the bundle.
+func (b *dtsBundler) rebase(key string) string {
+	p, err := relPath(path.Dir(b.entry.key), key)
+	if err != nil {
+		return key
+	}
+	return p
+}
+
+func (b *dtsBundler) analyze(f *dtsBundleFile) {
+	for _, stmt := range f.stmts {
+		body := stmt.body
+		if m := regexpDtsExportStar.FindStringSubmatch(body); m != nil {
+			if m[2] != "" {
+				f.exportNames.Add(m[2])
+			} else if ref := b.resolve(f, m[3]); ref.file != nil {
+				f.starFrom = append(f.starFrom, ref.file.key)
+			} else {
+				f.externalStars = append(f.externalStars, ref.url)
+			}
+		} else if m := regexpDtsExportFrom.FindStringSubmatch(body); m != nil {
+			for _, s := range parseDtsSpecifiers(m[2]) {
+				f.exportNames.Add(s[1])
+			}
+		} else if m := regexpDtsExportLocal.FindStringSubmatch(body); m != nil {
+			for _, s := range parseDtsSpecifiers(m[2]) {
+				f.exportNames.Add(s[1])
+				if s[0] == s[1] {
+					f.needsExport.Add(s[0])
+				}
+			}
+		} else if regexpDtsExportEquals.MatchString(body) {
+			f.exportEquals = true
+		} else if m := regexpDtsImportEquals.FindStringSubmatch(body); m != nil {
+			f.exportNames.Add(m[1])
+		} else if m := regexpDtsImportRequire.FindStringSubmatch(body); m != nil && m[1] != "" {
+			f.exportNames.Add(m[2])
+		} else if m := regexpDtsDecl.FindStringSubmatch(body); m != nil && m[1] != "" {
+			f.exportNames.Add(m[4])
+		}
+	}
+	f.exportNames.Remove("default")
+
+	// the `declare global` blocks are hoisted out of the namespace, the local declarations used in the blocks must be exported
+	if f.isModule && f != b.entry {
+		for _, stmt := range f.stmts {
+			if m := regexpDtsDeclareGlobal.FindStringSubmatch(stmt.body); m != nil && m[1] == "global" {
+				names := b.getLocalNames(f)
+				replaceDtsIdents(stmt.body, func(name string) string {
+					if _, ok := names[name]; ok {
+						f.needsExport.Add(name)
+					}
+					return name
+				})
+			}
+		}
+	}
+}
+
+// getStarNames returns the names that are exported by the `export * from "..."` statement.
+func (b *dtsBundler) getStarNames(f *dtsBundleFile, seen *set.Set[string]) []string {
+	if names, ok := b.starNames[f.key]; ok {
+		return names
+	}
+	if seen.Has(f.key) {
+		return nil
+	}
+	seen.Add(f.key)
+	names := set.New[string]()
+	for _, name := range f.exportNames.Values() {
+		names.Add(name)
+	}
+	for _, key := range f.starFrom {
+		for _, name := range b.getStarNames(b.files[key], seen) {
+			names.Add(name)
+		}
+	}
+	b.starNames[f.key] = names.Values()
+	return b.starNames[f.key]
+}
+
+// getExternalStars returns the external modules that are re-exported by the `export * from "..."` statement.
+func (b *dtsBundler) getExternalStars(f *dtsBundleFile, seen *set.Set[string]) (urls []string) {
+	if seen.Has(f.key) {
+		return
+	}
+	seen.Add(f.key)
+	urls = append(urls, f.externalStars...)
+	for _, key := range f.starFrom {
+		urls = append(urls, b.getExternalStars(b.files[key], seen)...)
+	}
+	return
+}
+
+// getExternalAlias returns the namespace alias of the external module that is used in the namespaces.
+func (b *dtsBundler) getExternalAlias(url string) string {
+	if alias, ok := b.externals[url]; ok {
+		return alias
+	}
+	alias := fmt.Sprintf("__dts_ext%d$", len(b.externals))
+	b.externals[url] = alias
+	b.imports = append(b.imports, fmt.Sprintf(`import * as %s from "%s";`, alias, url))
+	return alias
+}
+
+func (b *dtsBundler) nsExpr(ref dtsModuleRef) string {
+	if ref.file != nil {
+		if ref.file.exportEquals {
+			return ref.file.ident + ".$default"
+		}
+		return ref.file.ident
+	}
+	return b.getExternalAlias(ref.url)
+}
+
+func (b *dtsBundler) memberExpr(ref dtsModuleRef, name string) string {
+	if name == "default" {
+		if ref.file != nil {
+			return ref.file.ident + ".$default"
+		}
+		return b.getExternalAlias(ref.url) + ".default"
+	}
+	return b.nsExpr(ref) + "." + name
+}
+
+// emit returns the code of the file, the relative imports and exports are rewritten to the namespaces.
+func (b *dtsBundler) emit(f *dtsBundleFile, inNS bool) string {
+	buf, recycle := NewBuffer()
+	defer recycle()
+	emitted := set.New[string]()
+	promoted := b.getPromotedNames(f, inNS)
+	for _, stmt := range f.stmts {
+		trivia := regexpDtsReference.ReplaceAllStringFunc(stmt.trivia, func(s string) string {
+			m := regexpDtsReference.FindStringSubmatch(s)
+			specifier := m[2]
+			if m[1] == "path" {
+				ref := b.resolve(f, specifier)
+				if ref.file != nil {
+					b.include(ref.file)
+					return ""
+				}
+				specifier = ref.url
+			}
+			b.addReference(fmt.Sprintf(`/// <reference %s="%s" />`, m[1], specifier))
+			return ""
+		})
+		buf.WriteString(trivia)
+		buf.WriteString(b.transformStmt(f, stmt.body, inNS, emitted, promoted))
+	}
+	return buf.String()
+}
+
+// getPromotedNames returns the imported names that are re-exported by the file,
+// they are declared with `export import` to avoid duplicate identifiers.
+func (b *dtsBundler) getPromotedNames(f *dtsBundleFile, inNS bool) *set.Set[string] {
+	imported := set.New[string]()
+	for _, stmt := range f.stmts {
+		if m := regexpDtsImportFrom.FindStringSubmatch(stmt.body); m != nil {
+			if ref := b.resolve(f, m[3]); ref.file != nil || inNS {
+				defaultName, nsName, named := parseDtsImportClause(m[2])
+				imported.Add(defaultName)
+				imported.Add(nsName)
+				for _, s := range named {
+					imported.Add(s[1])
+				}
+			}
+		} else if m := regexpDtsImportRequire.FindStringSubmatch(stmt.body); m != nil && m[1] == "" {
+			if ref := b.resolve(f, m[3]); ref.file != nil || inNS {
+				imported.Add(m[2])
+			}
+		}
+	}
+	promoted := set.New[string]()
+	for _, stmt := range f.stmts {
+		if m := regexpDtsExportStar.FindStringSubmatch(stmt.body); m != nil {
+			if m[2] != "" {
+				if imported.Has(m[2]) {
+					promoted.Add(m[2])
+				}
+			} else if ref := b.resolve(f, m[3]); ref.file != nil {
+				for _, name := range b.getStarNames(ref.file, set.New[string]()) {
+					if imported.Has(name) && !f.exportNames.Has(name) {
+						promoted.Add(name)
+					}
+				}
+			}
+		} else if m := regexpDtsExportFrom.FindStringSubmatch(stmt.body); m != nil {
+			for _, s := range parseDtsSpecifiers(m[2]) {
+				if imported.Has(s[1]) {
+					promoted.Add(s[1])
+				}
+			}
+		}
+	}
+	return promoted
+}
+
+func (b *dtsBundler) addReference(ref string) {
+	if !stringInSlice(b.references, ref) {
+		b.references = append(b.references, ref)
+	}
+}
+
+func (b *dtsBundler) addImport(imp string) {
+	if !stringInSlice(b.imports, imp) {
+		b.imports = append(b.imports, imp)
+	}
+}
+
+// include includes the script file(global declarations) in the `declare global` block.
+func (b *dtsBundler) include(f *dtsBundleFile) {
+	if f.isModule || b.globals.Has(f.key) {
+		return
+	}
+	b.globals.Add(f.key)
+	code := strings.TrimSpace(b.emit(f, true))
+	if code != "" {
+		b.hoisted = append(b.hoisted, fmt.Sprintf("// %s\ndeclare global {\n%s\n}", b.rebase(f.key), code))
+	}
+}
+
+func (b *dtsBundler) transformStmt(f *dtsBundleFile, body string, inNS bool, emitted *set.Set[string], promoted *set.Set[string]) string {
+	if body == "" {
+		return ""
+	}
+	exportPrefix := func(name string) string {
+		if inNS && f.needsExport.Has(name) {
+			return "export "
+		}
+		return ""
+	}
+	exportAlias := func(lines []string, name string, expr string) []string {
+		if emitted.Has(name) {
+			return lines
+		}
+		emitted.Add(name)
+		return append(lines, fmt.Sprintf("export import %s = %s;", name, expr))
+	}
+	importAlias := func(lines []string, name string, expr string) []string {
+		if promoted.Has(name) {
+			return exportAlias(lines, name, expr)
+		}
+		return append(lines, fmt.Sprintf("%simport %s = %s;", exportPrefix(name), name, expr))
+	}
+	exportDefault := func(lines []string, expr string) []string {
+		if f.defaultDone {
+			return lines
+		}
+		f.defaultDone = true
+		if inNS {
+			return append(lines, fmt.Sprintf("export import $default = %s;", expr))
+		}
+		return append(lines, fmt.Sprintf("import __dts_default$ = %s;", expr), "export default __dts_default$;")
+	}
+
+	if m := regexpDtsImportSideEffect.FindStringSubmatch(body); m != nil {
+		ref := b.resolve(f, m[1])
+		if ref.file != nil {
+			b.include(ref.file)
+			return ""
+		}
+		if inNS {
+			b.addImport(fmt.Sprintf(`import "%s";`, ref.url))
+			return ""
+		}
+		return body
+	}
+
+	if m := regexpDtsImportFrom.FindStringSubmatch(body); m != nil {
+		ref := b.resolve(f, m[3])
+		if ref.file == nil && !inNS {
+			return body
+		}
+		var lines []string
+		defaultName, nsName, named := parseDtsImportClause(m[2])
+		if defaultName != "" {
+			lines = importAlias(lines, defaultName, b.memberExpr(ref, "default"))
+		}
+		if nsName != "" {
+			lines = importAlias(lines, nsName, b.nsExpr(ref))
+		}
+		for _, s := range named {
+			lines = importAlias(lines, s[1], b.memberExpr(ref, s[0]))
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	if m := regexpDtsImportRequire.FindStringSubmatch(body); m != nil {
+		ref := b.resolve(f, m[3])
+		if ref.file == nil && !inNS {
+			return body
+		}
+		if m[1] != "" {
+			return strings.Join(exportAlias(nil, m[2], b.nsExpr(ref)), "\n")
+		}
+		return strings.Join(importAlias(nil, m[2], b.nsExpr(ref)), "\n")
+	}
+
+	if m := regexpDtsExportStar.FindStringSubmatch(body); m != nil {
+		ref := b.resolve(f, m[3])
+		if ref.file == nil && !inNS {
+			return body
+		}
+		if m[2] != "" {
+			return strings.Join(exportAlias(nil, m[2], b.nsExpr(ref)), "\n")
+		}
+		if ref.file == nil {
+			// the external stars are re-exported by the bundle
+			return ""
+		}
+		var lines []string
+		if !inNS {
+			for _, url := range b.getExternalStars(ref.file, set.New[string]()) {
+				line := fmt.Sprintf(`export * from "%s";`, url)
+				if !stringInSlice(b.imports, line) {
+					b.imports = append(b.imports, line)
+				}
+			}
+		}
+		for _, name := range b.getStarNames(ref.file, set.New[string]()) {
+			// local exports take precedence over the star exports
+			if !f.exportNames.Has(name) {
+				lines = exportAlias(lines, name, b.memberExpr(ref, name))
+			}
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	if m := regexpDtsExportFrom.FindStringSubmatch(body); m != nil {
+		ref := b.resolve(f, m[3])
+		if ref.file == nil && !inNS {
+			return body
+		}
+		var lines []string
+		for _, s := range parseDtsSpecifiers(m[2]) {
+			if s[1] == "default" {
+				lines = exportDefault(lines, b.memberExpr(ref, s[0]))
+			} else {
+				lines = exportAlias(lines, s[1], b.memberExpr(ref, s[0]))
+			}
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	if !inNS {
+		return b.rewriteImportCalls(f, body)
+	}
+
+	// the statements in the namespace
+	if m := regexpDtsExportLocal.FindStringSubmatch(body); m != nil {
+		var lines []string
+		for _, s := range parseDtsSpecifiers(m[2]) {
+			if s[1] == "default" {
+				lines = exportDefault(lines, s[0])
+			} else if s[0] != s[1] {
+				lines = exportAlias(lines, s[1], s[0])
+			}
+		}
+		return strings.Join(lines, "\n")
+	}
+	if m := regexpDtsExportDefaultDecl.FindStringSubmatch(body); m != nil {
+		if m[2] == "" {
+			f.defaultDone = true
+			return b.rewriteImportCalls(f, "export "+m[1]+" $default"+body[len(m[0]):])
+		}
+		lines := []string{exportPrefix(m[2]) + b.rewriteImportCalls(f, regexpDtsExportDefaultPrefix.ReplaceAllString(body, ""))}
+		return strings.Join(exportDefault(lines, m[2]), "\n")
+	}
+	if m := regexpDtsExportDefault.FindStringSubmatch(body); m != nil {
+		return strings.Join(exportDefault(nil, m[1]), "\n")
+	}
+	if m := regexpDtsExportEquals.FindStringSubmatch(body); m != nil {
+		return strings.Join(exportDefault(nil, m[1]), "\n")
+	}
+	if regexpDtsExportAsNamespace.MatchString(body) {
+		return ""
+	}
+	if m := regexpDtsDeclareGlobal.FindStringSubmatch(body); m != nil {
+		if m[1] == "global" {
+			// `declare global` is not allowed in namespaces, hoist it to the top level of the bundle
+			// with the local names qualified, e.g. `Client` -> `__dts3$.Client`
+			names := b.getLocalNames(f)
+			code := replaceDtsIdents(b.rewriteImportCalls(f, body), func(name string) string {
+				if expr, ok := names[name]; ok {
+					return expr()
+				}
+				return name
+			})
+			b.hoisted = append(b.hoisted, fmt.Sprintf("// %s\n%s", b.rebase(f.key), code))
+			return ""
+		}
+		// module augmentations are not allowed in namespaces,
+		// import the original file instead to keep the scope of the augmentations
+		b.addImport(fmt.Sprintf(`import "%s";`, b.rebase(f.key)))
+		return ""
+	}
+	if m := regexpDtsDecl.FindStringSubmatch(body); m != nil {
+		// the `declare` modifier is not allowed in an ambient context
+		prefix := m[1]
+		if prefix == "" {
+			prefix = exportPrefix(m[4])
+		}
+		return b.rewriteImportCalls(f, prefix+body[len(m[1])+len(m[2]):])
+	}
+	return b.rewriteImportCalls(f, body)
+}
+
+// getLocalNames returns the expressions of the names that are imported or declared in the file, the
+// expressions are resolved lazily since the external modules are imported by the bundle when they are used.
+func (b *dtsBundler) getLocalNames(f *dtsBundleFile) map[string]func() string {
+	names := map[string]func() string{}
+	for _, stmt := range f.stmts {
+		body := stmt.body
+		if m := regexpDtsImportFrom.FindStringSubmatch(body); m != nil {
+			ref := b.resolve(f, m[3])
+			defaultName, nsName, named := parseDtsImportClause(m[2])
+			if defaultName != "" {
+				names[defaultName] = func() string { return b.memberExpr(ref, "default") }
+			}
+			if nsName != "" {
+				names[nsName] = func() string { return b.nsExpr(ref) }
+			}
+			for _, s := range named {
+				names[s[1]] = func() string { return b.memberExpr(ref, s[0]) }
+			}
+		} else if m := regexpDtsImportRequire.FindStringSubmatch(body); m != nil {
+			ref := b.resolve(f, m[3])
+			names[m[2]] = func() string { return b.nsExpr(ref) }
+		} else if m := regexpDtsDecl.FindStringSubmatch(body); m != nil {
+			names[m[4]] = func() string { return f.ident + "." + m[4] }
+		}
+	}
+	return names
+}
+
+// rewriteImportCalls rewrites the `import("...")` types to the namespaces.
+func (b *dtsBundler) rewriteImportCalls(f *dtsBundleFile, body string) string {
+	if !strings.Contains(body, "import") {
+		return body
+	}
+	return regexpDtsImportCall.ReplaceAllStringFunc(body, func(s string) string {
+		m := regexpDtsImportCall.FindStringSubmatch(s)
+		ref := b.resolve(f, m[1])
+		if ref.file == nil {
+			if ref.url == m[1] {
+				return s
+			}
+			return fmt.Sprintf(`import("%s")%s`, ref.url, m[2])
+		}
+		if m[2] != "" {
+			return b.memberExpr(ref, "default")
+		}
+		return b.nsExpr(ref)
+	})
+}
+
+// parseDtsImportClause parses the import clause, e.g. `React, { useState as useS }`.
+func parseDtsImportClause(clause string) (defaultName string, nsName string, named [][2]string) {
+	clause = regexpDtsComment.ReplaceAllString(clause, "")
+	if i := strings.IndexByte(clause, '{'); i >= 0 {
+		if j := strings.LastIndexByte(clause, '}'); j > i {
+			named = parseDtsSpecifiers(clause[i+1 : j])
+		}
+		clause = clause[:i]
+	}
+	for _, p := range strings.Split(clause, ",") {
+		p = strings.TrimSpace(p)
+		if strings.HasPrefix(p, "*") {
+			a := regexpDtsAs.Split(p, 2)
+			if len(a) == 2 {
+				nsName = strings.TrimSpace(a[1])
+			}
+		} else if p != "" {
+			defaultName = p
+		}
+	}
+	return
+}
+
+// parseDtsSpecifiers parses the import/export specifiers, e.g. `a, type b, c as d`.
+func parseDtsSpecifiers(s string) (specifiers [][2]string) {
+	s = regexpDtsComment.ReplaceAllString(s, "")
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if strings.HasPrefix(p, "type ") {
+			p = strings.TrimSpace(p[5:])
+		}
+		if p == "" {
+			continue
+		}
+		a := regexpDtsAs.Split(p, 2)
+		if len(a) == 2 {
+			specifiers = append(specifiers, [2]string{strings.TrimSpace(a[0]), strings.TrimSpace(a[1])})
+		} else {
+			specifiers = append(specifiers, [2]string{p, p})
+		}
+	}
+	return
+}
+
+// splitDtsStmts splits the `.d.ts` code into top-level statements.
+func splitDtsStmts(code string) (stmts []dtsStmt) {
+	n := len(code)
+	start := 0
+	bodyStart := -1
+	depth := 0
+	flush := func(end int) {
+		if bodyStart >= 0 {
+			stmts = append(stmts, dtsStmt{trivia: code[start:bodyStart], body: code[bodyStart:end]})
+			start = end
+			bodyStart = -1
+		}
+	}
+	mark := func(i int) {
+		if bodyStart < 0 {
+			bodyStart = i
+		}
+	}
+	for i := 0; i < n; i++ {
+		c := code[i]
+		switch c {
+		case '/':
+			if i+1 < n && code[i+1] == '/' {
+				j := strings.IndexByte(code[i:], '\n')
+				if j < 0 {
+					i = n
+				} else {
+					i += j - 1
+				}
+				continue
+			}
+			if i+1 < n && code[i+1] == '*' {
+				j := strings.Index(code[i+2:], "*/")
+				if j < 0 {
+					i = n
+				} else {
+					i += j + 3
+				}
+				continue
+			}
+			mark(i)
+		case '\'', '"':
+			mark(i)
+			i = skipDtsString(code, i)
+		case '`':
+			mark(i)
+			i = skipDtsTemplate(code, i)
+		case '{', '(', '[':
+			mark(i)
+			depth++
+		case '}', ')', ']':
+			mark(i)
+			if depth > 0 {
+				depth--
+			}
+		case ';':
+			mark(i)
+			if depth == 0 {
+				flush(i + 1)
+			}
+		case '\n':
+			if depth == 0 && bodyStart >= 0 && isDtsStmtStart(code, i+1) {
+				flush(i)
+			}
+		case ' ', '\t', '\r':
+		default:
+			mark(i)
+		}
+	}
+	if bodyStart >= 0 {
+		flush(n)
+	} else if start < n {
+		stmts = append(stmts, dtsStmt{trivia: code[start:]})
+	}
+	return
+}
+
+// isDtsStmtStart checks if a new statement starts at the given position, the comments are skipped.
+func isDtsStmtStart(code string, i int) bool {
+	n := len(code)
+	for i < n {
+		c := code[i]
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+			i++
+		} else if strings.HasPrefix(code[i:], "//") {
+			j := strings.IndexByte(code[i:], '\n')
+			if j < 0 {
+				return true
+			}
+			i += j
+		} else if strings.HasPrefix(code[i:], "/*") {
+			j := strings.Index(code[i+2:], "*/")
+			if j < 0 {
+				return true
+			}
+			i += j + 4
+		} else {
+			break
+		}
+	}
+	if i >= n {
+		return true
+	}
+	for _, kw := range dtsStmtKeywords {
+		if strings.HasPrefix(code[i:], kw) {
+			j := i + len(kw)
+			if j < n && (isJsIdentChar(code[j]) || (kw == "import" && (code[j] == '(' || code[j] == '.'))) {
+				continue
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// replaceDtsIdents replaces the identifiers in the code that refer to the types or values, the strings, comments,
+// member names (e.g. `a` of `{ a: string }` and `x.a`) and the declared names are kept.
+func replaceDtsIdents(code string, replace func(name string) string) string {
+	buf, recycle := NewBuffer()
+	defer recycle()
+	n := len(code)
+	for i := 0; i < n; {
+		c := code[i]
+		j := i + 1
+		switch {
+		case c == '\'' || c == '"':
+			j = min(skipDtsString(code, i)+1, n)
+		case c == '`':
+			j = min(skipDtsTemplate(code, i)+1, n)
+		case c == '/' && i+1 < n && code[i+1] == '/':
+			if k := strings.IndexByte(code[i:], '\n'); k >= 0 {
+				j = i + k
+			} else {
+				j = n
+			}
+		case c == '/' && i+1 < n && code[i+1] == '*':
+			if k := strings.Index(code[i+2:], "*/"); k >= 0 {
+				j = i + k + 4
+			} else {
+				j = n
+			}
+		case isJsIdentChar(c):
+			for j < n && isJsIdentChar(code[j]) {
+				j++
+			}
+			if (c < '0' || c > '9') && !isDtsMemberOrDeclName(code, i, j) {
+				buf.WriteString(replace(code[i:j]))
+				i = j
+				continue
+			}
+		}
+		buf.WriteString(code[i:j])
+		i = j
+	}
+	return buf.String()
+}
+
+// isDtsMemberOrDeclName checks if the identifier at code[start:end] is a member name or a declared name.
+func isDtsMemberOrDeclName(code string, start int, end int) bool {
+	i := start - 1
+	for i >= 0 && (code[i] == ' ' || code[i] == '\t') {
+		i--
+	}
+	// the declared names, e.g. `interface Window`
+	word := i + 1
+	for word > 0 && isJsIdentChar(code[word-1]) {
+		word--
+	}
+	switch code[word : i+1] {
+	case "interface", "class", "type", "var", "let", "const", "function", "namespace", "module", "enum":
+		return true
+	}
+	if i >= 0 && code[i] == '.' {
+		return true
+	}
+	// the member names, e.g. `client: Client`, `client?: Client` and `connect(): void`
+	if i < 0 || code[i] == '\n' || code[i] == '\r' || strings.IndexByte("{;,(", code[i]) >= 0 || code[word:i+1] == "readonly" {
+		j := end
+		for j < len(code) && (code[j] == ' ' || code[j] == '\t') {
+			j++
+		}
+		if j < len(code) && code[j] == '?' {
+			j++
+		}
+		return j < len(code) && (code[j] == ':' || code[j] == '(')
+	}
+	return false
+}
+
+func isJsIdentChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' || c == '$'
+}
+
+// skipDtsString returns the index of the closing quote of the string literal.
+func skipDtsString(code string, i int) int {
+	q := code[i]
+	for i++; i < len(code); i++ {
+		switch code[i] {
+		case '\\':
+			i++
+		case q, '\n':
+			return i
+		}
+	}
+	return len(code)
+}
+
+// skipDtsTemplate returns the index of the closing backtick of the template literal.
+func skipDtsTemplate(code string, i int) int {
+	for i++; i < len(code); i++ {
+		switch code[i] {
+		case '\\':
+			i++
+		case '`':
+			return i
+		case '$':
+			if i+1 < len(code) && code[i+1] == '{' {
+				depth := 0
+			Expr:
+				for i++; i < len(code); i++ {
+					switch code[i] {
+					case '{':
+						depth++
+					case '}':
+						depth--
+						if depth == 0 {
+							break Expr
+						}
+					case '\'', '"':
+						i = skipDtsString(code, i)
+					case '`':
+						i = skipDtsTemplate(code, i)
+					}
+				}
+			}
+		}
+	}
+	return len(code)
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestBundleDTS(t *testing.T) {
+	files := map[string]string{
+		"types/pkg@1.0.0/index.d.ts": `/// <reference types="{ESM_CDN_ORIGIN}/@types/node@20.0.0/index.d.ts" />
+/// <reference path="./globals.d.ts" />
+import type { Options } from "./lib/options.d.ts";
+import Client from "./lib/client.d.ts";
+/**
+ * Creates a client.
+ */
+export declare function createClient(options: Options): Client;
+export * from "./lib/options.d.ts";
+export { default as Client } from "./lib/client.d.ts";
+export * as utils from "./lib/utils.d.ts";
+export type Lazy = typeof import("./lib/utils.d.ts");
+export default createClient;
+`,
+		"types/pkg@1.0.0/globals.d.ts": "declare var __PKG_VERSION__: string;\n",
+		"types/pkg@1.0.0/lib/options.d.ts": `import { EventEmitter } from "{ESM_CDN_ORIGIN}/@types/node@20.0.0/events.d.ts";
+export interface Options {
+  emitter?: EventEmitter
+  format: ` + "`${string}-${number}`" + `
+}
+type Mode = "a" | "b"
+export { Mode }
+export * from "{ESM_CDN_ORIGIN}/zod@3.0.0/index.d.ts";
+`,
+		"types/pkg@1.0.0/lib/client.d.ts": `import type { Options } from "./options.d.ts";
+declare class Client {
+  constructor(options: Options);
+}
+declare global {
+  interface Window { client: Client; Client?: typeof Client; options(): Options }
+}
+export default Client;
+`,
+		"types/pkg@1.0.0/lib/utils.d.ts": `export declare function noop(): void;
+export { noop as nop };
+`,
+	}
+	dts, err := bundleDTS("types/pkg@1.0.0/index.d.ts", func(key string) ([]byte, error) {
+		if s, ok := files[key]; ok {
+			return []byte(s), nil
+		}
+		return nil, errors.New("not found")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := string(dts)
+	for _, s := range []string{
+		`/// <reference types="{ESM_CDN_ORIGIN}/@types/node@20.0.0/index.d.ts" />`,
+		`export * from "{ESM_CDN_ORIGIN}/zod@3.0.0/index.d.ts";`,
+		`import * as __dts_ext0$ from "{ESM_CDN_ORIGIN}/@types/node@20.0.0/events.d.ts";`,
+		"// ./lib/client.d.ts\ndeclare global {\n  interface Window { client: __dts3$.Client; Client?: typeof __dts3$.Client; options(): __dts2$.Options }\n}",
+		`export import Options = __dts2$.Options;`,
+		`export import Client = __dts3$.$default;`,
+		`export import Mode = __dts2$.Mode;`,
+		`export import utils = __dts4$;`,
+		`export type Lazy = typeof __dts4$;`,
+		"declare global {\nvar __PKG_VERSION__: string;\n}",
+		"declare namespace __dts2$ {\nimport EventEmitter = __dts_ext0$.EventEmitter;",
+		`export type Mode = "a" | "b"`,
+		"export class Client {",
+		"export import $default = Client;",
+		"export function noop(): void;\nexport import nop = noop;",
+	} {
+		if !strings.Contains(code, s) {
+			t.Fatalf("missing %q in the bundled dts:\n%s", s, code)
+		}
+	}
+	if strings.Contains(code, `"./lib/options.d.ts"`) || strings.Contains(code, `"./lib/client.d.ts"`) || strings.Contains(code, "declare function noop") {
+		t.Fatalf("unexpected bundled dts:\n%s", code)
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+
+	"github.com/esm-dev/esm.sh/server/common"
+	"github.com/ije/gox/utils"
+)
+
+// emitDTS emits the declarations(.d.ts) of the typescript module, the import specifiers of the
+// declarations are rewritten by the `resolve` function.
+func emitDTS(npmrc *NpmRC, filename string, code string, resolve func(specifier string) string) ([]byte, error) {
+	output, err := transpileDeclaration(npmrc, filename, code)
+	if err != nil {
+		return nil, err
+	}
+	return rewriteDtsImports(output.Code, resolve)
+}
+
+// rewriteDtsImports rewrites the import specifiers of the declarations, the `/// <reference>`
+// directives are kept as they are.
+func rewriteDtsImports(dts string, resolve func(specifier string) string) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	err := parseDts(strings.NewReader(dts), buf, func(specifier string, kind TsImportKind, position int) (string, error) {
+		if kind == TsReferenceTypes || kind == TsReferencePath {
+			return specifier, nil
+		}
+		return resolve(specifier), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// resolveHttpDtsImport resolves the import specifier of the declarations of a http module with the
+// import map, the typescript modules on the same host point to their declarations served by esm.sh.
+func resolveHttpDtsImport(specifier string, modUrl *url.URL, importMap common.ImportMap, cdnOrigin string, dtsQuery string) string {
+	path, _ := importMap.Resolve(specifier)
+	if isRelPathSpecifier(path) || isAbsPathSpecifier(path) {
+		var query string
+		path, query = utils.SplitByFirstByte(path, '?')
+		if query != "" {
+			query = "?" + query
+		}
+		u := modUrl.ResolveReference(&url.URL{Path: path})
+		path = u.Scheme + "://" + u.Host + u.Path + query
+	}
+	if isHttpSepcifier(path) {
+		u, err := url.Parse(path)
+		if err == nil && u.Scheme == modUrl.Scheme && u.Host == modUrl.Host && u.RawQuery == "" && endsWith(u.Path, ".ts", ".mts", ".tsx") {
+			return cdnOrigin + "/" + path + "?" + dtsQuery
+		}
+	}
+	return path
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/esm-dev/esm.sh/server/common"
+)
+
+func TestRewriteHttpDtsImports(t *testing.T) {
+	modUrl, _ := url.Parse("https://example.com/lib/mod.ts")
+	importMap := common.ImportMap{Src: "/index.html", Imports: map[string]string{
+		"react": "https://esm.sh/react@19.0.0",
+		"~/":    "./utils/",
+	}}
+	dts := "import type { FC } from \"react\";\n" +
+		"import { Foo } from \"./foo.ts\";\n" +
+		"export * from \"~/bar.tsx\";\n" +
+		"export type { Baz } from \"https://cdn.example.org/baz.ts\";\n" +
+		"export declare const x: import(\"../shared.mts\").X;\n" +
+		"export declare function f(foo: Foo): FC;\n"
+	expected := "import type { FC } from \"https://esm.sh/react@19.0.0\";\n" +
+		"import { Foo } from \"https://esm.sh/https://example.com/lib/foo.ts?dts&v=1\";\n" +
+		"export * from \"https://esm.sh/https://example.com/utils/bar.tsx?dts&v=1\";\n" +
+		"export type { Baz } from \"https://cdn.example.org/baz.ts\";\n" +
+		"export declare const x: import(\"https://esm.sh/https://example.com/shared.mts?dts&v=1\").X;\n" +
+		"export declare function f(foo: Foo): FC;\n"
+	ret, err := rewriteDtsImports(dts, func(specifier string) string {
+		return resolveHttpDtsImport(specifier, modUrl, importMap, "https://esm.sh", "dts&v=1")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(ret) != expected {
+		t.Fatalf("unexpected output:\n%s", ret)
+	}
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// resolveEntryTypes resolves the types of the module with the `exports` and `typesVersions` fields of the
+// package.json as TypeScript does.
+func (ctx *BuildContext) resolveEntryTypes(subModuleName string, types string) string {
+	return resolvePackageTypes(ctx.pkgJson, subModuleName, types, config.TypeScriptVersion, ctx.args.conditions, func(filename string) bool {
+		return ctx.existsPkgFile(filename)
+	})
+}
+
+// resolvePackageTypes resolves the types of the sub-module, the rules match TypeScript's `bundler`/`node16`
+// module resolution. It returns the given `types` if nothing is resolved.
+// see https://www.typescriptlang.org/docs/handbook/modules/reference.html#packagejson-exports
+func resolvePackageTypes(pkgJson *PackageJSON, subModuleName string, types string, tsVersion string, conditions []string, exists func(string) bool) string {
+	if pkgJson.Exports.Len() > 0 {
+		// `typesVersions` is ignored if the types are resolved from `exports`
+		if ret := resolveExportsTypes(pkgJson.Exports, subModuleName, tsVersion, conditions, exists); ret != "" {
+			return ret
+		}
+	}
+	if pkgJson.TypesVersions.Len() > 0 {
+		subPath := subModuleName
+		if subPath == "" {
+			subPath = strings.TrimPrefix(types, "./")
+			if subPath == "" {
+				subPath = "index.d.ts"
+			}
+		}
+		if ret := resolveTypesVersions(pkgJson.TypesVersions, subPath, tsVersion, exists); ret != "" {
+			return ret
+		}
+	}
+	return types
+}
+
+// resolveExportsTypes resolves the types of the sub-module with the `exports` field, the `import` conditions
+// are preferred, and the `require` conditions are used as fallback for commonjs packages.
+func resolveExportsTypes(exports JSONObject, subModuleName string, tsVersion string, conditions []string, exists func(string) bool) string {
+	target, star, ok := matchExportsSubPath(exports, subModuleName)
+	if !ok {
+		return ""
+	}
+	for _, mode := range []string{"import", "require"} {
+		activeConditions := make(map[string]bool, len(conditions)+3)
+		activeConditions["types"] = true
+		activeConditions[mode] = true
+		activeConditions["default"] = true
+		for _, name := range conditions {
+			activeConditions[name] = true
+		}
+		if ret := resolveExportsTargetTypes(target, star, activeConditions, tsVersion, exists); ret != "" {
+			return ret
+		}
+	}
+	return ""
+}
+
+// matchExportsSubPath returns the export target of the sub-module, and the string that matches the `*`
+// if the export key is a pattern.
+func matchExportsSubPath(exports JSONObject, subModuleName string) (target any, star string, ok bool) {
+	isConditions := true
+	for _, key := range exports.keys {
+		if strings.HasPrefix(key, ".") {
+			isConditions = false
+			break
+		}
+	}
+	if isConditions {
+		if subModuleName == "" {
+			return exports, "", true
+		}
+		return nil, "", false
+	}
+
+	subPath := "."
+	if subModuleName != "" {
+		subPath = "./" + subModuleName
+	}
+	if target, ok = exports.Get(subPath); ok {
+		return
+	}
+	// the export key may have the module extension, e.g. "./foo.js"
+	for _, key := range exports.keys {
+		if !strings.ContainsRune(key, '*') && stripEntryModuleExt(key) == subPath {
+			return exports.values[key], "", true
+		}
+	}
+	// the pattern with the longest prefix wins
+	// see https://nodejs.org/api/packages.html#subpath-patterns
+	var matchedKey string
+	for _, key := range exports.keys {
+		prefix, suffix, isPattern := strings.Cut(key, "*")
+		if !isPattern || strings.ContainsRune(suffix, '*') || len(prefix) <= len(matchedKey) {
+			continue
+		}
+		if strings.HasPrefix(subPath, prefix) && len(subPath) >= len(prefix)+len(suffix) {
+			rest := subPath[len(prefix):]
+			if strings.HasSuffix(rest, suffix) {
+				matchedKey = prefix
+				target, star, ok = exports.values[key], rest[:len(rest)-len(suffix)], true
+			} else if suffix != "" && strings.HasSuffix(stripEntryModuleExt(rest), stripEntryModuleExt(suffix)) {
+				// the extension of the sub-module is omitted, e.g. "./foo" matches "./*.js"
+				matchedKey = prefix
+				rest = stripEntryModuleExt(rest)
+				target, star, ok = exports.values[key], rest[:len(rest)-len(stripEntryModuleExt(suffix))], true
+			}
+		}
+	}
+	return
+}
+
+func resolveExportsTargetTypes(target any, star string, conditions map[string]bool, tsVersion string, exists func(string) bool) string {
+	switch v := target.(type) {
+	case string:
+		if star != "" || strings.ContainsRune(v, '*') {
+			v = strings.ReplaceAll(v, "*", star)
+		}
+		return toTypesFile(v, exists)
+	case []any:
+		for _, item := range v {
+			if ret := resolveExportsTargetTypes(item, star, conditions, tsVersion, exists); ret != "" {
+				return ret
+			}
+		}
+	case JSONObject:
+		for _, key := range v.keys {
+			if matchTypesCondition(key, conditions, tsVersion) {
+				value := v.values[key]
+				if value == nil {
+					// `null` excludes the path
+					return ""
+				}
+				if ret := resolveExportsTargetTypes(value, star, conditions, tsVersion, exists); ret != "" {
+					return ret
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// matchTypesCondition checks if the condition is active, the versioned `types@{range}` condition
+// is matched with the TypeScript version.
+func matchTypesCondition(key string, conditions map[string]bool, tsVersion string) bool {
+	if conditions[key] {
+		return true
+	}
+	if versionRange, ok := strings.CutPrefix(key, "types@"); ok {
+		return matchTypeScriptVersion(versionRange, tsVersion)
+	}
+	return false
+}
+
+// resolveTypesVersions resolves the types of the sub-path with the `typesVersions` field, the first
+// version range that matches the TypeScript version is used.
+// see https://www.typescriptlang.org/docs/handbook/declaration-files/publishing.html#version-selection-with-typesversions
+func resolveTypesVersions(typesVersions JSONObject, subPath string, tsVersion string, exists func(string) bool) string {
+	var mapping JSONObject
+	for _, versionRange := range typesVersions.keys {
+		if matchTypeScriptVersion(versionRange, tsVersion) {
+			mapping, _ = typesVersions.values[versionRange].(JSONObject)
+			break
+		}
+	}
+	if mapping.Len() == 0 {
+		return ""
+	}
+
+	subPath = strings.TrimPrefix(subPath, "./")
+	paths, ok := mapping.Get(subPath)
+	star := ""
+	if !ok {
+		// the pattern with the longest prefix wins
+		var matchedPrefix string
+		for _, key := range mapping.keys {
+			prefix, suffix, isPattern := strings.Cut(strings.TrimPrefix(key, "./"), "*")
+			if !isPattern || (ok && len(prefix) <= len(matchedPrefix)) {
+				continue
+			}
+			if strings.HasPrefix(subPath, prefix) && strings.HasSuffix(subPath, suffix) && len(subPath) >= len(prefix)+len(suffix) {
+				matchedPrefix = prefix
+				paths, star, ok = mapping.values[key], subPath[len(prefix):len(subPath)-len(suffix)], true
+			}
+		}
+	}
+	if !ok {
+		return ""
+	}
+
+	a, _ := paths.([]any)
+	for _, v := range a {
+		if s, isStr := v.(string); isStr {
+			if ret := toTypesFile(strings.ReplaceAll(s, "*", star), exists); ret != "" {
+				return ret
+			}
+		}
+	}
+	return ""
+}
+
+// matchTypeScriptVersion checks if the TypeScript version satisfies the version range,
+// e.g. ">=4.2", "<5.0", ">=3.1 <4", "*".
+func matchTypeScriptVersion(versionRange string, tsVersion string) bool {
+	if versionRange == "*" {
+		return true
+	}
+	c, err := semver.NewConstraint(versionRange)
+	if err != nil {
+		return false
+	}
+	v, err := semver.NewVersion(tsVersion)
+	if err != nil {
+		return false
+	}
+	return c.Check(v)
+}
+
+// toTypesFile returns the declaration file of the given path, the extensions are substituted as
+// TypeScript does, e.g. "./index.js" -> "./index.d.ts", "./index.mjs" -> "./index.d.mts".
+func toTypesFile(filename string, exists func(string) bool) string {
+	filename = normalizeEntryPath(filename)
+	if endsWith(filename, ".d.ts", ".d.mts", ".d.cts") {
+		if exists(filename) {
+			return filename
+		}
+		return ""
+	}
+	var candidates []string
+	switch {
+	case endsWith(filename, ".js", ".jsx", ".ts", ".tsx"):
+		candidates = []string{stripModuleExt(filename) + ".d.ts"}
+	case endsWith(filename, ".mjs", ".mts"):
+		candidates = []string{stripModuleExt(filename) + ".d.mts"}
+	case endsWith(filename, ".cjs", ".cts"):
+		candidates = []string{stripModuleExt(filename) + ".d.cts"}
+	case strings.HasSuffix(filename, ".d"):
+		candidates = []string{filename + ".mts", filename + ".ts", filename + ".cts"}
+	default:
+		candidates = []string{
+			filename + ".d.mts",
+			filename + ".d.ts",
+			filename + ".d.cts",
+			filename + "/index.d.mts",
+			filename + "/index.d.ts",
+			filename + "/index.d.cts",
+		}
+	}
+	for _, candidate := range candidates {
+		if exists(candidate) {
+			return candidate
+		}
+	}
+	return ""
+}
@@ -0,0 +1,253 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestResolveEntryTypes(t *testing.T) {
+	tests := []struct {
+		name          string
+		pkgJson       string
+		files         []string
+		subModuleName string
+		tsVersion     string
+		conditions    []string
+		expected      string
+	}{
+		{
+			name: "exports with import/require types",
+			pkgJson: `{
+				"exports": {
+					".": {
+						"import": { "types": "./build/modern/index.d.ts", "default": "./build/modern/index.js" },
+						"require": { "types": "./build/modern/index.d.cts", "default": "./build/modern/index.cjs" }
+					},
+					"./package.json": "./package.json"
+				}
+			}`,
+			files:    []string{"./build/modern/index.d.ts", "./build/modern/index.d.cts"},
+			expected: "./build/modern/index.d.ts",
+		},
+		{
+			name: "exports with require types only",
+			pkgJson: `{
+				"exports": {
+					".": {
+						"require": { "types": "./dist/index.d.cts", "default": "./dist/index.cjs" }
+					}
+				}
+			}`,
+			files:    []string{"./dist/index.d.cts"},
+			expected: "./dist/index.d.cts",
+		},
+		{
+			name: "exports with .d.mts types",
+			pkgJson: `{
+				"exports": {
+					".": {
+						"import": { "types": "./dist/index.d.mts", "default": "./dist/index.mjs" },
+						"require": { "types": "./dist/index.d.ts", "default": "./dist/index.js" }
+					}
+				}
+			}`,
+			files:    []string{"./dist/index.d.mts", "./dist/index.d.ts"},
+			expected: "./dist/index.d.mts",
+		},
+		{
+			name: "exports conditions without the `.` key",
+			pkgJson: `{
+				"exports": {
+					"types": "./index.d.ts",
+					"default": "./index.js"
+				}
+			}`,
+			files:    []string{"./index.d.ts"},
+			expected: "./index.d.ts",
+		},
+		{
+			name: "exports target without types",
+			pkgJson: `{
+				"exports": {
+					".": { "import": "./esm/index.mjs", "require": "./cjs/index.cjs" }
+				}
+			}`,
+			files:    []string{"./esm/index.d.mts", "./cjs/index.d.cts"},
+			expected: "./esm/index.d.mts",
+		},
+		{
+			name: "exports sub-path pattern",
+			pkgJson: `{
+				"exports": {
+					".": "./index.js",
+					"./*": { "types": "./dist/types/*.d.ts", "default": "./dist/*.js" },
+					"./icons/*": { "types": "./dist/icons/*.d.ts", "default": "./dist/icons/*.js" }
+				}
+			}`,
+			files:         []string{"./dist/types/utils/date.d.ts", "./dist/icons/arrow.d.ts"},
+			subModuleName: "icons/arrow",
+			expected:      "./dist/icons/arrow.d.ts",
+		},
+		{
+			name: "exports sub-path pattern with extension",
+			pkgJson: `{
+				"exports": {
+					"./*.js": { "types": "./types/*.d.ts", "default": "./lib/*.js" }
+				}
+			}`,
+			files:         []string{"./types/utils.d.ts"},
+			subModuleName: "utils",
+			expected:      "./types/utils.d.ts",
+		},
+		{
+			name: "versioned types conditions",
+			pkgJson: `{
+				"exports": {
+					".": {
+						"types@<4.8": "./ts4.7/index.d.ts",
+						"types": "./index.d.ts",
+						"default": "./index.js"
+					}
+				}
+			}`,
+			files:     []string{"./ts4.7/index.d.ts", "./index.d.ts"},
+			tsVersion: "4.7",
+			expected:  "./ts4.7/index.d.ts",
+		},
+		{
+			name: "versioned types conditions not matched",
+			pkgJson: `{
+				"exports": {
+					".": {
+						"types@<4.8": "./ts4.7/index.d.ts",
+						"types": "./index.d.ts",
+						"default": "./index.js"
+					}
+				}
+			}`,
+			files:    []string{"./ts4.7/index.d.ts", "./index.d.ts"},
+			expected: "./index.d.ts",
+		},
+		{
+			name: "custom conditions",
+			pkgJson: `{
+				"exports": {
+					".": {
+						"worker": { "types": "./worker.d.ts", "default": "./worker.js" },
+						"types": "./index.d.ts",
+						"default": "./index.js"
+					}
+				}
+			}`,
+			files:      []string{"./worker.d.ts", "./index.d.ts"},
+			conditions: []string{"worker"},
+			expected:   "./worker.d.ts",
+		},
+		{
+			name: "excluded sub-path",
+			pkgJson: `{
+				"types": "./index.d.ts",
+				"exports": {
+					"./internal/*": null,
+					"./*": { "types": "./*.d.ts" }
+				}
+			}`,
+			files:         []string{"./internal/foo.d.ts"},
+			subModuleName: "internal/foo",
+			expected:      "",
+		},
+		{
+			name: "typesVersions for old typescript",
+			pkgJson: `{
+				"types": "./index.d.ts",
+				"typesVersions": { "<4.0": { "*": ["ts3.4/*"] } }
+			}`,
+			files:     []string{"./index.d.ts", "./ts3.4/index.d.ts"},
+			tsVersion: "3.9",
+			expected:  "./ts3.4/index.d.ts",
+		},
+		{
+			name: "typesVersions not matched",
+			pkgJson: `{
+				"types": "./index.d.ts",
+				"typesVersions": { "<4.0": { "*": ["ts3.4/*"] } }
+			}`,
+			files:    []string{"./index.d.ts", "./ts3.4/index.d.ts"},
+			expected: "./index.d.ts",
+		},
+		{
+			name: "typesVersions first matched range wins",
+			pkgJson: `{
+				"types": "./index.d.ts",
+				"typesVersions": {
+					">=5.0": { "*": ["ts5.0/*"] },
+					">=4.2": { "*": ["ts4.2/*"] },
+					"*": { "*": ["ts3.4/*"] }
+				}
+			}`,
+			files:     []string{"./ts5.0/index.d.ts", "./ts4.2/index.d.ts", "./ts3.4/index.d.ts"},
+			tsVersion: "4.9",
+			expected:  "./ts4.2/index.d.ts",
+		},
+		{
+			name: "typesVersions maps sub-modules",
+			pkgJson: `{
+				"main": "./index.js",
+				"typesVersions": { "*": { "*": ["dist/types/*"] } }
+			}`,
+			files:         []string{"./dist/types/server.d.ts", "./dist/types/server/index.d.ts"},
+			subModuleName: "server",
+			expected:      "./dist/types/server.d.ts",
+		},
+		{
+			name: "typesVersions with exact and pattern paths",
+			pkgJson: `{
+				"typesVersions": {
+					"*": {
+						"react": ["./dist/react/index.d.ts"],
+						"*": ["./dist/*/index.d.ts", "./dist/*.d.ts"]
+					}
+				}
+			}`,
+			files:         []string{"./dist/react/index.d.ts", "./dist/vue.d.ts"},
+			subModuleName: "vue",
+			expected:      "./dist/vue.d.ts",
+		},
+		{
+			name: "typesVersions is ignored if types are resolved from exports",
+			pkgJson: `{
+				"exports": { ".": { "types": "./dist/index.d.ts", "default": "./dist/index.js" } },
+				"typesVersions": { "*": { "*": ["dist/legacy/*"] } }
+			}`,
+			files:    []string{"./dist/index.d.ts", "./dist/legacy/index.d.ts"},
+			expected: "./dist/index.d.ts",
+		},
+	}
+
+	for _, test := range tests {
+		var pkgJson PackageJSON
+		err := json.Unmarshal([]byte(test.pkgJson), &pkgJson)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		files := map[string]bool{}
+		for _, file := range test.files {
+			files[file] = true
+		}
+		exists := func(filename string) bool {
+			return files[filename]
+		}
+		tsVersion := test.tsVersion
+		if tsVersion == "" {
+			tsVersion = "5.7"
+		}
+		var types string
+		if pkgJson.Types != "" && test.subModuleName == "" {
+			types = normalizeEntryPath(pkgJson.Types)
+		}
+		ret := resolvePackageTypes(&pkgJson, test.subModuleName, types, tsVersion, test.conditions, exists)
+		if ret != test.expected {
+			t.Fatalf("%s: expected %q, got %q", test.name, test.expected, ret)
+		}
+	}
+}
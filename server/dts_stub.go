@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/ije/gox/set"
+)
+
+const stubDtsExt = ".stub.d.ts"
+
+// getStubDtsPath returns the path of the synthesized `.d.ts` file of the current module,
+// e.g. "/pkg@1.0.0/index.stub.d.ts"
+func (ctx *BuildContext) getStubDtsPath() string {
+	name := ctx.esm.SubModuleName
+	if name == "" {
+		name = "index"
+	}
+	return "/" + ctx.esm.Name() + "/" + ctx.getBuildArgsPrefix(true) + name + stubDtsExt
+}
+
+// synthesizeDTS generates a `.d.ts` file with `any` typed declarations for the module that
+// has neither bundled types nor a `@types/` package.
+func (ctx *BuildContext) synthesizeDTS(entry BuildEntry, meta *BuildMeta, cjsExports []string) (dts string, err error) {
+	if entry.main == "" || meta.TypesOnly {
+		return
+	}
+
+	var exports []string
+	if meta.CJS {
+		exports = cjsExports
+	} else if endsWith(entry.main, ".vue", ".svelte") {
+		// components only export the default
+	} else {
+		_, exports, err = validateModuleFile(path.Join(ctx.wd, "node_modules", ctx.esm.PkgName, entry.main))
+		if err != nil {
+			return
+		}
+	}
+
+	dts = ctx.getStubDtsPath()
+	savePath := normalizeSavePath(ctx.npmrc.zoneId, path.Join("types", dts))
+	err = ctx.storage.Put(savePath, bytes.NewReader(generateStubDTS(exports, meta.ExportDefault)))
+	if err != nil {
+		ctx.logger.Errorf("storage.put(%s): %v", savePath, err)
+		err = errors.New("storage: " + err.Error())
+		return "", err
+	}
+	return
+}
+
+// buildStubTypes rebuilds the synthesized `.d.ts` file if it's requested but not found in the storage.
+func (ctx *BuildContext) buildStubTypes() (ret *BuildMeta, err error) {
+	subModuleName := strings.TrimSuffix(ctx.esm.SubPath, stubDtsExt)
+	if subModuleName == "index" {
+		subModuleName = ""
+	}
+	ctx.esm.SubPath = subModuleName
+	ctx.esm.SubModuleName = subModuleName
+
+	entry := ctx.resolveEntry(ctx.esm)
+	if entry.main == "" {
+		err = errors.New("types not found")
+		return
+	}
+
+	ctx.status = "build"
+	meta, cjsExports, _, err := ctx.lexer(&entry)
+	if err != nil {
+		return
+	}
+	dts, err := ctx.synthesizeDTS(entry, meta, cjsExports)
+	if err != nil {
+		return
+	}
+	if dts == "" {
+		err = errors.New("types not found")
+		return
+	}
+
+	ret = &BuildMeta{Dts: dts, StubDts: true}
+	return
+}
+
+// generateStubDTS generates the declarations of the given export names, all typed as `any`.
+func generateStubDTS(exports []string, exportDefault bool) []byte {
+	names := set.New[string]()
+	for _, name := range exports {
+		if name != "default" && name != "__esModule" && isJsIdentifier(name) {
+			names.Add(name)
+		}
+	}
+	sorted := names.Values()
+	sort.Strings(sorted)
+
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString("// This file is generated by esm.sh since the package doesn't provide types.\n")
+	for _, name := range sorted {
+		if isJsReservedWord(name) {
+			continue
+		}
+		buf.WriteString("export declare const ")
+		buf.WriteString(name)
+		buf.WriteString(": any;\n")
+	}
+	// reserved words can only be exported with an alias
+	aliases := []string{}
+	for _, name := range sorted {
+		if isJsReservedWord(name) {
+			aliases = append(aliases, name)
+		}
+	}
+	if exportDefault {
+		aliases = append(aliases, "default")
+	}
+	if len(aliases) > 0 {
+		buf.WriteString("declare const ")
+		for i, name := range aliases {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString("__" + name + "$")
+			buf.WriteString(": any")
+		}
+		buf.WriteString(";\nexport { ")
+		for i, name := range aliases {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString("__" + name + "$ as " + name)
+		}
+		buf.WriteString(" };\n")
+	} else if len(sorted) == 0 {
+		buf.WriteString("export {};\n")
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,22 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestGenerateStubDTS(t *testing.T) {
+	dts := string(generateStubDTS([]string{"foo", "__esModule", "default", "delete", "bar", "foo", "a-b"}, true))
+	expected := "// This file is generated by esm.sh since the package doesn't provide types.\n" +
+		"export declare const bar: any;\n" +
+		"export declare const foo: any;\n" +
+		"declare const __delete$: any, __default$: any;\n" +
+		"export { __delete$ as delete, __default$ as default };\n"
+	if dts != expected {
+		t.Fatalf("unexpected dts:\n%s", dts)
+	}
+
+	dts = string(generateStubDTS(nil, false))
+	if dts != "// This file is generated by esm.sh since the package doesn't provide types.\nexport {};\n" {
+		t.Fatalf("unexpected dts:\n%s", dts)
+	}
+}
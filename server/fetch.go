@@ -8,6 +8,9 @@ import (
 	"time"
 )
 
+// errOffline is returned by the fetch client in offline mode
+var errOffline = errors.New("network access is disabled in offline mode")
+
 var fetchClientPool = sync.Pool{
 	New: func() any {
 		return &FetchClient{Client: &http.Client{}}
@@ -36,6 +39,9 @@ func NewFetchClient(timeout int, userAgent string, noRedirect bool) (client *Fet
 }
 
 func (c *FetchClient) Fetch(url *url.URL, header http.Header) (resp *http.Response, err error) {
+	if config.Offline {
+		return nil, errOffline
+	}
 	if c.userAgent != "" {
 		if header == nil {
 			header = make(http.Header)
@@ -3,13 +3,17 @@ package server
 import (
 	"bufio"
 	"bytes"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
 	"net/url"
+	"os"
 	"os/exec"
+	"strings"
 	"time"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/ije/gox/utils"
 )
 
@@ -20,23 +24,20 @@ type GitRef struct {
 
 // list repo refs using `git ls-remote repo`
 func listRepoRefs(repo string) (refs []GitRef, err error) {
+	return lsRemote(repo, nil)
+}
+
+func lsRemote(repo string, gitEnv []string) (refs []GitRef, err error) {
 	return withCache("git ls-remote "+repo, time.Duration(config.NpmQueryCacheTTL)*time.Second, func() ([]GitRef, string, error) {
-		stdout, recycle := NewBuffer()
-		defer recycle()
-		errout, recycle := NewBuffer()
-		defer recycle()
-		cmd := exec.Command("git", "ls-remote", repo)
-		cmd.Stdout = stdout
-		cmd.Stderr = errout
-		err = cmd.Run()
+		if config.Offline {
+			return nil, "", errOffline
+		}
+		output, err := gitWithEnv(gitEnv, "ls-remote", repo)
 		if err != nil {
-			if errout.Len() > 0 {
-				return nil, "", errors.New(errout.String())
-			}
 			return nil, "", err
 		}
 		refs = make([]GitRef, 0)
-		r := bufio.NewReader(stdout)
+		r := bufio.NewReader(bytes.NewReader(output))
 		for {
 			var line []byte
 			line, err = r.ReadBytes('\n')
@@ -81,3 +82,263 @@ func ghInstall(wd, name, tag string) (err error) {
 	err = extractPackageTarball(wd, name, io.LimitReader(res.Body, maxPackageTarballSize))
 	return
 }
+
+// GitHost is a git host that packages can be installed from, e.g. a self-hosted GitLab or Gitea server.
+// The packages are imported via the `/git/<host>/<repo>[/-/<subdir>]@<ref>` path, only the hosts in the
+// `gitHosts` config are allowed.
+type GitHost struct {
+	Url      string `json:"url"`
+	Token    string `json:"token"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+// Remote returns the remote url of the repository
+func (h *GitHost) Remote(repo string) string {
+	return h.Url + repo + ".git"
+}
+
+// ListRefs lists the refs of the repository
+func (h *GitHost) ListRefs(repo string) (refs []GitRef, err error) {
+	return lsRemote(h.Remote(repo), h.gitEnv())
+}
+
+// gitEnv returns the env vars to authenticate with the host, the credentials are passed via the
+// `GIT_CONFIG_*` env vars instead of the command line args which are visible to other processes.
+func (h *GitHost) gitEnv() []string {
+	password := h.Token
+	if password == "" {
+		password = h.Password
+	}
+	if password == "" {
+		return nil
+	}
+	user := h.User
+	if user == "" {
+		// GitLab and Gitea accept any user name with an access token
+		user = "oauth2"
+	}
+	return []string{
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=http.extraHeader",
+		"GIT_CONFIG_VALUE_0=Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password)),
+	}
+}
+
+// splitGitPackageName splits the git package name into host, repo and subdir.
+// e.g. "gitlab.example.com/team/ui/-/packages/button" -> ("gitlab.example.com", "team/ui", "packages/button")
+func splitGitPackageName(name string) (host string, repo string, subdir string) {
+	host, repo = utils.SplitByFirstByte(name, '/')
+	if i := strings.Index(repo, "/-/"); i >= 0 {
+		subdir = repo[i+3:]
+		repo = repo[:i]
+	}
+	return
+}
+
+// validateGitPackageName validates the git package name, returns the git host of the package.
+func validateGitPackageName(name string) (gitHost *GitHost, repo string, subdir string, err error) {
+	host, repo, subdir := splitGitPackageName(name)
+	h, ok := config.GitHosts[host]
+	if !ok {
+		err = fmt.Errorf("git: host '%s' is not allowed", host)
+		return
+	}
+	if repo == "" || strings.HasSuffix(name, "/-/") {
+		err = fmt.Errorf("invalid git package name '%s'", name)
+		return
+	}
+	segs := strings.Split(repo, "/")
+	if subdir != "" {
+		segs = append(segs, strings.Split(subdir, "/")...)
+	}
+	for _, seg := range segs {
+		if seg == "" || seg == "." || seg == ".." || seg == "-" || strings.HasPrefix(seg, "-") || strings.ContainsAny(seg, "@:\\ ") {
+			err = fmt.Errorf("invalid git package name '%s'", name)
+			return
+		}
+	}
+	return &h, repo, subdir, nil
+}
+
+// resolveGitVersion resolves the ref of a git repository, the ref can be a tag, a branch,
+// a commit sha, or a semver range with the `semver:` prefix.
+func resolveGitVersion(gitHost *GitHost, repo string, ref string) (version string, exactVersion bool, err error) {
+	if isExactVersion(strings.TrimPrefix(ref, "v")) {
+		return ref, true, nil
+	}
+	refs, err := gitHost.ListRefs(repo)
+	if err != nil {
+		return
+	}
+	if ref == "" || ref == "HEAD" {
+		for _, r := range refs {
+			if r.Ref == "HEAD" {
+				return r.Sha[:7], false, nil
+			}
+		}
+	}
+	// try to find the exact tag or branch
+	for _, r := range refs {
+		if r.Ref == "refs/tags/"+ref || r.Ref == "refs/heads/"+ref {
+			return r.Sha[:7], false, nil
+		}
+	}
+	// try to find the 'semver' tag
+	if c, e := semver.NewConstraint(strings.TrimPrefix(ref, "semver:")); e == nil {
+		var matched *semver.Version
+		for _, r := range refs {
+			if strings.HasPrefix(r.Ref, "refs/tags/") && !strings.HasSuffix(r.Ref, "^{}") {
+				v, e := semver.NewVersion(strings.TrimPrefix(r.Ref, "refs/tags/"))
+				if e == nil && c.Check(v) && (matched == nil || v.GreaterThan(matched)) {
+					matched = v
+				}
+			}
+		}
+		if matched != nil {
+			// keep the original tag name, e.g. "v1.0.0"
+			return matched.Original(), false, nil
+		}
+	}
+	if !isCommitish(ref) {
+		err = errors.New("git: tag or branch not found")
+		return
+	}
+	return ref, true, nil
+}
+
+// gitInstall installs a package from a git repository, the `version` is a tag, a branch or a commit sha.
+func gitInstall(wd string, name string, version string) (err error) {
+	gitHost, repo, subdir, err := validateGitPackageName(name)
+	if err != nil {
+		return
+	}
+	refs, err := gitHost.ListRefs(repo)
+	if err != nil {
+		return
+	}
+
+	// find the ref to fetch, fetching a ref with `--depth 1` is much faster than fetching the whole repository
+	var ref string
+	for _, r := range refs {
+		if r.Ref == "refs/tags/"+version || r.Ref == "refs/heads/"+version || (isCommitish(version) && strings.HasPrefix(r.Sha, version)) {
+			ref = strings.TrimSuffix(r.Ref, "^{}")
+			break
+		}
+	}
+
+	repoDir, err := os.MkdirTemp("", "esm-git-")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(repoDir)
+
+	_, err = git("init", "-q", "--bare", repoDir)
+	if err != nil {
+		return
+	}
+	args := []string{"-C", repoDir, "fetch", "-q"}
+	treeish := version
+	if ref != "" {
+		args = append(args, "--depth", "1", gitHost.Remote(repo), ref)
+		treeish = "FETCH_HEAD"
+	} else {
+		args = append(args, gitHost.Remote(repo), "+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*")
+	}
+	_, err = gitWithEnv(gitHost.gitEnv(), args...)
+	if err != nil {
+		return
+	}
+	if subdir != "" {
+		treeish += ":" + subdir
+	}
+
+	// stream the archive to the extractor, and stop at the size limit
+	errout, recycle := NewBuffer()
+	defer recycle()
+	cmd := gitCommand(nil, "-C", repoDir, "archive", "--format=tar.gz", "--prefix=package/", treeish)
+	cmd.Stderr = errout
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return
+	}
+	err = cmd.Start()
+	if err != nil {
+		return
+	}
+	tarball := &sizeLimitedReader{r: stdout, n: maxPackageTarballSize}
+	extractErr := extractPackageTarball(wd, name, tarball)
+	if extractErr == nil {
+		// drain the rest of the archive (e.g. the gzip trailer) to let git exit
+		_, extractErr = io.Copy(io.Discard, tarball)
+	}
+	if extractErr != nil {
+		cmd.Process.Kill()
+	}
+	waitErr := cmd.Wait()
+	if errors.Is(extractErr, errTooLarge) {
+		return errors.New("git: repository is too large")
+	}
+	if waitErr != nil && errout.Len() > 0 {
+		return fmt.Errorf("git: repo \"%s\" or ref \"%s\" not found", name, version)
+	}
+	if extractErr != nil {
+		return extractErr
+	}
+	return waitErr
+}
+
+var errTooLarge = errors.New("too large")
+
+// sizeLimitedReader reads at most `n` bytes, returns `errTooLarge` if the reader has more data.
+type sizeLimitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *sizeLimitedReader) Read(p []byte) (n int, err error) {
+	if l.n <= 0 {
+		// check if there is more data
+		n, err = l.r.Read(make([]byte, 1))
+		if n > 0 {
+			return 0, errTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err = l.r.Read(p)
+	l.n -= int64(n)
+	return
+}
+
+// git runs the git command without the terminal prompt
+func git(args ...string) (output []byte, err error) {
+	return gitWithEnv(nil, args...)
+}
+
+// gitWithEnv runs the git command with the extra env vars
+func gitWithEnv(env []string, args ...string) (output []byte, err error) {
+	stdout, recycle := NewBuffer()
+	defer recycle()
+	errout, recycle := NewBuffer()
+	defer recycle()
+	cmd := gitCommand(env, args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = errout
+	err = cmd.Run()
+	if err != nil {
+		if errout.Len() > 0 {
+			return nil, errors.New(errout.String())
+		}
+		return nil, err
+	}
+	return bytes.Clone(stdout.Bytes()), nil
+}
+
+func gitCommand(env []string, args ...string) *exec.Cmd {
+	cmd := exec.Command("git", args...)
+	cmd.Env = append(append(os.Environ(), "GIT_TERMINAL_PROMPT=0"), env...)
+	return cmd
+}
@@ -1,9 +1,12 @@
 package server
 
 import (
+	"encoding/base64"
+	"io"
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/ije/gox/crypto/rand"
@@ -37,3 +40,100 @@ func TestGhInstall(t *testing.T) {
 		t.Fatal("README.md not found")
 	}
 }
+
+func TestGitInstall(t *testing.T) {
+	root := t.TempDir()
+	gitHosts := config.GitHosts
+	config.GitHosts = map[string]GitHost{"git.example.com": {Url: "file://" + root + "/"}}
+	defer func() { config.GitHosts = gitHosts }()
+
+	// create a local bare repository: `{root}/team/lib.git`
+	src := path.Join(root, "src")
+	os.MkdirAll(path.Join(src, "packages/a"), 0755)
+	os.WriteFile(path.Join(src, "package.json"), []byte(`{"name":"lib","version":"1.0.0","main":"index.js"}`), 0644)
+	os.WriteFile(path.Join(src, "index.js"), []byte(`export default "lib"`), 0644)
+	os.WriteFile(path.Join(src, "packages/a/package.json"), []byte(`{"name":"a","version":"1.0.0","main":"index.js"}`), 0644)
+	os.WriteFile(path.Join(src, "packages/a/index.js"), []byte(`export default "a"`), 0644)
+	for _, args := range [][]string{
+		{"-C", src, "init", "-q", "-b", "main"},
+		{"-C", src, "add", "."},
+		{"-C", src, "-c", "user.name=esm", "-c", "user.email=esm@localhost", "commit", "-q", "-m", "init"},
+		{"-C", src, "tag", "v1.0.0"},
+		{"-C", src, "-c", "user.name=esm", "-c", "user.email=esm@localhost", "commit", "-q", "--allow-empty", "-m", "v1.1.0"},
+		{"-C", src, "tag", "v1.1.0"},
+		{"-C", src, "tag", "v2.0.0-beta.1"},
+		{"clone", "-q", "--bare", src, path.Join(root, "team/lib.git")},
+	} {
+		if _, err := git(args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	esm, _, exactVersion, _, err := praseEsmPath(nil, "/git/git.example.com/team/lib@semver:^1.0.0/es2022/lib.mjs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !esm.GitPrefix || esm.PkgName != "git.example.com/team/lib" || esm.PkgVersion != "v1.1.0" || exactVersion {
+		t.Fatalf("unexpected esm path: %+v", esm)
+	}
+	if esm.Name() != "git/git.example.com/team/lib@v1.1.0" {
+		t.Fatalf("unexpected name: %s", esm.Name())
+	}
+	esm, _, _, _, err = praseEsmPath(nil, "/git/git.example.com/team/lib/-/packages/a@main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isCommitish(esm.PkgVersion) {
+		t.Fatalf("unexpected version: %s", esm.PkgVersion)
+	}
+	if _, _, _, _, err = praseEsmPath(nil, "/git/github.com/team/lib@main"); err == nil {
+		t.Fatal("expected an error for the host that is not allowed")
+	}
+	if _, _, _, _, err = praseEsmPath(nil, "/git/git.example.com/team/../lib@main"); err == nil {
+		t.Fatal("expected an error for the invalid path")
+	}
+
+	wd := t.TempDir()
+	err = gitInstall(wd, "git.example.com/team/lib", "v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !existsFile(path.Join(wd, "node_modules/git.example.com/team/lib/index.js")) {
+		t.Fatal("index.js not found")
+	}
+	err = gitInstall(wd, "git.example.com/team/lib/-/packages/a", esm.PkgVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !existsFile(path.Join(wd, "node_modules/git.example.com/team/lib/-/packages/a/index.js")) {
+		t.Fatal("packages/a/index.js not found")
+	}
+}
+
+func TestSizeLimitedReader(t *testing.T) {
+	r := &sizeLimitedReader{r: strings.NewReader("hello"), n: 5}
+	if data, err := io.ReadAll(r); err != nil || string(data) != "hello" {
+		t.Fatalf("unexpected result: %q %v", data, err)
+	}
+	r = &sizeLimitedReader{r: strings.NewReader("hello world"), n: 5}
+	if _, err := io.ReadAll(r); err != errTooLarge {
+		t.Fatalf("expected errTooLarge, got %v", err)
+	}
+}
+
+func TestGitHostEnv(t *testing.T) {
+	h := &GitHost{Url: "https://git.example.com/", Token: "secret"}
+	cmd := gitCommand(h.gitEnv(), "config", "--get", "http.extraHeader")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(output)) != "Authorization: Basic "+base64.StdEncoding.EncodeToString([]byte("oauth2:secret")) {
+		t.Fatalf("unexpected header: %s", output)
+	}
+	for _, arg := range cmd.Args {
+		if strings.Contains(arg, "Authorization") {
+			t.Fatal("the credentials should not be passed via the command line args")
+		}
+	}
+}
@@ -112,6 +112,11 @@ func validateESMAFeatures(target api.Target) int {
 }
 
 func validateEngineFeatures(engine api.Engine) int {
+	return countFeatures(getUnsupportedEngineFeatures(engine))
+}
+
+// getUnsupportedEngineFeatures returns the JS features that are not supported by the engine.
+func getUnsupportedEngineFeatures(engine api.Engine) compat.JSFeature {
 	constraints := make(map[compat.Engine]compat.Semver)
 
 	if match := regexpBrowserVersion.FindStringSubmatch(engine.Version); match != nil {
@@ -144,7 +149,7 @@ func validateEngineFeatures(engine api.Engine) int {
 		}
 	}
 
-	return countFeatures(compat.UnsupportedJSFeatures(constraints))
+	return compat.UnsupportedJSFeatures(constraints)
 }
 
 func countFeatures(feature compat.JSFeature) int {
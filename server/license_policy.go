@@ -0,0 +1,226 @@
+package server
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/esm-dev/esm.sh/server/common"
+	"github.com/ije/gox/set"
+	"github.com/ije/gox/utils"
+)
+
+type LicenseAction uint8
+
+const (
+	LicenseAllow LicenseAction = iota
+	LicenseWarn
+	LicenseDeny
+)
+
+// IsEnabled checks if the license policy is configured.
+func (policy *LicensePolicy) IsEnabled() bool {
+	return len(policy.Deny) > 0 || len(policy.Warn) > 0 || len(policy.Overrides) > 0
+}
+
+// Check returns the action of the package with the license, the `overrides` of the package take precedence
+// over the `deny` and `warn` lists. A package without license is checked as "NONE".
+func (policy *LicensePolicy) Check(pkgName string, license string) LicenseAction {
+	if override, ok := policy.Overrides[pkgName]; ok {
+		switch override {
+		case "allow":
+			return LicenseAllow
+		case "warn":
+			return LicenseWarn
+		case "deny":
+			return LicenseDeny
+		}
+	}
+	if license == "" {
+		license = "NONE"
+	}
+	if len(policy.Deny) > 0 && matchLicenseExpression(license, policy.Deny) {
+		return LicenseDeny
+	}
+	if len(policy.Warn) > 0 && matchLicenseExpression(license, policy.Warn) {
+		return LicenseWarn
+	}
+	return LicenseAllow
+}
+
+// matchLicenseExpression checks if the SPDX license expression matches the license list, an `OR`
+// expression matches only if all the choices match, and an `AND` expression matches if any of the
+// licenses matches. e.g. "(MIT OR AGPL-3.0)" doesn't match ["AGPL-3.0"] since the MIT license can be chosen.
+func matchLicenseExpression(expr string, list []string) bool {
+	tokens := strings.Fields(strings.NewReplacer("(", " ( ", ")", " ) ").Replace(expr))
+	p := &licenseExprParser{tokens: tokens, list: list}
+	return p.parseOr()
+}
+
+type licenseExprParser struct {
+	tokens []string
+	pos    int
+	list   []string
+}
+
+func (p *licenseExprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *licenseExprParser) parseOr() bool {
+	matched := p.parseAnd()
+	for strings.EqualFold(p.peek(), "OR") {
+		p.pos++
+		// don't short-circuit, the tokens must be consumed
+		matched = p.parseAnd() && matched
+	}
+	return matched
+}
+
+func (p *licenseExprParser) parseAnd() bool {
+	matched := p.parseLicense()
+	for strings.EqualFold(p.peek(), "AND") {
+		p.pos++
+		matched = p.parseLicense() || matched
+	}
+	return matched
+}
+
+func (p *licenseExprParser) parseLicense() bool {
+	token := p.peek()
+	p.pos++
+	if token == "(" {
+		matched := p.parseOr()
+		if p.peek() == ")" {
+			p.pos++
+		}
+		return matched
+	}
+	// skip the license exception, e.g. "GPL-2.0 WITH Classpath-exception-2.0"
+	if strings.EqualFold(p.peek(), "WITH") {
+		p.pos += 2
+	}
+	return matchLicenseId(token, p.list)
+}
+
+// matchLicenseId checks if the license id matches any of the list, the `-only`, `-or-later` and `+`
+// variants are matched as well, and the id ends with `*` matches by prefix, e.g. "GPL-*".
+func matchLicenseId(id string, list []string) bool {
+	id = strings.ToLower(id)
+	for _, s := range list {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if prefix, ok := strings.CutSuffix(s, "*"); ok {
+			if strings.HasPrefix(id, prefix) {
+				return true
+			}
+		} else if id == s || id == s+"-only" || id == s+"-or-later" || id == s+"+" {
+			return true
+		}
+	}
+	return false
+}
+
+// checkLicensePolicy checks the licenses of the package and its bundled dependencies, an error is
+// returned if any of the licenses is denied by the license policy.
+func (ctx *BuildContext) checkLicensePolicy(bundled []*common.SBOMComponent) error {
+	if ctx.pkgJson != nil && config.LicensePolicy.Check(ctx.pkgJson.Name, ctx.pkgJson.License) == LicenseDeny {
+		return newLicenseError(ctx.esm.Specifier(), "", ctx.pkgJson.Name+"@"+ctx.pkgJson.Version, ctx.pkgJson.License)
+	}
+	_, err := checkBundledLicenses(ctx.esm.Specifier(), bundled)
+	return err
+}
+
+// checkBundledLicenses checks the licenses of the bundled dependencies, an error is returned if any of
+// the licenses is denied by the license policy, otherwise the warnings are returned.
+func checkBundledLicenses(specifier string, bundled []*common.SBOMComponent) (warnings []string, err error) {
+	for _, c := range bundled {
+		switch config.LicensePolicy.Check(c.Name, c.License) {
+		case LicenseDeny:
+			return nil, newLicenseError(specifier, "bundled dependency ", c.Name+"@"+c.Version, c.License)
+		case LicenseWarn:
+			warnings = append(warnings, formatLicenseWarning(c.Name+"@"+c.Version, c.License))
+		}
+	}
+	return
+}
+
+// getBundledComponents returns the dependencies that are bundled into the build, the components are
+// read from the installed package.json files without accessing the registry.
+func (ctx *BuildContext) getBundledComponents() []*common.SBOMComponent {
+	if ctx.bundleMode != BundleDeps && ctx.args.format == "" {
+		return nil
+	}
+	root := &common.SBOMComponent{}
+	sbom := &common.SBOM{}
+	wd := path.Join(ctx.npmrc.StoreDir(), ctx.esm.Name())
+	mark := set.New[string]()
+	ctx.walkBundledDeps(sbom, root, wd, path.Join(wd, "node_modules", ctx.esm.PkgName, "package.json"), mark, false)
+	// the targets of the `?alias` query are not declared in the package.json
+	for _, to := range ctx.args.alias {
+		pkg := ctx.resolveAliasTarget(wd, to)
+		if ctx.externalAll || ctx.args.external.Has(pkg.Name) {
+			continue
+		}
+		c := &common.SBOMComponent{Name: pkg.Name, Version: pkg.Version, Purl: common.NpmPurl(pkg.Name, pkg.Version), Scope: "bundled"}
+		var raw PackageJSONRaw
+		if utils.ParseJSONFile(path.Join(wd, "node_modules", pkg.Name, "package.json"), &raw) == nil && raw.Name == pkg.Name {
+			c.License = raw.ToNpmPackage().License
+		} else if info, err := ctx.npmrc.getPackageInfo(pkg.Name, pkg.Version); err == nil {
+			// the alias target may be not installed yet
+			c.License = info.License
+		}
+		c = sbom.Add(c)
+		if !mark.Has(pkg.Name) {
+			mark.Add(pkg.Name)
+			ctx.walkBundledDeps(sbom, c, wd, path.Join(wd, "node_modules", pkg.Name, "package.json"), mark, false)
+		}
+	}
+	return sbom.Components
+}
+
+// resolveAliasTarget resolves the exact version of the alias target, e.g. "lodash@^4.0.0" -> lodash@4.17.21,
+// the version is left as it is if it can't be resolved.
+func (ctx *BuildContext) resolveAliasTarget(wd string, to string) Package {
+	name, version, _, _ := splitEsmPath(to)
+	pkg := Package{Name: name, Version: version}
+	if isExactVersion(version) {
+		return pkg
+	}
+	if version == "" {
+		if v, ok := ctx.args.deps[name]; ok {
+			version = v
+		} else if ctx.pkgJson != nil && ctx.pkgJson.Dependencies[name] != "" {
+			version = ctx.pkgJson.Dependencies[name]
+		} else {
+			version = "latest"
+		}
+	}
+	var raw PackageJSONRaw
+	if utils.ParseJSONFile(path.Join(wd, "node_modules", name, "package.json"), &raw) == nil && raw.Name == name {
+		pkg.Version = raw.Version
+	} else if info, err := ctx.npmrc.getPackageInfo(name, version); err == nil {
+		pkg.Version = info.Version
+	}
+	return pkg
+}
+
+func newLicenseError(pkg string, kind string, name string, license string) *BuildError {
+	if license == "" {
+		license = "NONE"
+	}
+	return &BuildError{
+		Kind:    "license",
+		Message: fmt.Sprintf("%s\"%s\" is licensed under \"%s\" which is denied by the license policy", kind, name, license),
+		Package: pkg,
+	}
+}
+
+func formatLicenseWarning(name string, license string) string {
+	if license == "" {
+		license = "NONE"
+	}
+	return fmt.Sprintf("%s is licensed under %s", name, license)
+}
@@ -0,0 +1,69 @@
+package server
+
+import "testing"
+
+func TestLicensePolicy(t *testing.T) {
+	policy := &LicensePolicy{
+		Deny:      []string{"AGPL-3.0", "GPL-*"},
+		Warn:      []string{"LGPL-2.1", "NONE"},
+		Overrides: map[string]string{"foo": "allow", "bar": "warn", "baz": "deny"},
+	}
+	tests := []struct {
+		name     string
+		license  string
+		expected LicenseAction
+	}{
+		{"a", "MIT", LicenseAllow},
+		{"a", "AGPL-3.0", LicenseDeny},
+		{"a", "agpl-3.0-only", LicenseDeny},
+		{"a", "AGPL-3.0-or-later", LicenseDeny},
+		{"a", "GPL-2.0+", LicenseDeny},
+		{"a", "GPL-2.0 WITH Classpath-exception-2.0", LicenseDeny},
+		{"a", "(MIT OR GPL-3.0)", LicenseAllow},
+		{"a", "(AGPL-3.0 OR GPL-3.0)", LicenseDeny},
+		{"a", "MIT AND AGPL-3.0", LicenseDeny},
+		{"a", "(MIT AND (Apache-2.0 OR AGPL-3.0))", LicenseAllow},
+		{"a", "(MIT OR Apache-2.0) AND GPL-3.0", LicenseDeny},
+		{"a", "LGPL-2.1-only", LicenseWarn},
+		{"a", "", LicenseWarn},
+		{"foo", "AGPL-3.0", LicenseAllow},
+		{"bar", "AGPL-3.0", LicenseWarn},
+		{"baz", "MIT", LicenseDeny},
+	}
+	for _, test := range tests {
+		if action := policy.Check(test.name, test.license); action != test.expected {
+			t.Fatalf("Check(%q, %q): expected %d, got %d", test.name, test.license, test.expected, action)
+		}
+	}
+	if (&LicensePolicy{}).IsEnabled() {
+		t.Fatal("empty license policy should be disabled")
+	}
+}
+
+func TestCheckLicensePolicy(t *testing.T) {
+	ctx := newBundleTestContext(t, map[string]string{
+		"app":      `{"name":"app","version":"1.0.0","license":"MIT","dependencies":{"lgpl-lib":"^1.0.0"}}`,
+		"lgpl-lib": `{"name":"lgpl-lib","version":"1.0.0","license":"LGPL-2.1","dependencies":{"gpl-lib":"^1.0.0"}}`,
+		"gpl-lib":  `{"name":"gpl-lib","version":"1.0.0","license":"GPL-3.0"}`,
+	})
+	licensePolicy := config.LicensePolicy
+	defer func() { config.LicensePolicy = licensePolicy }()
+	config.LicensePolicy = LicensePolicy{Deny: []string{"GPL-*"}, Warn: []string{"LGPL-2.1"}}
+
+	bundled := ctx.getBundledComponents()
+	err := ctx.checkLicensePolicy(bundled)
+	if err == nil || err.(*BuildError).Kind != "license" {
+		t.Fatalf("the bundled gpl dependency should be denied, got %v", err)
+	}
+
+	// the bundled dependencies of a cached build are checked with the current policy
+	meta, err := decodeBuildMeta(encodeBuildMeta(&BuildMeta{BundledDeps: bundled}))
+	if err != nil || len(meta.BundledDeps) != 2 {
+		t.Fatalf("unexpected build meta %v: %v", meta, err)
+	}
+	config.LicensePolicy.Deny = nil
+	warnings, err := checkBundledLicenses(ctx.esm.Specifier(), meta.BundledDeps)
+	if err != nil || len(warnings) != 1 || warnings[0] != "lgpl-lib@1.0.0 is licensed under LGPL-2.1" {
+		t.Fatalf("unexpected warnings %v: %v", warnings, err)
+	}
+}
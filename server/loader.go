@@ -24,6 +24,12 @@ type LoaderOutput struct {
 }
 
 func runLoader(loaderJsPath string, filename string, code string) (output *LoaderOutput, err error) {
+	return runLoaderIn(os.TempDir(), nil, loaderJsPath, filename, code)
+}
+
+// runLoaderIn runs the loader in the given directory, the loader is allowed to read files in the directory
+// and the `allowRead` directories.
+func runLoaderIn(dir string, allowRead []string, loaderJsPath string, filename string, code string) (output *LoaderOutput, err error) {
 	stdout, recycle := NewBuffer()
 	defer recycle()
 	stderr, recycle := NewBuffer()
@@ -34,12 +40,12 @@ func runLoader(loaderJsPath string, filename string, code string) (output *Loade
 		"--no-lock",
 		"--cached-only",
 		"--no-prompt",
-		"--allow-read=.",
+		"--allow-read="+strings.Join(append([]string{"."}, allowRead...), ","),
 		"--quiet",
 		loaderJsPath,
 		filename, // args[0]
 	)
-	cmd.Dir = os.TempDir()
+	cmd.Dir = dir
 	cmd.Stdin = strings.NewReader(code)
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
@@ -142,6 +148,10 @@ func InstallDeno(version string) (installedVersion string, err error) {
 		}
 	}
 
+	if config.Offline {
+		return "", fmt.Errorf("deno not found, please put the deno binary in %s (offline mode)", binDir)
+	}
+
 	url, err := getDenoInstallURL(version)
 	if err != nil {
 		return
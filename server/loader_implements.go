@@ -97,6 +97,213 @@ func resolveSvelteVersion(npmrc *NpmRC, importMap common.ImportMap) (svelteVersi
 	return
 }
 
+// compileStylesheet compiles the sass/scss/less file to css, the imports are resolved in the `wd` directory
+// that contains the `node_modules` directory. The loader is allowed to read the npm store since the packages
+// in the `node_modules` directory are symlinks to their install directories.
+func compileStylesheet(npmrc *NpmRC, wd string, filename string, code string) (output *LoaderOutput, err error) {
+	sassVersion := "1.83.4"
+	lessVersion := "4.2.2"
+	loaderExecPath := path.Join(npmrc.StoreDir(), "sass@"+sassVersion, "loader-less@"+lessVersion+".js")
+
+	once, _ := compileSyncMap.LoadOrStore(loaderExecPath, &sync.Once{})
+	err = once.(*sync.Once).Do(func() (err error) {
+		if !existsFile(loaderExecPath) {
+			if DEBUG {
+				fmt.Println(term.Dim("Compiling stylesheet loader..."))
+			}
+			err = compileStylesheetLoader(npmrc, sassVersion, lessVersion, loaderExecPath)
+		}
+		return
+	})
+	if err != nil {
+		err = errors.New("failed to compile stylesheet loader: " + err.Error())
+		return
+	}
+
+	output, err = runLoaderIn(wd, []string{npmrc.StoreDir()}, loaderExecPath, filename, code)
+	if err != nil {
+		return
+	}
+	output.Lang = "css"
+	return
+}
+
+func compileStylesheetLoader(npmrc *NpmRC, sassVersion string, lessVersion string, loaderExecPath string) (err error) {
+	wd := path.Join(npmrc.StoreDir(), "sass@"+sassVersion)
+
+	// install sass and less
+	pkgJson, err := npmrc.installPackage(Package{Name: "sass", Version: sassVersion})
+	if err != nil {
+		return
+	}
+	npmrc.installDependencies(wd, pkgJson, false, nil)
+	npmrc.installDependencies(wd, &PackageJSON{Dependencies: map[string]string{"less": lessVersion}}, false, nil)
+
+	// the imports are resolved with the Deno file system APIs since the loader is bundled for browsers
+	loaderJS := `
+	  import * as sass from "sass";
+	  import less from "less";
+	  const { stdin, stdout } = Deno;
+	  const write = data => stdout.write(new TextEncoder().encode(data));
+	  const nodeModulesDir = Deno.cwd() + "/node_modules/";
+	  const isFile = filename => {
+	    try {
+	      return Deno.statSync(filename).isFile;
+	    } catch {
+	      return false;
+	    }
+	  };
+	  // the files outside of the allowed directories (the package directory and the npm store) are rejected
+	  const checkPath = filename => {
+	    if (Deno.permissions.querySync({ name: "read", path: filename }).state !== "granted") {
+	      throw new Error("Path '" + filename + "' is not allowed");
+	    }
+	    return filename;
+	  };
+	  const toFilename = (specifier, baseDir) => {
+	    if (specifier.startsWith("~")) {
+	      return nodeModulesDir + specifier.slice(1);
+	    }
+	    if (specifier.startsWith("/")) {
+	      throw new Error("Absolute path '" + specifier + "' is not allowed");
+	    }
+	    if (specifier.startsWith("./") || specifier.startsWith("../") || isFile(baseDir + specifier)) {
+	      return checkPath(new URL(specifier, "file://" + baseDir).pathname);
+	    }
+	    return nodeModulesDir + specifier;
+	  };
+	  const sassImporter = {
+	    canonicalize(url) {
+	      const filename = url.startsWith("file:") ? checkPath(decodeURIComponent(new URL(url).pathname)) : toFilename(url, Deno.cwd() + "/");
+	      const dir = filename.slice(0, filename.lastIndexOf("/") + 1);
+	      const base = filename.slice(dir.length);
+	      const candidates = [];
+	      for (const name of [base, "_" + base]) {
+	        for (const ext of ["", ".scss", ".sass", ".css"]) {
+	          candidates.push(dir + name + ext);
+	        }
+	      }
+	      for (const name of ["index", "_index"]) {
+	        for (const ext of [".scss", ".sass", ".css"]) {
+	          candidates.push(filename + "/" + name + ext);
+	        }
+	      }
+	      const found = candidates.find(isFile);
+	      return found ? new URL("file://" + found) : null;
+	    },
+	    load(url) {
+	      const filename = decodeURIComponent(url.pathname);
+	      const syntax = filename.endsWith(".sass") ? "indented" : filename.endsWith(".css") ? "css" : "scss";
+	      return { contents: Deno.readTextFileSync(filename), syntax };
+	    },
+	  };
+	  class LessFileManager extends less.AbstractFileManager {
+	    supports() {
+	      return true;
+	    }
+	    async loadFile(specifier, currentDirectory) {
+	      const filename = toFilename(specifier, currentDirectory.endsWith("/") ? currentDirectory : currentDirectory + "/");
+	      const found = [filename, filename + ".less", filename + ".css"].find(isFile);
+	      if (!found) {
+	        throw new Error("Could not resolve '" + specifier + "'");
+	      }
+	      return { filename: found, contents: await Deno.readTextFile(found) };
+	    }
+	  }
+	  try {
+	    let sourceCode = "";
+	    for await (const text of stdin.readable.pipeThrough(new TextDecoderStream())) {
+	      sourceCode += text;
+	    }
+	    const filename = Deno.args[0];
+	    let css;
+	    if (filename.endsWith(".less")) {
+	      const plugin = { install(_, pluginManager) { pluginManager.addFileManager(new LessFileManager()) } };
+	      ({ css } = await less.render(sourceCode, { filename, plugins: [plugin] }));
+	    } else {
+	      const syntax = filename.endsWith(".sass") ? "indented" : "scss";
+	      ({ css } = sass.compileString(sourceCode, { url: new URL("file://" + filename), syntax, importer: sassImporter }));
+	    }
+	    await write("1\n" + css);
+	  } catch (err) {
+	    await write("0\n" + err.message);
+	  }
+	`
+	err = buildLoader(wd, loaderJS, loaderExecPath)
+	return
+}
+
+// transpileDeclaration emits the declarations(.d.ts) of the given typescript module without type checking,
+// the exported functions and variables require explicit types as the `isolatedDeclarations` option requires.
+func transpileDeclaration(npmrc *NpmRC, filename string, code string) (output *LoaderOutput, err error) {
+	// use the same TypeScript version as the types are selected with, e.g. "5.7" -> "5.7.3"
+	tsVersion := config.TypeScriptVersion
+	if !isExactVersion(tsVersion) {
+		var info *PackageJSON
+		info, err = npmrc.getPackageInfo("typescript", tsVersion)
+		if err != nil {
+			err = errors.New("failed to resolve typescript@" + tsVersion + ": " + err.Error())
+			return
+		}
+		tsVersion = info.Version
+	}
+	loaderExecPath := path.Join(npmrc.StoreDir(), "typescript@"+tsVersion, "loader-dts.js")
+
+	once, _ := compileSyncMap.LoadOrStore(loaderExecPath, &sync.Once{})
+	err = once.(*sync.Once).Do(func() (err error) {
+		if !existsFile(loaderExecPath) {
+			if DEBUG {
+				fmt.Println(term.Dim("Compiling declaration loader..."))
+			}
+			err = compileDeclarationLoader(npmrc, tsVersion, loaderExecPath)
+		}
+		return
+	})
+	if err != nil {
+		err = errors.New("failed to compile declaration loader: " + err.Error())
+		return
+	}
+
+	return runLoader(loaderExecPath, filename, code)
+}
+
+func compileDeclarationLoader(npmrc *NpmRC, tsVersion string, loaderExecPath string) (err error) {
+	wd := path.Join(npmrc.StoreDir(), "typescript@"+tsVersion)
+
+	// install typescript
+	_, err = npmrc.installPackage(Package{Name: "typescript", Version: tsVersion})
+	if err != nil {
+		return
+	}
+
+	loaderJS := `
+	  import ts from "typescript";
+	  const { stdin, stdout } = Deno;
+	  const write = data => stdout.write(new TextEncoder().encode(data));
+	  try {
+	    let sourceCode = "";
+	    for await (const text of stdin.readable.pipeThrough(new TextDecoderStream())) {
+	      sourceCode += text;
+	    }
+	    const { outputText } = ts.transpileDeclaration(sourceCode, {
+	      fileName: Deno.args[0],
+	      compilerOptions: {
+	        isolatedDeclarations: true,
+	        allowImportingTsExtensions: true,
+	        jsx: ts.JsxEmit.ReactJSX,
+	        target: ts.ScriptTarget.ESNext,
+	        module: ts.ModuleKind.ESNext,
+	      },
+	    });
+	    await write("2\n" + outputText);
+	  } catch (err) {
+	    await write("0\n" + err.message);
+	  }
+	`
+	err = buildLoader(wd, loaderJS, loaderExecPath)
+	return
+}
+
 func generateUnoCSS(npmrc *NpmRC, configCSS string, content string) (output *LoaderOutput, err error) {
 	loaderVersion := "0.4.3"
 	loaderExecPath := path.Join(config.WorkDir, "bin", "unocss-"+loaderVersion)
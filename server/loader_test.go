@@ -0,0 +1,52 @@
+package server
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestCompileStylesheet(t *testing.T) {
+	workDir := config.WorkDir
+	defer func() { config.WorkDir = workDir }()
+	config.WorkDir = t.TempDir()
+
+	if _, err := InstallDeno("2.2.1"); err != nil {
+		t.Skipf("deno is not available: %v", err)
+	}
+
+	npmrc := &NpmRC{NpmRegistry: NpmRegistry{Registry: "https://registry.npmjs.org/"}}
+	wd := path.Join(npmrc.StoreDir(), "app@1.0.0")
+	depDir := path.Join(npmrc.StoreDir(), "dep@1.0.0", "node_modules", "dep")
+	for filename, content := range map[string]string{
+		path.Join(wd, "node_modules", "app", "style.scss"): `@use "~dep/vars" as v; @use "dep/mixins"; a { color: v.$color; }`,
+		path.Join(wd, "node_modules", "app", "style.less"): `@import "~dep/vars.less"; a { color: @color; }`,
+		path.Join(depDir, "_vars.scss"):                    `$color: red;`,
+		path.Join(depDir, "_mixins.scss"):                  `b { color: blue; }`,
+		path.Join(depDir, "vars.less"):                     `@color: green;`,
+	} {
+		os.MkdirAll(path.Dir(filename), 0755)
+		os.WriteFile(filename, []byte(content), 0644)
+	}
+	// the dependencies in the node_modules directory are symlinks to the install directories as `installDependencies` does
+	os.Symlink(depDir, path.Join(wd, "node_modules", "dep"))
+
+	for name, expected := range map[string]string{"style.scss": "color: red", "style.less": "color: green"} {
+		filename := path.Join(wd, "node_modules", "app", name)
+		code, _ := os.ReadFile(filename)
+		out, err := compileStylesheet(npmrc, wd, filename, string(code))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out.Code, expected) {
+			t.Fatalf("unexpected output of %s: %s", name, out.Code)
+		}
+	}
+
+	filename := path.Join(wd, "node_modules", "app", "style.scss")
+	_, err := compileStylesheet(npmrc, wd, filename, `@use "/etc/passwd";`)
+	if err == nil || !strings.Contains(err.Error(), "is not allowed") {
+		t.Fatalf("the absolute path should be rejected, got %v", err)
+	}
+}
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"bytes"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
@@ -10,6 +12,7 @@ import (
 	"strings"
 
 	"github.com/esm-dev/esm.sh/server/common"
+	"github.com/esm-dev/esm.sh/server/storage"
 	esbuild "github.com/evanw/esbuild/pkg/api"
 	esbuild_config "github.com/ije/esbuild-internal/config"
 	"github.com/ije/esbuild-internal/js_ast"
@@ -95,7 +98,16 @@ func minify(code string, loader esbuild.Loader, target esbuild.Target) ([]byte,
 	return concatBytes(ret.LegalComments, ret.Code), nil
 }
 
-func treeShake(code []byte, exports []string, target esbuild.Target) ([]byte, error) {
+// treeShake removes the unused exports of the module, the source map of the module is composed
+// into the result if it's provided.
+func treeShake(code []byte, sourceMap []byte, exports []string, target string) (js []byte, jsMap []byte, err error) {
+	if len(sourceMap) > 0 {
+		// esbuild composes the inline source map of the input module
+		if i := bytes.LastIndex(code, []byte("//# sourceMappingURL=")); i >= 0 {
+			code = code[:i]
+		}
+		code = concatBytes(code, []byte("\n//# sourceMappingURL=data:application/json;base64,"+base64.StdEncoding.EncodeToString(sourceMap)))
+	}
 	input := &esbuild.StdinOptions{
 		Contents: fmt.Sprintf(`export { %s } from '.';`, strings.Join(exports, ", ")),
 		Loader:   esbuild.LoaderJS,
@@ -117,11 +129,12 @@ func treeShake(code []byte, exports []string, target esbuild.Target) ([]byte, er
 			},
 		},
 	}
-	ret := esbuild.Build(esbuild.BuildOptions{
+	options := esbuild.BuildOptions{
 		Stdin:             input,
 		Bundle:            true,
 		Format:            esbuild.FormatESModule,
-		Target:            target,
+		Target:            targets[target],
+		Engines:           getBuildEngines(target),
 		Platform:          esbuild.PlatformBrowser,
 		MinifyWhitespace:  config.Minify,
 		MinifyIdentifiers: config.Minify,
@@ -129,11 +142,47 @@ func treeShake(code []byte, exports []string, target esbuild.Target) ([]byte, er
 		Outdir:            "/esbuild",
 		Write:             false,
 		Plugins:           plugins,
-	})
+	}
+	if len(sourceMap) > 0 {
+		options.Sourcemap = esbuild.SourceMapExternal
+	}
+	ret := esbuild.Build(options)
 	if len(ret.Errors) > 0 {
-		return nil, errors.New(ret.Errors[0].Text)
+		return nil, nil, errors.New(ret.Errors[0].Text)
+	}
+	for _, file := range ret.OutputFiles {
+		if strings.HasSuffix(file.Path, ".js") {
+			js = file.Contents
+		} else if strings.HasSuffix(file.Path, ".js.map") {
+			jsMap = file.Contents
+		}
 	}
-	return ret.OutputFiles[0].Contents, nil
+	return
+}
+
+// treeShakeAndSave tree-shakes the module with the source map at `sourceMapPath`, and saves the result to `savePath`.
+func treeShakeAndSave(buildStorage storage.Storage, code []byte, sourceMapPath string, savePath string, exports []string, target string) ([]byte, error) {
+	var sourceMap []byte
+	if config.SourceMap {
+		f, _, err := buildStorage.Get(sourceMapPath)
+		if err == nil {
+			sourceMap, err = io.ReadAll(f)
+			f.Close()
+		}
+		if err != nil && err != storage.ErrNotFound {
+			return nil, err
+		}
+	}
+	ret, retMap, err := treeShake(code, sourceMap, exports, target)
+	if err != nil {
+		return nil, err
+	}
+	if len(retMap) > 0 {
+		ret = concatBytes(ret, []byte("//# sourceMappingURL="+path.Base(savePath)+".map"))
+		go buildStorage.Put(savePath+".map", bytes.NewReader(retMap))
+	}
+	go buildStorage.Put(savePath, bytes.NewReader(ret))
+	return ret, nil
 }
 
 // bundleHttpModule bundles the http module and it's submodules.
@@ -147,8 +196,19 @@ func bundleHttpModule(npmrc *NpmRC, entry string, importMap common.ImportMap, co
 		err = errors.New("invalid enrtry, require a valid url")
 		return
 	}
+	entryPoints := []string{entry}
+	var stdin *esbuild.StdinOptions
+	// export the class names of the css module
+	if strings.HasSuffix(entryUrl.Path, ".module.css") {
+		entryPoints = nil
+		stdin = &esbuild.StdinOptions{
+			Contents: fmt.Sprintf(`export { default } from "%s";`, entry),
+			Loader:   esbuild.LoaderJS,
+		}
+	}
 	ret := esbuild.Build(esbuild.BuildOptions{
-		EntryPoints:      []string{entry},
+		EntryPoints:      entryPoints,
+		Stdin:            stdin,
 		Target:           esbuild.ESNext,
 		Format:           esbuild.FormatESModule,
 		Platform:         esbuild.PlatformBrowser,
@@ -228,6 +288,9 @@ func bundleHttpModule(npmrc *NpmRC, entry string, importMap common.ImportMap, co
 							jsx = true
 						case ".css":
 							loader = esbuild.LoaderCSS
+							if strings.HasSuffix(url.Path, ".module.css") {
+								loader = esbuild.LoaderLocalCSS
+							}
 						case ".json":
 							loader = esbuild.LoaderJSON
 						case ".svelte":
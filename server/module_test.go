@@ -0,0 +1,59 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	esbuild "github.com/evanw/esbuild/pkg/api"
+)
+
+func TestTreeShake(t *testing.T) {
+	res := esbuild.Build(esbuild.BuildOptions{
+		Stdin: &esbuild.StdinOptions{
+			Contents:   "export function foo() {\n  return 1;\n}\n\nexport function bar() {\n  throw new Error('bar');\n}\n",
+			Sourcefile: "lib.js",
+		},
+		Format:    esbuild.FormatESModule,
+		Sourcemap: esbuild.SourceMapExternal,
+		Outdir:    "/esbuild",
+	})
+	if len(res.Errors) > 0 {
+		t.Fatal(res.Errors[0].Text)
+	}
+	var code, sourceMap []byte
+	for _, file := range res.OutputFiles {
+		if strings.HasSuffix(file.Path, ".js") {
+			code = concatBytes(file.Contents, []byte("//# sourceMappingURL=lib.mjs.map"))
+		} else {
+			sourceMap = file.Contents
+		}
+	}
+
+	js, jsMap, err := treeShake(code, nil, []string{"bar"}, "es2022")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(js), "foo") || !strings.Contains(string(js), "bar") || jsMap != nil {
+		t.Fatalf("unexpected tree-shaking result: %s", js)
+	}
+
+	js, jsMap, err = treeShake(code, sourceMap, []string{"bar"}, "es2022")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(js), "foo") || strings.Contains(string(js), "sourceMappingURL") {
+		t.Fatalf("unexpected tree-shaking result: %s", js)
+	}
+	var m struct {
+		Sources  []string `json:"sources"`
+		Mappings string   `json:"mappings"`
+	}
+	if err := json.Unmarshal(jsMap, &m); err != nil {
+		t.Fatal(err)
+	}
+	// the composed source map should point to the original source
+	if len(m.Sources) != 1 || m.Sources[0] != "lib.js" || m.Mappings == "" {
+		t.Fatalf("unexpected source map: %s", jsMap)
+	}
+}
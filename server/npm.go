@@ -4,10 +4,15 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"net/http"
 	"net/url"
@@ -40,6 +45,7 @@ type Package struct {
 	Name     string
 	Version  string
 	Github   bool
+	Git      bool
 	PkgPrNew bool
 }
 
@@ -48,6 +54,9 @@ func (p *Package) String() string {
 	if p.Github {
 		return "gh/" + s
 	}
+	if p.Git {
+		return "git/" + s
+	}
 	if p.PkgPrNew {
 		return "pr/" + s
 	}
@@ -76,16 +85,20 @@ type PackageJSONRaw struct {
 	Dependencies     any             `json:"dependencies"`
 	PeerDependencies any             `json:"peerDependencies"`
 	Imports          any             `json:"imports"`
-	TypesVersions    any             `json:"typesVersions"`
+	TypesVersions    json.RawMessage `json:"typesVersions"`
 	Exports          json.RawMessage `json:"exports"`
 	Esmsh            any             `json:"esm.sh"`
 	Dist             json.RawMessage `json:"dist"`
 	Deprecated       any             `json:"deprecated"`
+	License          any             `json:"license"`
+	Licenses         any             `json:"licenses"`
 }
 
 // NpmPackageDist defines the dist field of a NPM package
 type NpmPackageDist struct {
-	Tarball string `json:"tarball"`
+	Tarball   string `json:"tarball"`
+	Integrity string `json:"integrity"`
+	Shasum    string `json:"shasum,omitempty"`
 }
 
 // PackageJSON defines the package.json of a NPM package
@@ -104,11 +117,12 @@ type PackageJSON struct {
 	Dependencies     map[string]string
 	PeerDependencies map[string]string
 	Imports          map[string]any
-	TypesVersions    map[string]any
+	TypesVersions    JSONObject
 	Exports          JSONObject
 	Esmsh            map[string]any
 	Dist             NpmPackageDist
 	Deprecated       string
+	License          string
 }
 
 // ToNpmPackage converts PackageJSONRaw to PackageJSON
@@ -192,6 +206,11 @@ func (a *PackageJSONRaw) ToNpmPackage() *PackageJSON {
 		}
 	}
 
+	typesVersions := JSONObject{}
+	if rawTypesVersions := a.TypesVersions; rawTypesVersions != nil {
+		typesVersions.UnmarshalJSON(rawTypesVersions)
+	}
+
 	depreacted := ""
 	if a.Deprecated != nil {
 		if s, ok := a.Deprecated.(string); ok {
@@ -199,6 +218,23 @@ func (a *PackageJSONRaw) ToNpmPackage() *PackageJSON {
 		}
 	}
 
+	license := toLicenseString(a.License)
+	if license == "" {
+		// the deprecated `licenses` field, e.g. [{ "type": "MIT" }, { "type": "Apache-2.0" }]
+		if licenses, ok := a.Licenses.([]any); ok {
+			names := make([]string, 0, len(licenses))
+			for _, v := range licenses {
+				if name := toLicenseString(v); name != "" {
+					names = append(names, name)
+				}
+			}
+			license = strings.Join(names, " OR ")
+			if len(names) > 1 {
+				license = "(" + license + ")"
+			}
+		}
+	}
+
 	var dist NpmPackageDist
 	if a.Dist != nil {
 		json.Unmarshal(a.Dist, &dist)
@@ -218,10 +254,11 @@ func (a *PackageJSONRaw) ToNpmPackage() *PackageJSON {
 		Dependencies:     dependencies,
 		PeerDependencies: peerDependencies,
 		Imports:          toMap(a.Imports),
-		TypesVersions:    toMap(a.TypesVersions),
+		TypesVersions:    typesVersions,
 		Exports:          exports,
 		Esmsh:            toMap(a.Esmsh),
 		Deprecated:       depreacted,
+		License:          license,
 		Dist:             dist,
 	}
 
@@ -251,10 +288,11 @@ func (a *PackageJSON) UnmarshalJSON(b []byte) error {
 }
 
 type NpmRegistry struct {
-	Registry string `json:"registry"`
-	Token    string `json:"token"`
-	User     string `json:"user"`
-	Password string `json:"password"`
+	Registry string   `json:"registry"`
+	Mirrors  []string `json:"mirrors"`
+	Token    string   `json:"token"`
+	User     string   `json:"user"`
+	Password string   `json:"password"`
 }
 
 type NpmRC struct {
@@ -274,6 +312,7 @@ func DefaultNpmRC() *NpmRC {
 	defaultNpmRC = &NpmRC{
 		NpmRegistry: NpmRegistry{
 			Registry: config.NpmRegistry,
+			Mirrors:  config.NpmRegistryMirrors,
 			Token:    config.NpmToken,
 			User:     config.NpmUser,
 			Password: config.NpmPassword,
@@ -288,12 +327,22 @@ func DefaultNpmRC() *NpmRC {
 		for scope, reg := range config.NpmScopedRegistries {
 			defaultNpmRC.ScopedRegistries[scope] = NpmRegistry{
 				Registry: reg.Registry,
+				Mirrors:  reg.Mirrors,
 				Token:    reg.Token,
 				User:     reg.User,
 				Password: reg.Password,
 			}
 		}
 	}
+	// track the health of the registries that have mirrors
+	if len(defaultNpmRC.Mirrors) > 0 {
+		trackRegistryHealth(defaultNpmRC.Registries()...)
+	}
+	for _, reg := range defaultNpmRC.ScopedRegistries {
+		if len(reg.Mirrors) > 0 {
+			trackRegistryHealth(reg.Registries()...)
+		}
+	}
 	return defaultNpmRC
 }
 
@@ -359,16 +408,13 @@ func (npmrc *NpmRC) getPackageInfo(pkgName string, version string) (packageJson
 			}
 		}
 
-		regUrl := reg.Registry + pkgName
-		isWellknownVersion := (isExactVersion(version) || isDistTag(version)) && strings.HasPrefix(regUrl, npmRegistry)
-		if isWellknownVersion {
-			// npm registry supports url like `https://registry.npmjs.org/<name>/<version>`
-			regUrl += "/" + version
-		}
-
-		u, err := url.Parse(regUrl)
-		if err != nil {
-			return nil, "", err
+		// resolve the version from the npm store in offline mode
+		if config.Offline {
+			p, err := npmrc.resolveInstalledPackage(pkgName, version)
+			if err != nil {
+				return nil, "", err
+			}
+			return p, getCacheKey(pkgName, p.Version), nil
 		}
 
 		header := http.Header{}
@@ -381,15 +427,17 @@ func (npmrc *NpmRC) getPackageInfo(pkgName string, version string) (packageJson
 		fetchClient, recycle := NewFetchClient(15, "esmd/"+VERSION, false)
 		defer recycle()
 
-		retryTimes := 0
-	RETRY:
-		res, err := fetchClient.Fetch(u, header)
-		if err != nil {
-			if retryTimes < 3 {
-				retryTimes++
-				time.Sleep(time.Duration(retryTimes) * 100 * time.Millisecond)
-				goto RETRY
+		var isWellknownVersion bool
+		res, err := reg.fetch(fetchClient, header, func(registry string) (string, bool) {
+			regUrl := registry + pkgName
+			isWellknownVersion = (isExactVersion(version) || isDistTag(version)) && strings.HasPrefix(regUrl, npmRegistry)
+			if isWellknownVersion {
+				// npm registry supports url like `https://registry.npmjs.org/<name>/<version>`
+				regUrl += "/" + version
 			}
+			return regUrl, true
+		})
+		if err != nil {
 			return nil, "", err
 		}
 		defer res.Body.Close()
@@ -477,6 +525,107 @@ func (npmrc *NpmRC) getPackageInfo(pkgName string, version string) (packageJson
 	})
 }
 
+// getPackagePublishTime returns the publish time of the package version from the `time` field of the package metadata.
+func (npmrc *NpmRC) getPackagePublishTime(pkgName string, version string) (publishTime time.Time, err error) {
+	reg := npmrc.getRegistryByPackageName(pkgName)
+	// the publish time of a version never changes
+	return withCache(reg.Registry+pkgName+"@"+version+"#time", 24*time.Hour, func() (time.Time, string, error) {
+		header := http.Header{}
+		if reg.Token != "" {
+			header.Set("Authorization", "Bearer "+reg.Token)
+		} else if reg.User != "" && reg.Password != "" {
+			header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(reg.User+":"+reg.Password)))
+		}
+
+		fetchClient, recycle := NewFetchClient(15, "esmd/"+VERSION, false)
+		defer recycle()
+
+		res, err := reg.fetch(fetchClient, header, func(registry string) (string, bool) {
+			return registry + pkgName, true
+		})
+		if err != nil {
+			return time.Time{}, "", err
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != 200 {
+			return time.Time{}, "", fmt.Errorf("could not get metadata of package '%s' (%s)", pkgName, res.Status)
+		}
+
+		var metadata struct {
+			Time map[string]string `json:"time"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&metadata)
+		if err != nil {
+			return time.Time{}, "", err
+		}
+
+		t, ok := metadata.Time[version]
+		if !ok {
+			return time.Time{}, "", fmt.Errorf("publish time of '%s@%s' not found", pkgName, version)
+		}
+		publishTime, err := time.Parse(time.RFC3339, t)
+		return publishTime, "", err
+	})
+}
+
+// resolveInstalledPackage resolves the package version from the packages installed in the npm store.
+// This is used in offline mode instead of querying the registry.
+func (npmrc *NpmRC) resolveInstalledPackage(pkgName string, version string) (packageJson *PackageJSON, err error) {
+	dir := npmrc.StoreDir()
+	name := pkgName
+	if strings.HasPrefix(pkgName, "@") {
+		var scope string
+		scope, name = utils.SplitByFirstByte(pkgName, '/')
+		dir = path.Join(dir, scope)
+	}
+
+	var c *semver.Constraints
+	if version != "latest" {
+		c, err = semver.NewConstraint(version)
+		if err != nil {
+			return nil, fmt.Errorf("offline mode: version %s of '%s' not found", version, pkgName)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return
+	}
+
+	var matched *semver.Version
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), name+"@") {
+			continue
+		}
+		v, e := semver.NewVersion(strings.TrimPrefix(entry.Name(), name+"@"))
+		if e != nil {
+			continue
+		}
+		if c == nil {
+			// ignore prerelease versions for the `latest` tag
+			if v.Prerelease() != "" {
+				continue
+			}
+		} else if !c.Check(v) {
+			continue
+		}
+		if matched == nil || v.GreaterThan(matched) {
+			matched = v
+		}
+	}
+	if matched == nil {
+		return nil, fmt.Errorf("offline mode: version %s of '%s' not found", version, pkgName)
+	}
+
+	var raw PackageJSONRaw
+	err = utils.ParseJSONFile(path.Join(npmrc.StoreDir(), pkgName+"@"+matched.Original(), "node_modules", pkgName, "package.json"), &raw)
+	if err != nil {
+		return nil, fmt.Errorf("offline mode: version %s of '%s' not found", version, pkgName)
+	}
+	return raw.ToNpmPackage(), nil
+}
+
 func (npmrc *NpmRC) installPackage(pkg Package) (packageJson *PackageJSON, err error) {
 	installDir := path.Join(npmrc.StoreDir(), pkg.String())
 	packageJsonPath := path.Join(installDir, "node_modules", pkg.Name, "package.json")
@@ -488,6 +637,12 @@ func (npmrc *NpmRC) installPackage(pkg Package) (packageJson *PackageJSON, err e
 		return
 	}
 
+	// the package can not be installed in offline mode
+	if config.Offline {
+		err = fmt.Errorf("offline mode: package '%s' not found", pkg.String())
+		return
+	}
+
 	// only one installation process is allowed at the same time for the same package
 	unlock := installMutex.Lock(pkg.String())
 	defer unlock()
@@ -498,8 +653,12 @@ func (npmrc *NpmRC) installPackage(pkg Package) (packageJson *PackageJSON, err e
 		return
 	}
 
-	if pkg.Github {
-		err = ghInstall(installDir, pkg.Name, pkg.Version)
+	if pkg.Github || pkg.Git {
+		if pkg.Git {
+			err = gitInstall(installDir, pkg.Name, pkg.Version)
+		} else {
+			err = ghInstall(installDir, pkg.Name, pkg.Version)
+		}
 		// ensure 'package.json' file if not exists after installing from github
 		if err == nil && !existsFile(packageJsonPath) {
 			buf := bytes.NewBuffer(nil)
@@ -550,7 +709,7 @@ func (npmrc *NpmRC) installPackage(pkg Package) (packageJson *PackageJSON, err e
 			}
 		}
 	} else if pkg.PkgPrNew {
-		err = fetchPackageTarball(&NpmRegistry{}, installDir, pkg.Name, "https://pkg.pr.new/"+pkg.Name+"@"+pkg.Version)
+		err = fetchPackageTarball(&NpmRegistry{}, installDir, pkg.Name, NpmPackageDist{Tarball: "https://pkg.pr.new/" + pkg.Name + "@" + pkg.Version})
 	} else {
 		info, fetchErr := npmrc.getPackageInfo(pkg.Name, pkg.Version)
 		if fetchErr != nil {
@@ -559,7 +718,11 @@ func (npmrc *NpmRC) installPackage(pkg Package) (packageJson *PackageJSON, err e
 		if info.Deprecated != "" {
 			os.WriteFile(path.Join(installDir, "deprecated.txt"), []byte(info.Deprecated), 0644)
 		}
-		err = fetchPackageTarball(npmrc.getRegistryByPackageName(pkg.Name), installDir, info.Name, info.Dist.Tarball)
+		err = fetchPackageTarball(npmrc.getRegistryByPackageName(pkg.Name), installDir, info.Name, info.Dist)
+		if err == nil {
+			// the installed package.json doesn't have the `dist` field
+			os.WriteFile(path.Join(installDir, "dist.json"), utils.MustEncodeJSON(info.Dist), 0644)
+		}
 	}
 	if err != nil {
 		return
@@ -607,7 +770,7 @@ func (npmrc *NpmRC) installDependencies(wd string, pkgJson *PackageJSON, npmMode
 				// skip installing `@types/*` packages
 				return
 			}
-			if !isExactVersion(pkg.Version) && !pkg.Github && !pkg.PkgPrNew {
+			if !isExactVersion(pkg.Version) && !pkg.Github && !pkg.Git && !pkg.PkgPrNew {
 				p, e := npmrc.getPackageInfo(pkg.Name, pkg.Version)
 				if e != nil {
 					return
@@ -654,12 +817,19 @@ func (npmrc *NpmRC) isDeprecated(pkgName string, pkgVersion string) (string, err
 	return string(data), nil
 }
 
-func fetchPackageTarball(reg *NpmRegistry, installDir string, pkgName string, tarballUrl string) (err error) {
-	u, err := url.Parse(tarballUrl)
-	if err != nil {
-		return
+// getInstalledPackageDist returns the `dist` field of the package that is saved by the `installPackage` function
+func (npmrc *NpmRC) getInstalledPackageDist(pkgName string, pkgVersion string) (dist NpmPackageDist, err error) {
+	installDir := path.Join(npmrc.StoreDir(), pkgName+"@"+pkgVersion)
+	err = utils.ParseJSONFile(path.Join(installDir, "dist.json"), &dist)
+	if err != nil && os.IsNotExist(err) {
+		err = nil
 	}
+	return
+}
 
+// fetchPackageTarball downloads the tarball of the package and extracts it to the install directory, the tarball
+// is verified with the `integrity` (or the `shasum`) of the dist before extracting.
+func fetchPackageTarball(reg *NpmRegistry, installDir string, pkgName string, dist NpmPackageDist) (err error) {
 	header := http.Header{}
 	if reg.Token != "" {
 		header.Set("Authorization", "Bearer "+reg.Token)
@@ -670,15 +840,8 @@ func fetchPackageTarball(reg *NpmRegistry, installDir string, pkgName string, ta
 	fetchClient, recycle := NewFetchClient(30, "esmd/"+VERSION, false)
 	defer recycle()
 
-	retryTimes := 0
-RETRY:
-	res, err := fetchClient.Fetch(u, header)
+	res, err := reg.fetch(fetchClient, header, reg.resolveRegistryResource(dist.Tarball))
 	if err != nil {
-		if retryTimes < 3 {
-			retryTimes++
-			time.Sleep(time.Duration(retryTimes) * 100 * time.Millisecond)
-			goto RETRY
-		}
 		return
 	}
 	defer res.Body.Close()
@@ -694,7 +857,32 @@ RETRY:
 		return
 	}
 
-	err = extractPackageTarball(installDir, pkgName, io.LimitReader(res.Body, maxPackageTarballSize))
+	var tarball io.Reader = io.LimitReader(res.Body, maxPackageTarballSize)
+	if h, digest := parseIntegrity(dist.Integrity, dist.Shasum); h != nil {
+		// save the tarball to a temporary file to verify it before extracting
+		var tmpFile *os.File
+		tmpFile, err = os.CreateTemp("", "esm-tarball-*.tgz")
+		if err != nil {
+			return
+		}
+		defer os.Remove(tmpFile.Name())
+		defer tmpFile.Close()
+		_, err = io.Copy(io.MultiWriter(tmpFile, h), tarball)
+		if err != nil {
+			return
+		}
+		if !bytes.Equal(h.Sum(nil), digest) {
+			err = fmt.Errorf("integrity check failed for tarball of package '%s'", path.Base(installDir))
+			return
+		}
+		_, err = tmpFile.Seek(0, io.SeekStart)
+		if err != nil {
+			return
+		}
+		tarball = tmpFile
+	}
+
+	err = extractPackageTarball(installDir, pkgName, tarball)
 	if err != nil {
 		// clear installDir if failed to extract tarball
 		os.RemoveAll(installDir)
@@ -702,6 +890,44 @@ RETRY:
 	return
 }
 
+// parseIntegrity returns the hash of the strongest algorithm in the subresource integrity and the expected digest,
+// the sha1 `shasum` is used if the integrity is not provided. A nil hash is returned if there is nothing to verify.
+func parseIntegrity(integrity string, shasum string) (h hash.Hash, digest []byte) {
+	priority := 0
+	for _, s := range strings.Fields(integrity) {
+		algo, value := utils.SplitByFirstByte(s, '-')
+		// strip the options, e.g. "sha512-xxx?foo"
+		value, _ = utils.SplitByFirstByte(value, '?')
+		sum, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			continue
+		}
+		var newHash func() hash.Hash
+		var p int
+		switch algo {
+		case "sha1":
+			newHash, p = sha1.New, 1
+		case "sha256":
+			newHash, p = sha256.New, 2
+		case "sha384":
+			newHash, p = sha512.New384, 3
+		case "sha512":
+			newHash, p = sha512.New, 4
+		default:
+			continue
+		}
+		if p > priority {
+			h, digest, priority = newHash(), sum, p
+		}
+	}
+	if h == nil && shasum != "" {
+		if sum, err := hex.DecodeString(shasum); err == nil {
+			h, digest = sha1.New(), sum
+		}
+	}
+	return
+}
+
 func extractPackageTarball(installDir string, pkgName string, tarball io.Reader) (err error) {
 	unziped, err := gzip.NewReader(tarball)
 	if err != nil {
@@ -794,7 +1020,21 @@ func resolveDependencyVersion(v string) (Package, error) {
 	}
 	if strings.HasPrefix(v, "git+ssh://") || strings.HasPrefix(v, "git+https://") || strings.HasPrefix(v, "git://") {
 		gitUrl, e := url.Parse(v)
-		if e != nil || gitUrl.Hostname() != "github.com" {
+		if e != nil {
+			return Package{}, errors.New("unsupported git dependency")
+		}
+		if gitUrl.Hostname() != "github.com" {
+			// check the git hosts in the config
+			remote, _ := utils.SplitByFirstByte(strings.TrimPrefix(v, "git+"), '#')
+			for host, h := range config.GitHosts {
+				if strings.HasPrefix(remote, h.Url) {
+					return Package{
+						Git:     true,
+						Name:    host + "/" + strings.TrimSuffix(strings.TrimPrefix(remote, h.Url), ".git"),
+						Version: strings.TrimPrefix(gitUrl.Fragment, "semver:"),
+					}, nil
+				}
+			}
 			return Package{}, errors.New("unsupported git dependency")
 		}
 		repo := strings.TrimSuffix(gitUrl.Path[1:], ".git")
@@ -930,3 +1170,17 @@ func toMap(v any) map[string]any {
 	}
 	return nil
 }
+
+// toLicenseString returns the license name of the `license` field, which is a string or an object
+// like `{ "type": "MIT" }` in old packages.
+func toLicenseString(v any) string {
+	switch l := v.(type) {
+	case string:
+		return strings.TrimSpace(l)
+	case map[string]any:
+		if t, ok := l["type"].(string); ok {
+			return strings.TrimSpace(t)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,349 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ije/gox/log"
+)
+
+const (
+	// open the circuit of a registry after the number of consecutive failures
+	registryCircuitThreshold = 3
+	// the time to wait before trying a registry with an open circuit again
+	registryCircuitCooldown = 30 * time.Second
+	// the interval of the background health check
+	registryHealthCheckInterval = time.Minute
+)
+
+var (
+	registryHealthLock sync.RWMutex
+	registryHealthMap  = map[string]*RegistryHealth{}
+)
+
+// RegistryHealth tracks the health of a npm registry (or mirror)
+type RegistryHealth struct {
+	lock      sync.Mutex
+	registry  string
+	failures  int
+	openUntil time.Time
+	latency   time.Duration
+	lastError string
+	lastCheck time.Time
+}
+
+// RegistryStatus is the health status of a registry that is shown in `/status.json`
+type RegistryStatus struct {
+	Registry  string `json:"registry"`
+	Status    string `json:"status"`
+	Latency   int64  `json:"latency"`
+	Failures  int    `json:"failures"`
+	LastError string `json:"lastError,omitempty"`
+	LastCheck string `json:"lastCheck,omitempty"`
+}
+
+// trackRegistryHealth enables health tracking for the given registries.
+// Only mirrored registries from the server config are tracked, registries from the `X-Npmrc` header are not.
+func trackRegistryHealth(registries ...string) {
+	registryHealthLock.Lock()
+	defer registryHealthLock.Unlock()
+	for _, registry := range registries {
+		if registry != "" {
+			if _, ok := registryHealthMap[registry]; !ok {
+				registryHealthMap[registry] = &RegistryHealth{registry: registry}
+			}
+		}
+	}
+}
+
+// getRegistryHealth returns the health tracker of the given registry, or nil if it's not tracked.
+func getRegistryHealth(registry string) *RegistryHealth {
+	registryHealthLock.RLock()
+	defer registryHealthLock.RUnlock()
+	return registryHealthMap[registry]
+}
+
+// getRegistryStatus returns the health status of all tracked registries.
+func getRegistryStatus() []RegistryStatus {
+	registryHealthLock.RLock()
+	list := make([]*RegistryHealth, 0, len(registryHealthMap))
+	for _, h := range registryHealthMap {
+		list = append(list, h)
+	}
+	registryHealthLock.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].registry < list[j].registry
+	})
+	now := time.Now()
+	status := make([]RegistryStatus, len(list))
+	for i, h := range list {
+		status[i] = h.Status(now)
+	}
+	return status
+}
+
+// Available returns true if the circuit of the registry is closed or half-open.
+func (h *RegistryHealth) Available(now time.Time) bool {
+	if h == nil {
+		return true
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.failures < registryCircuitThreshold || now.After(h.openUntil)
+}
+
+// Latency returns the average response latency of the registry.
+func (h *RegistryHealth) Latency() time.Duration {
+	if h == nil {
+		return 0
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.latency
+}
+
+// Success records a successful request and closes the circuit.
+func (h *RegistryHealth) Success(latency time.Duration) {
+	if h == nil {
+		return
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.latency == 0 {
+		h.latency = latency
+	} else {
+		// exponentially weighted moving average
+		h.latency = (h.latency*7 + latency*3) / 10
+	}
+	h.failures = 0
+	h.openUntil = time.Time{}
+	h.lastCheck = time.Now()
+}
+
+// Failure records a failed request, the circuit will be opened if the failures reach the threshold.
+func (h *RegistryHealth) Failure(err error) {
+	if h == nil {
+		return
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.failures++
+	h.lastError = err.Error()
+	h.lastCheck = time.Now()
+	if h.failures >= registryCircuitThreshold {
+		h.openUntil = h.lastCheck.Add(registryCircuitCooldown)
+	}
+}
+
+// Status returns the health status of the registry.
+func (h *RegistryHealth) Status(now time.Time) RegistryStatus {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	status := "ok"
+	if h.failures >= registryCircuitThreshold {
+		if now.After(h.openUntil) {
+			status = "half-open"
+		} else {
+			status = "down"
+		}
+	} else if h.failures > 0 {
+		status = "degraded"
+	}
+	s := RegistryStatus{
+		Registry:  h.registry,
+		Status:    status,
+		Latency:   h.latency.Milliseconds(),
+		Failures:  h.failures,
+		LastError: h.lastError,
+	}
+	if !h.lastCheck.IsZero() {
+		s.LastCheck = h.lastCheck.Format(http.TimeFormat)
+	}
+	return s
+}
+
+// Registries returns the registry and its mirrors ordered by health: available registries come first,
+// then registries are ordered by the latency in 50ms buckets, and the configured order breaks the ties.
+func (reg *NpmRegistry) Registries() []string {
+	registries := make([]string, 0, 1+len(reg.Mirrors))
+	registries = append(registries, reg.Registry)
+	for _, mirror := range reg.Mirrors {
+		if mirror != "" && mirror != reg.Registry {
+			registries = append(registries, mirror)
+		}
+	}
+	if len(registries) == 1 {
+		return registries
+	}
+	type rank struct {
+		registry string
+		tier     int // 0 for available, 1 for the registries with an open circuit
+		latency  int64
+		index    int
+	}
+	now := time.Now()
+	ranks := make([]rank, len(registries))
+	for i, registry := range registries {
+		h := getRegistryHealth(registry)
+		tier := 0
+		if !h.Available(now) {
+			tier = 1
+		}
+		// only prefer the faster one if the latency difference is significant (>50ms)
+		ranks[i] = rank{registry, tier, int64(h.Latency() / (50 * time.Millisecond)), i}
+	}
+	sort.Slice(ranks, func(i, j int) bool {
+		a, b := ranks[i], ranks[j]
+		if a.tier != b.tier {
+			return a.tier < b.tier
+		}
+		if a.latency != b.latency {
+			return a.latency < b.latency
+		}
+		return a.index < b.index
+	})
+	for i, r := range ranks {
+		registries[i] = r.registry
+	}
+	return registries
+}
+
+// fetch fetches a resource from the registry, falls back to the mirrors if the registry is down.
+// The `resolve` function returns the resource url of the given registry, and whether the
+// registry health should be tracked for the request.
+func (reg *NpmRegistry) fetch(fetchClient *FetchClient, header http.Header, resolve func(registry string) (string, bool)) (res *http.Response, err error) {
+	registries := reg.Registries()
+	// retry 3 times at least
+	attempts := max(len(registries), 4)
+	for i := 0; i < attempts; i++ {
+		registry := registries[i%len(registries)]
+		if i >= len(registries) {
+			time.Sleep(time.Duration(i/len(registries)) * 100 * time.Millisecond)
+		}
+		rawUrl, tracked := resolve(registry)
+		var u *url.URL
+		u, err = url.Parse(rawUrl)
+		if err != nil {
+			return
+		}
+		var health *RegistryHealth
+		reqHeader := header
+		if tracked {
+			health = getRegistryHealth(registry)
+			if registry != reg.Registry && header.Get("Authorization") != "" {
+				// the credentials belong to the registry, don't send them to the mirrors
+				reqHeader = header.Clone()
+				reqHeader.Del("Authorization")
+			}
+		}
+		start := time.Now()
+		res, err = fetchClient.Fetch(u, reqHeader)
+		if err != nil {
+			health.Failure(err)
+			continue
+		}
+		if res.StatusCode >= 500 {
+			health.Failure(errors.New(res.Status))
+			if i < attempts-1 {
+				res.Body.Close()
+				continue
+			}
+			// return the last response to the caller
+			return
+		}
+		health.Success(time.Since(start))
+		return
+	}
+	return
+}
+
+// resolveRegistryResource returns a `resolve` function for the `NpmRegistry.fetch` method
+// that maps the resource url to the given registry.
+func (reg *NpmRegistry) resolveRegistryResource(resourceUrl string) func(registry string) (string, bool) {
+	var pathname string
+	for _, registry := range append([]string{reg.Registry}, reg.Mirrors...) {
+		if registry != "" && strings.HasPrefix(resourceUrl, registry) {
+			pathname = strings.TrimPrefix(resourceUrl, registry)
+			break
+		}
+	}
+	return func(registry string) (string, bool) {
+		if pathname == "" {
+			// the resource is not hosted by the registry
+			return resourceUrl, false
+		}
+		return registry + pathname, true
+	}
+}
+
+// startRegistryHealthCheck checks the health of tracked registries periodically.
+func startRegistryHealthCheck(logger *log.Logger) {
+	registryHealthLock.RLock()
+	n := len(registryHealthMap)
+	registryHealthLock.RUnlock()
+	if n == 0 {
+		// no mirrors
+		return
+	}
+	tick := time.NewTicker(registryHealthCheckInterval)
+	for {
+		<-tick.C
+		registryHealthLock.RLock()
+		list := make([]*RegistryHealth, 0, len(registryHealthMap))
+		for _, h := range registryHealthMap {
+			list = append(list, h)
+		}
+		registryHealthLock.RUnlock()
+		for _, h := range list {
+			pingRegistry(h, logger)
+		}
+	}
+}
+
+// pingRegistry checks the registry by requesting the `/-/ping` endpoint.
+func pingRegistry(h *RegistryHealth, logger *log.Logger) {
+	u, err := url.Parse(h.registry + "-/ping")
+	if err != nil {
+		return
+	}
+	fetchClient, recycle := NewFetchClient(10, "esmd/"+VERSION, false)
+	defer recycle()
+	start := time.Now()
+	res, err := fetchClient.Fetch(u, nil)
+	if err == nil {
+		res.Body.Close()
+		if res.StatusCode >= 500 {
+			err = errors.New(res.Status)
+		}
+	}
+	if err != nil {
+		h.Failure(err)
+		logger.Warnf("registry health check(%s): %v", h.registry, err)
+		return
+	}
+	h.Success(time.Since(start))
+}
+
+// normalizeRegistryMirrors normalizes the mirror urls of a registry
+func normalizeRegistryMirrors(mirrors []string) []string {
+	normalized := make([]string, 0, len(mirrors))
+	for _, mirror := range mirrors {
+		mirror = strings.TrimSpace(mirror)
+		if mirror == "" {
+			continue
+		}
+		if !isHttpSepcifier(mirror) {
+			fmt.Printf("[error] invalid npm registry mirror: %s\n", mirror)
+			continue
+		}
+		normalized = append(normalized, strings.TrimRight(mirror, "/")+"/")
+	}
+	return normalized
+}
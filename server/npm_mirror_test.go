@@ -0,0 +1,142 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRegistryCircuitBreaker(t *testing.T) {
+	h := &RegistryHealth{registry: "https://registry.example.com/"}
+	now := time.Now()
+	for i := 0; i < registryCircuitThreshold; i++ {
+		if !h.Available(now) {
+			t.Fatalf("circuit should be closed after %d failures", i)
+		}
+		h.Failure(errors.New("timeout"))
+	}
+	if h.Available(time.Now()) {
+		t.Fatal("circuit should be open")
+	}
+	if s := h.Status(time.Now()).Status; s != "down" {
+		t.Fatalf("expected status 'down', got '%s'", s)
+	}
+	if !h.Available(time.Now().Add(registryCircuitCooldown + time.Second)) {
+		t.Fatal("circuit should be half-open after the cooldown")
+	}
+	h.Success(10 * time.Millisecond)
+	if !h.Available(time.Now()) {
+		t.Fatal("circuit should be closed after a success")
+	}
+	if s := h.Status(time.Now()).Status; s != "ok" {
+		t.Fatalf("expected status 'ok', got '%s'", s)
+	}
+}
+
+func TestRegistryFailover(t *testing.T) {
+	var primaryHits int
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primaryHits++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer mirror.Close()
+
+	reg := &NpmRegistry{
+		Registry: primary.URL + "/",
+		Mirrors:  []string{mirror.URL + "/"},
+	}
+	trackRegistryHealth(reg.Registries()...)
+
+	fetchClient, recycle := NewFetchClient(5, "esmd/test", false)
+	defer recycle()
+
+	for i := 0; i < registryCircuitThreshold+2; i++ {
+		res, err := reg.fetch(fetchClient, nil, func(registry string) (string, bool) {
+			return registry + "react", true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != 200 || string(data) != "/react" {
+			t.Fatalf("unexpected response: %s %s", res.Status, data)
+		}
+	}
+	if primaryHits != registryCircuitThreshold {
+		t.Fatalf("expected %d requests to the primary registry, got %d", registryCircuitThreshold, primaryHits)
+	}
+	if registries := reg.Registries(); registries[0] != mirror.URL+"/" {
+		t.Fatalf("expected the mirror to be preferred, got %v", registries)
+	}
+	if s := getRegistryHealth(primary.URL + "/").Status(time.Now()).Status; s != "down" {
+		t.Fatalf("expected status 'down', got '%s'", s)
+	}
+}
+
+func TestResolveRegistryResource(t *testing.T) {
+	reg := &NpmRegistry{
+		Registry: "https://registry.npmjs.org/",
+		Mirrors:  []string{"https://registry.npmmirror.com/"},
+	}
+	resolve := reg.resolveRegistryResource("https://registry.npmjs.org/react/-/react-19.0.0.tgz")
+	if u, tracked := resolve("https://registry.npmmirror.com/"); u != "https://registry.npmmirror.com/react/-/react-19.0.0.tgz" || !tracked {
+		t.Fatalf("unexpected tarball url: %s", u)
+	}
+	resolve = reg.resolveRegistryResource("https://pkg.pr.new/tinybench@a832a55")
+	if u, tracked := resolve("https://registry.npmmirror.com/"); u != "https://pkg.pr.new/tinybench@a832a55" || tracked {
+		t.Fatalf("unexpected tarball url: %s", u)
+	}
+}
+
+func TestRegistryFetchCredentials(t *testing.T) {
+	var mirrorAuth []string
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer primary.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrorAuth = append(mirrorAuth, r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer mirror.Close()
+
+	reg := &NpmRegistry{
+		Registry: primary.URL + "/",
+		Mirrors:  []string{mirror.URL + "/"},
+	}
+	trackRegistryHealth(reg.Registries()...)
+
+	fetchClient, recycle := NewFetchClient(5, "esmd/test", false)
+	defer recycle()
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer secret")
+	res, err := reg.fetch(fetchClient, header, func(registry string) (string, bool) {
+		return registry + "react", true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadGateway {
+		t.Fatalf("expected the last response to be returned, got %s", res.Status)
+	}
+	for _, auth := range mirrorAuth {
+		if auth != "" {
+			t.Fatal("the credentials should not be sent to the mirror")
+		}
+	}
+	for _, registry := range reg.Registries() {
+		if s := getRegistryHealth(registry).Status(time.Now()); s.Failures != 2 {
+			t.Fatalf("expected 2 failures of %s, got %d", registry, s.Failures)
+		}
+	}
+}
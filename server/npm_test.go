@@ -0,0 +1,98 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha512"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+)
+
+func TestResolveInstalledPackage(t *testing.T) {
+	workDir := config.WorkDir
+	config.WorkDir = t.TempDir()
+	defer func() { config.WorkDir = workDir }()
+
+	npmrc := &NpmRC{}
+	for _, pkg := range []string{"react@18.2.0", "react@18.3.1", "react@19.0.0-rc.1", "react-dom@19.0.0", "@esm.sh/foo@1.0.0"} {
+		name, version, _, _ := splitEsmPath("/" + pkg)
+		dir := path.Join(npmrc.StoreDir(), pkg, "node_modules", name)
+		os.MkdirAll(dir, 0755)
+		os.WriteFile(path.Join(dir, "package.json"), []byte(`{"name":"`+name+`","version":"`+version+`"}`), 0644)
+	}
+
+	tests := map[string]string{
+		"react@latest":       "18.3.1",
+		"react@18":           "18.3.1",
+		"react@~18.2.0":      "18.2.0",
+		"react@19.0.0-rc.1":  "19.0.0-rc.1",
+		"@esm.sh/foo@^1.0.0": "1.0.0",
+	}
+	for spec, want := range tests {
+		name, version, _, _ := splitEsmPath("/" + spec)
+		p, err := npmrc.resolveInstalledPackage(name, version)
+		if err != nil {
+			t.Fatalf("%s: %v", spec, err)
+		}
+		if p.Version != want {
+			t.Fatalf("%s: expected version %s, got %s", spec, want, p.Version)
+		}
+	}
+
+	for _, spec := range []string{"react@17", "react@next", "vue@latest"} {
+		name, version, _, _ := splitEsmPath("/" + spec)
+		if _, err := npmrc.resolveInstalledPackage(name, version); err == nil {
+			t.Fatalf("%s: expected an error", spec)
+		}
+	}
+}
+
+func TestFetchPackageTarballIntegrity(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	pkgJson := []byte(`{"name":"foo","version":"1.0.0"}`)
+	tw.WriteHeader(&tar.Header{Name: "package/package.json", Mode: 0644, Size: int64(len(pkgJson))})
+	tw.Write(pkgJson)
+	tw.Close()
+	gw.Close()
+	tarball := buf.Bytes()
+	sum := sha512.Sum512(tarball)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(tarball)
+	}))
+	defer server.Close()
+
+	digest := base64.StdEncoding.EncodeToString(sum[:])
+	badDigest := base64.StdEncoding.EncodeToString(make([]byte, sha512.Size))
+	dir := t.TempDir()
+	for i, test := range []struct {
+		integrity string
+		ok        bool
+	}{
+		{"sha512-" + digest, true},
+		{"sha1-AAAA sha512-" + digest, true},
+		{"sha512-" + badDigest, false},
+		{"sha512-" + badDigest + " sha1-AAAA", false},
+	} {
+		integrity, ok := test.integrity, test.ok
+		installDir := path.Join(dir, strconv.Itoa(i))
+		err := fetchPackageTarball(&NpmRegistry{}, installDir, "foo", NpmPackageDist{Tarball: server.URL + "/foo-1.0.0.tgz", Integrity: integrity})
+		if ok && err != nil {
+			t.Fatalf("%s: %v", integrity, err)
+		}
+		if !ok && err == nil {
+			t.Fatalf("%s: expected an integrity error", integrity)
+		}
+		if existsFile(path.Join(installDir, "node_modules", "foo", "package.json")) != ok {
+			t.Fatalf("%s: unexpected install result", integrity)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ije/gox/utils"
+)
+
+var npmrcEnvRegexp = regexp.MustCompile(`\$\{([^${}?]+)(\?)?\}`)
+
+// npmrcAuth is the credential of a registry in the `.npmrc` file, keyed by the "nerf dart" of the
+// registry url (e.g. `//registry.npmjs.org/`).
+type npmrcAuth struct {
+	Token    string
+	User     string
+	Password string
+}
+
+// NewNpmRcFromIni creates a NpmRC from the `.npmrc` ini format, supported keys are:
+//
+//	registry=https://registry.npmjs.org/
+//	@scope:registry=https://npm.pkg.github.com/
+//	//npm.pkg.github.com/:_authToken=TOKEN
+//	//registry.example.com/:_auth=BASE64(user:password)
+//	//registry.example.com/:username=USER
+//	//registry.example.com/:_password=BASE64(password)
+//
+// The `${ENV}` references are expanded only if `expandEnv` is true, it should never be enabled for
+// the `X-Npmrc` header.
+func NewNpmRcFromIni(data []byte, expandEnv bool) (npmrc *NpmRC, err error) {
+	rc, auths, err := parseNpmrcIni(data, expandEnv)
+	if err != nil {
+		return nil, err
+	}
+	if rc.Registry == "" {
+		if config != nil && config.NpmRegistry != "" {
+			rc.Registry = config.NpmRegistry
+		} else {
+			rc.Registry = npmRegistry
+		}
+	}
+	applyNpmrcAuths(&rc.NpmRegistry, auths)
+	for scope, reg := range rc.ScopedRegistries {
+		reg.applyAuth(findNpmrcAuth(auths, reg.Registry))
+		rc.ScopedRegistries[scope] = reg
+	}
+	if _, ok := rc.ScopedRegistries["@jsr"]; !ok {
+		rc.ScopedRegistries["@jsr"] = NpmRegistry{
+			Registry: jsrRegistry,
+		}
+	}
+	return rc, nil
+}
+
+// parseNpmrcIni parses the `.npmrc` ini format, the `Registry` of the returned NpmRC is empty if the
+// `registry` key is not set, and the credentials are returned by the nerf darts without being applied.
+func parseNpmrcIni(data []byte, expandEnv bool) (rc *NpmRC, auths map[string]*npmrcAuth, err error) {
+	rc = &NpmRC{ScopedRegistries: map[string]NpmRegistry{}}
+	auths = map[string]*npmrcAuth{}
+	getAuth := func(nerfDart string) *npmrcAuth {
+		auth, ok := auths[nerfDart]
+		if !ok {
+			auth = &npmrcAuth{}
+			auths[nerfDart] = auth
+		}
+		return auth
+	}
+
+	n := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		key, value := utils.SplitByFirstByte(line, '=')
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		if expandEnv {
+			key = expandNpmrcEnv(key)
+			value = expandNpmrcEnv(value)
+		}
+
+		var nerfDart string
+		if strings.HasPrefix(key, "//") {
+			nerfDart, key = utils.SplitByLastByte(key, ':')
+			if !strings.HasSuffix(nerfDart, "/") {
+				nerfDart += "/"
+			}
+		}
+
+		switch {
+		case key == "registry" && nerfDart == "":
+			if !isHttpSepcifier(value) {
+				return nil, nil, fmt.Errorf("invalid registry: %s", value)
+			}
+			rc.Registry = strings.TrimRight(value, "/") + "/"
+		case strings.HasPrefix(key, "@") && strings.HasSuffix(key, ":registry") && nerfDart == "":
+			if !isHttpSepcifier(value) {
+				return nil, nil, fmt.Errorf("invalid registry: %s", value)
+			}
+			scope := strings.TrimSuffix(key, ":registry")
+			rc.ScopedRegistries[scope] = NpmRegistry{Registry: strings.TrimRight(value, "/") + "/"}
+		case key == "_authToken":
+			getAuth(nerfDart).Token = value
+		case key == "_auth":
+			decoded, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return nil, nil, errors.New("invalid _auth")
+			}
+			auth := getAuth(nerfDart)
+			auth.User, auth.Password = utils.SplitByFirstByte(string(decoded), ':')
+		case key == "username":
+			getAuth(nerfDart).User = value
+		case key == "_password":
+			decoded, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return nil, nil, errors.New("invalid _password")
+			}
+			getAuth(nerfDart).Password = string(decoded)
+		default:
+			// ignore unsupported keys
+			continue
+		}
+		n++
+	}
+	if n == 0 {
+		return nil, nil, errors.New("no registry config found")
+	}
+	return
+}
+
+// applyNpmrcAuths sets the credential of the default registry, the global credential (without the nerf dart)
+// applies to the default registry as well.
+func applyNpmrcAuths(reg *NpmRegistry, auths map[string]*npmrcAuth) {
+	if auth, ok := auths[""]; ok {
+		reg.applyAuth(auth)
+	}
+	reg.applyAuth(findNpmrcAuth(auths, reg.Registry))
+}
+
+// NewNpmRcFromHeader creates a NpmRC from the `X-Npmrc` header, which can be either the JSON format,
+// or the `.npmrc` ini format (base64 encoded, or with lines separated by `\n`).
+func NewNpmRcFromHeader(value string) (npmrc *NpmRC, err error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "{") {
+		return NewNpmRcFromJSON([]byte(value))
+	}
+	if data, err := base64.StdEncoding.DecodeString(value); err == nil && utf8.Valid(data) && bytes.ContainsRune(data, '=') {
+		return NewNpmRcFromIni(data, false)
+	}
+	return NewNpmRcFromIni([]byte(strings.ReplaceAll(value, `\n`, "\n")), false)
+}
+
+// applyAuth sets the credential of the registry if it's not set
+func (reg *NpmRegistry) applyAuth(auth *npmrcAuth) {
+	if auth == nil || reg.Token != "" || reg.User != "" {
+		return
+	}
+	reg.Token = auth.Token
+	reg.User = auth.User
+	reg.Password = auth.Password
+}
+
+// findNpmrcAuth finds the credential of the registry, the most specific nerf dart wins like npm does.
+// e.g. `//npm.pkg.github.com/foo/` is preferred over `//npm.pkg.github.com/` for `https://npm.pkg.github.com/foo/`
+func findNpmrcAuth(auths map[string]*npmrcAuth, registry string) (auth *npmrcAuth) {
+	_, nerfDart := utils.SplitByFirstByte(registry, ':')
+	matched := ""
+	for key, a := range auths {
+		if key != "" && strings.HasPrefix(nerfDart, key) && len(key) > len(matched) {
+			matched = key
+			auth = a
+		}
+	}
+	return
+}
+
+// expandNpmrcEnv expands the `${ENV}` references in the `.npmrc` file,
+// `${ENV?}` is expanded to an empty string if the env is not set.
+func expandNpmrcEnv(s string) string {
+	return npmrcEnvRegexp.ReplaceAllStringFunc(s, func(m string) string {
+		match := npmrcEnvRegexp.FindStringSubmatch(m)
+		if v, ok := os.LookupEnv(match[1]); ok {
+			return v
+		}
+		if match[2] == "?" {
+			return ""
+		}
+		return m
+	})
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"encoding/base64"
+	"os"
+	"testing"
+)
+
+func TestNewNpmRcFromIni(t *testing.T) {
+	os.Setenv("ESM_TEST_NPM_TOKEN", "secret")
+	defer os.Unsetenv("ESM_TEST_NPM_TOKEN")
+
+	data := []byte(`
+# comment
+registry=https://registry.example.com
+@github:registry=https://npm.pkg.github.com/
+@private:registry = "https://npm.example.com/private/"
+//registry.example.com/:_auth=` + base64.StdEncoding.EncodeToString([]byte("user:pass")) + `
+//npm.pkg.github.com/:_authToken=${ESM_TEST_NPM_TOKEN}
+//npm.example.com/:_authToken=host-token
+//npm.example.com/private/:username=bob
+//npm.example.com/private/:_password=` + base64.StdEncoding.EncodeToString([]byte("p@ss")) + `
+always-auth=true
+`)
+	rc, err := NewNpmRcFromIni(data, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc.Registry != "https://registry.example.com/" || rc.User != "user" || rc.Password != "pass" {
+		t.Fatalf("unexpected default registry: %+v", rc.NpmRegistry)
+	}
+	if reg := rc.ScopedRegistries["@github"]; reg.Registry != "https://npm.pkg.github.com/" || reg.Token != "secret" {
+		t.Fatalf("unexpected @github registry: %+v", reg)
+	}
+	if reg := rc.ScopedRegistries["@private"]; reg.Registry != "https://npm.example.com/private/" || reg.User != "bob" || reg.Password != "p@ss" || reg.Token != "" {
+		t.Fatalf("unexpected @private registry: %+v", reg)
+	}
+	if _, ok := rc.ScopedRegistries["@jsr"]; !ok {
+		t.Fatal("missing @jsr registry")
+	}
+
+	// env references are not expanded for the `X-Npmrc` header
+	rc, err = NewNpmRcFromHeader(`@github:registry=https://npm.pkg.github.com/\n//npm.pkg.github.com/:_authToken=${ESM_TEST_NPM_TOKEN}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reg := rc.ScopedRegistries["@github"]; reg.Token != "${ESM_TEST_NPM_TOKEN}" {
+		t.Fatalf("unexpected @github registry: %+v", reg)
+	}
+
+	// base64 encoded `.npmrc` in the `X-Npmrc` header
+	rc, err = NewNpmRcFromHeader(base64.StdEncoding.EncodeToString([]byte("registry=https://registry.example.com/\n_authToken=token\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc.Registry != "https://registry.example.com/" || rc.Token != "token" {
+		t.Fatalf("unexpected default registry: %+v", rc.NpmRegistry)
+	}
+
+	// json format in the `X-Npmrc` header
+	rc, err = NewNpmRcFromHeader(`{"registry":"https://registry.example.com/","token":"token"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc.Registry != "https://registry.example.com/" || rc.Token != "token" {
+		t.Fatalf("unexpected default registry: %+v", rc.NpmRegistry)
+	}
+
+	if _, err = NewNpmRcFromHeader("invalid"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestLoadNpmrcFile(t *testing.T) {
+	filename := t.TempDir() + "/.npmrc"
+	os.WriteFile(filename, []byte("//npm.example.com/:_authToken=file-token\n@github:registry=https://npm.pkg.github.com/\n"), 0644)
+
+	// the env takes precedence over the `.npmrc` file
+	t.Setenv("NPM_REGISTRY", "https://npm.example.com")
+	t.Setenv("NPM_TOKEN", "env-token")
+	c := &Config{Npmrc: filename}
+	normalizeConfig(c)
+	if c.NpmRegistry != "https://npm.example.com/" || c.NpmToken != "env-token" {
+		t.Fatalf("unexpected registry: %s %s", c.NpmRegistry, c.NpmToken)
+	}
+	if reg := c.NpmScopedRegistries["@github"]; reg.Registry != "https://npm.pkg.github.com/" {
+		t.Fatalf("unexpected @github registry: %+v", reg)
+	}
+
+	// the credential of the `.npmrc` file applies to the registry from the env
+	t.Setenv("NPM_TOKEN", "")
+	c = &Config{Npmrc: filename}
+	normalizeConfig(c)
+	if c.NpmRegistry != "https://npm.example.com/" || c.NpmToken != "file-token" {
+		t.Fatalf("unexpected registry: %s %s", c.NpmRegistry, c.NpmToken)
+	}
+
+	// the default registry is used if neither the env nor the file sets the registry
+	t.Setenv("NPM_REGISTRY", "")
+	c = &Config{Npmrc: filename}
+	normalizeConfig(c)
+	if c.NpmRegistry != npmRegistry || c.NpmToken != "" {
+		t.Fatalf("unexpected registry: %s %s", c.NpmRegistry, c.NpmToken)
+	}
+}
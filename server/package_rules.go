@@ -0,0 +1,72 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/esm-dev/esm.sh/server/common"
+)
+
+// hasPackageRules checks if the allow list or the ban list is configured.
+func hasPackageRules() bool {
+	return len(config.AllowList.Packages) > 0 || len(config.AllowList.Scopes) > 0 || len(config.BanList.Packages) > 0 || len(config.BanList.Scopes) > 0 || config.BanList.GetMinPublishAge() > 0
+}
+
+// checkPackageRules checks the resolved package with the allow list and the ban list, the reason is returned
+// if the package is forbidden. The `minPublishAge` of the ban list only applies to the npm packages.
+func checkPackageRules(npmrc *NpmRC, pkg Package) (reason string, forbidden bool) {
+	reason, forbidden = checkPackageLists(pkg)
+	if forbidden {
+		return
+	}
+	return checkPackagePublishAge(npmrc, pkg)
+}
+
+// checkPackageLists checks the package with the allow list and the ban list without accessing the registry.
+func checkPackageLists(pkg Package) (reason string, forbidden bool) {
+	fullName := pkg.Name
+	if pkg.Version != "" {
+		fullName += "@" + pkg.Version
+	}
+	if !config.AllowList.IsPackageAllowed(fullName) {
+		return fmt.Sprintf("\"%s\" is not in the allow list", fullName), true
+	}
+	if config.BanList.IsPackageBanned(fullName) {
+		return fmt.Sprintf("\"%s\" is banned", fullName), true
+	}
+	return "", false
+}
+
+// checkPackagePublishAge checks the publish time of the package with the `minPublishAge` of the ban list,
+// the package is forbidden if the publish time can't be fetched, so a registry failure doesn't bypass the rule.
+func checkPackagePublishAge(npmrc *NpmRC, pkg Package) (reason string, forbidden bool) {
+	minPublishAge := config.BanList.GetMinPublishAge()
+	if minPublishAge <= 0 || config.Offline || pkg.Github || pkg.Git || pkg.PkgPrNew || !isExactVersion(pkg.Version) {
+		return "", false
+	}
+	fullName := pkg.Name + "@" + pkg.Version
+	publishTime, err := npmrc.getPackagePublishTime(pkg.Name, pkg.Version)
+	if err != nil {
+		return fmt.Sprintf("could not check the publish time of \"%s\": %v", fullName, err), true
+	}
+	if time.Since(publishTime) < minPublishAge {
+		return fmt.Sprintf("\"%s\" was published less than %s ago", fullName, minPublishAge), true
+	}
+	return "", false
+}
+
+// checkBundledPackageRules checks the bundled dependencies of the build with the allow list and the ban list,
+// since the bundled dependencies are not requested through the router.
+func (ctx *BuildContext) checkBundledPackageRules(bundled []*common.SBOMComponent) error {
+	for _, c := range bundled {
+		if reason, forbidden := checkPackageRules(ctx.npmrc, Package{Name: c.Name, Version: c.Version}); forbidden {
+			ctx.logger.Warnf("build(%s): blocked bundled dependency, %s", ctx.Path(), reason)
+			return &BuildError{
+				Kind:    "forbidden",
+				Message: "forbidden: bundled dependency " + reason,
+				Package: ctx.esm.Specifier(),
+			}
+		}
+	}
+	return nil
+}
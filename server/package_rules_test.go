@@ -0,0 +1,67 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckPackagePublishAge(t *testing.T) {
+	banList := config.BanList
+	defer func() { config.BanList = banList }()
+	config.BanList = BanList{MinPublishAge: "24h"}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old-pkg":
+			fmt.Fprintf(w, `{"time":{"1.0.0":"%s"}}`, time.Now().Add(-48*time.Hour).Format(time.RFC3339))
+		case "/new-pkg":
+			fmt.Fprintf(w, `{"time":{"1.0.0":"%s"}}`, time.Now().Format(time.RFC3339))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	npmrc := &NpmRC{NpmRegistry: NpmRegistry{Registry: server.URL + "/"}}
+	if reason, forbidden := checkPackageRules(npmrc, Package{Name: "old-pkg", Version: "1.0.0"}); forbidden {
+		t.Fatalf("old-pkg@1.0.0 should be allowed, got %s", reason)
+	}
+	if reason, forbidden := checkPackageRules(npmrc, Package{Name: "new-pkg", Version: "1.0.0"}); !forbidden || !strings.Contains(reason, "was published less than") {
+		t.Fatalf("new-pkg@1.0.0 should be forbidden, got %q", reason)
+	}
+	// the registry failure must not bypass the rule
+	if reason, forbidden := checkPackageRules(npmrc, Package{Name: "broken-pkg", Version: "1.0.0"}); !forbidden || !strings.Contains(reason, "could not check the publish time") {
+		t.Fatalf("broken-pkg@1.0.0 should be forbidden, got %q", reason)
+	}
+	if !hasPackageRules() {
+		t.Fatal("the min publish age should be a package rule")
+	}
+	config.BanList = BanList{}
+	if hasPackageRules() {
+		t.Fatal("empty lists should have no package rules")
+	}
+}
+
+func TestBundledAliasTargets(t *testing.T) {
+	ctx := newBundleTestContext(t, map[string]string{
+		"app":    `{"name":"app","version":"1.0.0"}`,
+		"lodash": `{"name":"lodash","version":"4.17.20","license":"MIT"}`,
+	})
+	banList := config.BanList
+	defer func() { config.BanList = banList }()
+	config.BanList = BanList{Packages: []string{"lodash@<4.17.21"}}
+
+	ctx.args = BuildArgs{alias: map[string]string{"underscore": "lodash"}}
+	components := ctx.getBundledComponents()
+	if len(components) != 1 || components[0].Name != "lodash" || components[0].Version != "4.17.20" {
+		t.Fatalf("unexpected components %v", components)
+	}
+	err := ctx.checkBundledPackageRules(components)
+	if err == nil || err.(*BuildError).Kind != "forbidden" {
+		t.Fatalf("the aliased lodash@4.17.20 should be forbidden, got %v", err)
+	}
+}
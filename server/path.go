@@ -13,6 +13,7 @@ import (
 
 type EsmPath struct {
 	GhPrefix      bool
+	GitPrefix     bool
 	PrPrefix      bool
 	PkgName       string
 	PkgVersion    string
@@ -23,6 +24,7 @@ type EsmPath struct {
 func (p EsmPath) Package() Package {
 	return Package{
 		Github:   p.GhPrefix,
+		Git:      p.GitPrefix,
 		PkgPrNew: p.PrPrefix,
 		Name:     p.PkgName,
 		Version:  p.PkgVersion,
@@ -37,6 +39,9 @@ func (p EsmPath) Name() string {
 	if p.GhPrefix {
 		return "gh/" + name
 	}
+	if p.GitPrefix {
+		return "git/" + name
+	}
 	if p.PrPrefix {
 		return "pr/" + name
 	}
@@ -80,6 +85,31 @@ func praseEsmPath(npmrc *NpmRC, pathname string) (esm EsmPath, extraQuery string
 		return
 	}
 
+	// see `GitHost`
+	if strings.HasPrefix(pathname, "/git/") {
+		pkgName, rest := utils.SplitByFirstByte(pathname[5:], '@')
+		var gitHost *GitHost
+		var repo string
+		gitHost, repo, _, err = validateGitPackageName(pkgName)
+		if err != nil {
+			return
+		}
+		version, subPath := utils.SplitByFirstByte(rest, '/')
+		version, extraQuery = utils.SplitByFirstByte(version, '&')
+		if v, e := url.PathUnescape(version); e == nil {
+			version = v
+		}
+		hasTargetSegment = validateTargetSegment(strings.Split(subPath, "/"))
+		esm = EsmPath{
+			PkgName:       pkgName,
+			SubPath:       subPath,
+			SubModuleName: stripEntryModuleExt(subPath),
+			GitPrefix:     true,
+		}
+		esm.PkgVersion, exactVersion, err = resolveGitVersion(gitHost, repo, version)
+		return
+	}
+
 	var ghPrefix bool
 	if strings.HasPrefix(pathname, "/gh/") {
 		if !strings.ContainsRune(pathname[4:], '/') {
@@ -246,11 +276,9 @@ func validateTargetSegment(segments []string) bool {
 		return false
 	}
 	if strings.HasPrefix(segments[0], "X-") && len(segments) > 2 {
-		_, ok := targets[segments[1]]
-		return ok
+		return isBuildTarget(segments[1])
 	}
-	_, ok := targets[segments[0]]
-	return ok
+	return isBuildTarget(segments[0])
 }
 
 func toPackageName(specifier string) string {
@@ -39,6 +39,8 @@ const (
 	EsmBuild
 	// source map
 	EsmSourceMap
+	// provenance manifest
+	EsmManifest
 	// *.d.ts
 	EsmDts
 	// package raw file
@@ -103,10 +105,42 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 				h.Write([]byte(options.JsxImportSource))
 				h.Write([]byte(options.SourceMap))
 				h.Write([]byte(fmt.Sprintf("%v", options.Minify)))
+				if strings.HasSuffix(options.Filename, ".module.css") {
+					// the class names of css modules are generated from the filename
+					h.Write([]byte(options.Filename))
+				}
 				hash := hex.EncodeToString(h.Sum(nil))
 
-				// if previous build exists, return it directly
+				importMap := common.ImportMap{Imports: map[string]string{}}
+				if len(options.ImportMap) > 0 {
+					err = json.Unmarshal(options.ImportMap, &importMap)
+					if err != nil {
+						return rex.Err(400, "Invalid ImportMap")
+					}
+				}
+
+				// emit the declarations of typescript modules with the `dts` option, the imports are resolved with the import map
 				savePath := normalizeSavePath(ctx.R.Header.Get("X-Zone-Id"), fmt.Sprintf("modules/transform/%s.mjs", hash))
+				dtsSavePath := strings.TrimSuffix(savePath, ".mjs") + ".d.ts"
+				emitTransformDTS := func() string {
+					filename := options.Filename
+					if filename == "" {
+						filename = "source." + options.Lang
+					}
+					dts, err := emitDTS(DefaultNpmRC(), filename, options.Code, func(specifier string) string {
+						path, _ := importMap.Resolve(specifier)
+						return path
+					})
+					if err != nil {
+						logger.Warnf("failed to emit declarations of %s: %v", filename, err)
+						return ""
+					}
+					go buildStorage.Put(dtsSavePath, bytes.NewReader(dts))
+					return string(dts)
+				}
+				emitDts := options.Dts && (options.Lang == "ts" || options.Lang == "tsx")
+
+				// if previous build exists, return it directly
 				if file, _, err := buildStorage.Get(savePath); err == nil {
 					data, err := io.ReadAll(file)
 					file.Close()
@@ -124,15 +158,19 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 							output.Map = string(data)
 						}
 					}
-					return output
-				}
-
-				importMap := common.ImportMap{Imports: map[string]string{}}
-				if len(options.ImportMap) > 0 {
-					err = json.Unmarshal(options.ImportMap, &importMap)
-					if err != nil {
-						return rex.Err(400, "Invalid ImportMap")
+					if emitDts {
+						file, _, err = buildStorage.Get(dtsSavePath)
+						if err == nil {
+							data, err = io.ReadAll(file)
+							file.Close()
+							if err == nil {
+								output.Dts = string(data)
+							}
+						} else if err == storage.ErrNotFound {
+							output.Dts = emitTransformDTS()
+						}
 					}
+					return output
 				}
 
 				output, err := transform(&ResolvedTransformOptions{
@@ -146,6 +184,9 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 					output.Code = fmt.Sprintf("%s//# sourceMappingURL=+%s", output.Code, path.Base(savePath)+".map")
 					go buildStorage.Put(savePath+".map", strings.NewReader(output.Map))
 				}
+				if emitDts {
+					output.Dts = emitTransformDTS()
+				}
 				go buildStorage.Put(savePath, strings.NewReader(output.Code))
 				ctx.SetHeader("Cache-Control", ccMustRevalidate)
 				return output
@@ -318,64 +359,45 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 			ctx.SetHeader("Cache-Control", ccMustRevalidate)
 			return map[string]any{
 				"buildQueue": q[:i],
+				"registries": getRegistryStatus(),
 				"version":    VERSION,
 				"uptime":     time.Since(startTime).String(),
 				"disk":       disk,
 			}
 
 		case "/error.js":
-			switch query := ctx.Query(); query.Get("type") {
+			query := ctx.Query()
+			kind := query.Get("type")
+			var format string
+			switch kind {
 			case "resolve":
-				return errorJS(ctx, fmt.Sprintf(
-					`Could not resolve "%s" (Imported by "%s")`,
-					query.Get("name"),
-					query.Get("importer"),
-				))
+				format = `Could not resolve "%s" (Imported by "%s")`
 			case "unsupported-node-builtin-module":
-				return errorJS(ctx, fmt.Sprintf(
-					`Unsupported Node builtin module "%s" (Imported by "%s")`,
-					query.Get("name"),
-					query.Get("importer"),
-				))
+				format = `Unsupported Node builtin module "%s" (Imported by "%s")`
 			case "unsupported-node-native-module":
-				return errorJS(ctx, fmt.Sprintf(
-					`Unsupported node native module "%s" (Imported by "%s")`,
-					query.Get("name"),
-					query.Get("importer"),
-				))
+				format = `Unsupported node native module "%s" (Imported by "%s")`
 			case "unsupported-npm-package":
-				return errorJS(ctx, fmt.Sprintf(
-					`Unsupported NPM package "%s" (Imported by "%s")`,
-					query.Get("name"),
-					query.Get("importer"),
-				))
+				format = `Unsupported NPM package "%s" (Imported by "%s")`
 			case "unsupported-file-dependency":
-				return errorJS(ctx, fmt.Sprintf(
-					`Unsupported file dependency "%s" (Imported by "%s")`,
-					query.Get("name"),
-					query.Get("importer"),
-				))
+				format = `Unsupported file dependency "%s" (Imported by "%s")`
 			case "unsupported-git-dependency":
-				return errorJS(ctx, fmt.Sprintf(
-					`Unsupported git dependency "%s" (Imported by "%s")`,
-					query.Get("name"),
-					query.Get("importer"),
-				))
+				format = `Unsupported git dependency "%s" (Imported by "%s")`
 			case "invalid-jsr-dependency":
-				return errorJS(ctx, fmt.Sprintf(
-					`Invalid jsr dependency "%s" (Imported by "%s")`,
-					query.Get("name"),
-					query.Get("importer"),
-				))
+				format = `Invalid jsr dependency "%s" (Imported by "%s")`
 			case "invalid-http-dependency":
-				return errorJS(ctx, fmt.Sprintf(
-					`Invalid http dependency "%s" (Imported by "%s")`,
-					query.Get("name"),
-					query.Get("importer"),
-				))
+				format = `Invalid http dependency "%s" (Imported by "%s")`
 			default:
 				return rex.Status(500, "Unknown error")
 			}
+			name := query.Get("name")
+			importer := query.Get("importer")
+			return errorJS(ctx, &BuildError{
+				Kind:        kind,
+				Message:     fmt.Sprintf(format, name, importer),
+				Package:     name,
+				Importer:    importer,
+				Suggestions: getErrorSuggestions(kind, name),
+			})
 
 		// builtin scripts
 		case "/x", "/tsx", "/run", "/xs", "/xb":
@@ -438,8 +460,15 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 			}
 			if strings.HasSuffix(pathname, ".map") {
 				ctx.SetHeader("Content-Type", ctJSON)
+			} else if strings.HasSuffix(pathname, ".d.ts") {
+				ctx.SetHeader("Content-Type", ctTypeScript)
 			} else {
 				ctx.SetHeader("Content-Type", ctJavaScript)
+				dtsSavePath := normalizeSavePath(ctx.R.Header.Get("X-Zone-Id"), fmt.Sprintf("modules/transform/%s.d.ts", hash))
+				if _, err := buildStorage.Stat(dtsSavePath); err == nil {
+					ctx.SetHeader("X-TypeScript-Types", fmt.Sprintf("%s/+%s.d.ts", getOrigin(ctx), hash))
+					ctx.SetHeader("Access-Control-Expose-Headers", "X-TypeScript-Types")
+				}
 			}
 			ctx.SetHeader("Last-Modified", fi.ModTime().UTC().Format(http.TimeFormat))
 			ctx.SetHeader("Cache-Control", ccImmutable)
@@ -498,7 +527,7 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 
 		var npmrc *NpmRC
 		if v := ctx.R.Header.Get("X-Npmrc"); v != "" {
-			rc, err := NewNpmRcFromJSON([]byte(v))
+			rc, err := NewNpmRcFromHeader(v)
 			if err != nil {
 				return rex.Status(400, "Invalid Npmrc Header")
 			}
@@ -531,6 +560,9 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 		}
 
 		if strings.HasPrefix(pathname, "/http://") || strings.HasPrefix(pathname, "/https://") {
+			if config.Offline {
+				return rex.Status(403, "HTTP modules are not available in offline mode")
+			}
 			query := ctx.Query()
 			modUrl, err := url.Parse(pathname[1:])
 			if err != nil {
@@ -713,18 +745,36 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 				denoJson := query.Get("dj")
 				refreshParam := query.Get("refresh")
 				forceRefresh := refreshParam != ""
-				
+
+				// the declarations of typescript modules are emitted with the `?dts` query
+				isTsModule := endsWith(modUrl.Path, ".ts", ".mts", ".tsx")
+				isDts := isTsModule && query.Has("dts")
+				dtsQuery := "dts"
+				for _, key := range []string{"dj", "im", "v"} {
+					if value := query.Get(key); value != "" {
+						dtsQuery += "&" + key + "=" + url.QueryEscape(value)
+					}
+				}
+
 				h := sha1.New()
 				h.Write([]byte(modUrlRaw))
 				h.Write([]byte(im))
 				h.Write([]byte(denoJson))
-				h.Write([]byte(target))
+				if isDts {
+					h.Write([]byte("dts"))
+				} else {
+					h.Write([]byte(target))
+				}
 				h.Write([]byte(v))
 				if forceRefresh {
 					// 添加时间戳到哈希值，确保每次刷新生成不同的缓存键
 					h.Write([]byte(refreshParam))
 				}
-				savePath := normalizeSavePath(zoneIdHeader, path.Join("modules/x", hex.EncodeToString(h.Sum(nil))+".mjs"))
+				saveExt := ".mjs"
+				if isDts {
+					saveExt = ".d.ts"
+				}
+				savePath := normalizeSavePath(zoneIdHeader, path.Join("modules/x", hex.EncodeToString(h.Sum(nil))+saveExt))
 				content, _, err := buildStorage.Get(savePath)
 				if (err != nil && err != storage.ErrNotFound) || (forceRefresh && err != storage.ErrNotFound) {
 					// 如果是强制刷新，且文件存在，则忽略已缓存的内容
@@ -822,6 +872,34 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 							}
 						}
 					}
+					if isDts {
+						res, err := fetchClient.Fetch(modUrl, nil)
+						if err != nil {
+							return rex.Status(500, "Failed to fetch module")
+						}
+						defer res.Body.Close()
+						if res.StatusCode != 200 {
+							if res.StatusCode == 404 {
+								return rex.Status(404, "Module not found")
+							}
+							return rex.Status(500, "Failed to fetch module: "+res.Status)
+						}
+						code, err := io.ReadAll(io.LimitReader(res.Body, 5*MB))
+						if err != nil {
+							return rex.Status(500, "Failed to fetch module")
+						}
+						cdnOrigin := getOrigin(ctx)
+						dts, err := emitDTS(npmrc, modUrlRaw, string(code), func(specifier string) string {
+							return resolveHttpDtsImport(specifier, modUrl, importMap, cdnOrigin, dtsQuery)
+						})
+						if err != nil {
+							return rex.Status(500, "Failed to emit declarations: "+err.Error())
+						}
+						go buildStorage.Put(savePath, bytes.NewReader(dts))
+						ctx.SetHeader("Cache-Control", ccImmutable)
+						ctx.SetHeader("Content-Type", ctTypeScript)
+						return dts
+					}
 					if extname == ".md" {
 						for _, kind := range []string{"jsx", "svelte", "vue"} {
 							if query.Has(kind) {
@@ -835,10 +913,7 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 					if err != nil {
 						return rex.Status(500, "Failed to build module: "+err.Error())
 					}
-					code := string(js)
-					if len(css) > 0 {
-						code += fmt.Sprintf(`globalThis.document.head.insertAdjacentHTML("beforeend","<style>"+%s+"</style>")`, utils.MustEncodeJSON(string(css)))
-					}
+					code := string(js) + injectCSS(css)
 					lang := "js"
 					if jsx {
 						lang = "jsx"
@@ -860,7 +935,9 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 					body = bytes.NewReader([]byte(out.Code))
 					go buildStorage.Put(savePath, strings.NewReader(out.Code))
 				}
-				if extname == ".css" && query.Has("module") {
+				// css modules are always js modules that export the class names
+				isCSSModule := strings.HasSuffix(modUrl.Path, ".module.css")
+				if extname == ".css" && query.Has("module") && !isCSSModule {
 					css, err := io.ReadAll(body)
 					if closer, ok := body.(io.Closer); ok {
 						closer.Close()
@@ -871,10 +948,16 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 					body = strings.NewReader(fmt.Sprintf("var style = document.createElement('style');\nstyle.textContent = %s;\ndocument.head.appendChild(style);\nexport default null;", utils.MustEncodeJSON(string(css))))
 				}
 				ctx.SetHeader("Cache-Control", ccImmutable)
-				if extname == ".css" {
+				if isDts {
+					ctx.SetHeader("Content-Type", ctTypeScript)
+				} else if extname == ".css" && !isCSSModule {
 					ctx.SetHeader("Content-Type", ctCSS)
 				} else {
 					ctx.SetHeader("Content-Type", ctJavaScript)
+					if isTsModule {
+						ctx.SetHeader("X-TypeScript-Types", getOrigin(ctx)+"/"+modUrlRaw+"?"+dtsQuery)
+						ctx.SetHeader("Access-Control-Expose-Headers", "X-TypeScript-Types")
+					}
 				}
 				return body // auto closed
 			}
@@ -891,6 +974,9 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 		} else if strings.HasPrefix(pathname, "/github.com/*") {
 			asteriskPrefix = true
 			pathname = "/gh/" + pathname[13:]
+		} else if strings.HasPrefix(pathname, "/git/*") {
+			asteriskPrefix = true
+			pathname = "/git/" + pathname[6:]
 		} else if strings.HasPrefix(pathname, "/pr/*") {
 			asteriskPrefix = true
 			pathname = "/pr/" + pathname[5:]
@@ -908,13 +994,40 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 			} else if strings.HasSuffix(message, " not found") {
 				status = 404
 			}
-			return rex.Status(status, message)
+			return errorResponse(ctx, status, err, "")
 		}
 
-		pkgAllowed := config.AllowList.IsPackageAllowed(esm.PkgName)
-		pkgBanned := config.BanList.IsPackageBanned(esm.PkgName)
-		if !pkgAllowed || pkgBanned {
-			return rex.Status(403, "forbidden")
+		// check the allow list and the ban list with the resolved version, the publish time is only checked when
+		// resolving the package, not for the build files that are served from the storage
+		if hasPackageRules() {
+			reason, forbidden := checkPackageLists(esm.Package())
+			if !forbidden && !hasTargetSegment {
+				reason, forbidden = checkPackagePublishAge(npmrc, esm.Package())
+			}
+			if forbidden {
+				logger.Warnf("blocked %s: %s", ctx.R.URL.Path, reason)
+				return errorResponse(ctx, 403, &BuildError{Kind: "forbidden", Message: "forbidden: " + reason}, esm.PkgName)
+			}
+		}
+
+		// check the security advisories of the package
+		var advisories []*Advisory
+		if !esm.GhPrefix && !esm.GitPrefix && !esm.PrPrefix {
+			advisories = advisoryDB.Query(esm.PkgName, esm.PkgVersion)
+			if isAdvisoryBlocked(advisories) {
+				logger.Warnf("blocked %s: %s", ctx.R.URL.Path, formatAdvisoriesHeader(advisories))
+				ctx.SetHeader("X-ESM-Advisories", formatAdvisoriesHeader(advisories))
+				return errorResponse(ctx, 403, &BuildError{Kind: "advisory", Message: fmt.Sprintf("forbidden: \"%s@%s\" has security advisories: %s", esm.PkgName, esm.PkgVersion, formatAdvisoriesHeader(advisories))}, esm.Specifier())
+			}
+			if len(advisories) > 0 {
+				ctx.SetHeader("X-ESM-Advisories", formatAdvisoriesHeader(advisories))
+				// the advisory database changes over time, don't send the header with the immutable responses
+				defer func() {
+					if ctx.W.Header().Get("Cache-Control") == ccImmutable {
+						ctx.W.Header().Del("X-ESM-Advisories")
+					}
+				}()
+			}
 		}
 
 		origin := getOrigin(ctx)
@@ -922,6 +1035,8 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 		registryPrefix := ""
 		if esm.GhPrefix {
 			registryPrefix = "/gh"
+		} else if esm.GitPrefix {
+			registryPrefix = "/git"
 		} else if esm.PrPrefix {
 			registryPrefix = "/pr"
 		}
@@ -995,7 +1110,7 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 		if esm.SubPath != "" {
 			ext := path.Ext(esm.SubPath)
 			switch ext {
-			case ".mjs":
+			case ".mjs", ".cjs":
 				if hasTargetSegment {
 					pathKind = EsmBuild
 				}
@@ -1015,6 +1130,12 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 				} else {
 					pathKind = RawFile
 				}
+			case ".json":
+				if hasTargetSegment && strings.HasSuffix(esm.SubPath, manifestExt) {
+					pathKind = EsmManifest
+				} else {
+					pathKind = RawFile
+				}
 			default:
 				if ext != "" && assetExts[ext[1:]] {
 					pathKind = RawFile
@@ -1027,6 +1148,20 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 			pathKind = RawFile
 		}
 
+		// check the license of the package when resolving the entry, the bundled dependencies are checked
+		// with the build meta
+		if pathKind == EsmEntry && config.LicensePolicy.IsEnabled() && !esm.GhPrefix && !esm.GitPrefix && !esm.PrPrefix {
+			pkgJson, err := npmrc.getPackageInfo(esm.PkgName, esm.PkgVersion)
+			if err == nil {
+				switch config.LicensePolicy.Check(esm.PkgName, pkgJson.License) {
+				case LicenseDeny:
+					return errorResponse(ctx, http.StatusUnavailableForLegalReasons, newLicenseError(esm.Specifier(), "", esm.PkgName+"@"+esm.PkgVersion, pkgJson.License), esm.Specifier())
+				case LicenseWarn:
+					ctx.W.Header().Add("X-ESM-License-Warning", formatLicenseWarning(esm.PkgName+"@"+esm.PkgVersion, pkgJson.License))
+				}
+			}
+		}
+
 		// redirect to the url with exact package version
 		if !isExactVersion {
 			if hasTargetSegment {
@@ -1036,6 +1171,8 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 				if asteriskPrefix {
 					if esm.GhPrefix || esm.PrPrefix {
 						pkgName = pkgName[0:3] + "*" + pkgName[3:]
+					} else if esm.GitPrefix {
+						pkgName = pkgName[0:4] + "*" + pkgName[4:]
 					} else {
 						pkgName = "*" + pkgName
 					}
@@ -1089,12 +1226,120 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 				buf := &bytes.Buffer{}
 				wasmUrl := origin + pathname
 				fmt.Fprintf(buf, "/* esm.sh - wasm module */\n")
-				fmt.Fprintf(buf, "const data = await fetch(%s).then(r => r.arrayBuffer());\nexport default new WebAssembly.Module(data);", strings.TrimSpace(string(utils.MustEncodeJSON(wasmUrl))))
+				fmt.Fprintf(buf, "export default await WebAssembly.compileStreaming(fetch(%s));", strings.TrimSpace(string(utils.MustEncodeJSON(wasmUrl))))
 				ctx.SetHeader("Content-Type", ctJavaScript)
 				ctx.SetHeader("Cache-Control", ccImmutable)
 				return buf
 			}
 
+			// compile sass/scss/less files to css when `?css` query is present
+			if pathKind == RawFile && endsWith(esm.SubPath, ".sass", ".scss", ".less") && query.Has("css") && !rawFlag {
+				savePath := normalizeSavePath(npmrc.zoneId, path.Join("modules", esm.Name(), esm.SubPath+".css"))
+				r, _, err := buildStorage.Get(savePath)
+				if err != nil && err != storage.ErrNotFound {
+					return rex.Status(500, err.Error())
+				}
+				if err == nil {
+					ctx.SetHeader("Cache-Control", ccImmutable)
+					ctx.SetHeader("Content-Type", ctCSS)
+					return r // auto closed
+				}
+				wd := path.Join(npmrc.StoreDir(), esm.Name())
+				pkgJson, err := npmrc.installPackage(esm.Package())
+				if err != nil {
+					return rex.Status(500, err.Error())
+				}
+				// install dependencies to resolve `@import`/`@use` from node_modules
+				npmrc.installDependencies(wd, pkgJson, false, nil)
+				filename := path.Join(wd, "node_modules", esm.PkgName, esm.SubPath)
+				stat, err := os.Lstat(filename)
+				if err != nil {
+					if os.IsNotExist(err) {
+						return rex.Status(404, "File Not Found")
+					}
+					return rex.Status(500, err.Error())
+				}
+				if stat.IsDir() {
+					return rex.Status(404, "File Not Found")
+				}
+				if stat.Size() > maxAssetFileSize {
+					return rex.Status(403, "File Too Large")
+				}
+				code, err := os.ReadFile(filename)
+				if err != nil {
+					return rex.Status(500, err.Error())
+				}
+				out, err := compileStylesheet(npmrc, wd, filename, string(code))
+				if err != nil {
+					return rex.Status(500, "Failed to compile "+path.Base(esm.SubPath)+": "+err.Error())
+				}
+				ret := esbuild.Build(esbuild.BuildOptions{
+					Stdin: &esbuild.StdinOptions{
+						Sourcefile: path.Base(esm.SubPath) + ".css",
+						Contents:   out.Code,
+						Loader:     esbuild.LoaderCSS,
+					},
+					Write:            false,
+					MinifyWhitespace: config.Minify,
+					MinifySyntax:     config.Minify,
+				})
+				if len(ret.Errors) > 0 {
+					return rex.Status(500, ret.Errors[0].Text)
+				}
+				css := ret.OutputFiles[0].Contents
+				go buildStorage.Put(savePath, bytes.NewReader(css))
+				ctx.SetHeader("Cache-Control", ccImmutable)
+				ctx.SetHeader("Content-Type", ctCSS)
+				return css
+			}
+
+			// convert yaml/toml/csv and text files to es modules when `?module` query is present
+			if pathKind == RawFile && isDataModuleFile(esm.SubPath) && query.Has("module") && !rawFlag {
+				savePath := normalizeSavePath(npmrc.zoneId, path.Join("modules", esm.Name(), esm.SubPath+".mjs"))
+				r, _, err := buildStorage.Get(savePath)
+				if err != nil && err != storage.ErrNotFound {
+					return rex.Status(500, err.Error())
+				}
+				if err == nil {
+					ctx.SetHeader("Cache-Control", ccImmutable)
+					ctx.SetHeader("Content-Type", ctJavaScript)
+					return r // auto closed
+				}
+				wd := path.Join(npmrc.StoreDir(), esm.Name())
+				if !existsDir(wd) {
+					_, err := npmrc.installPackage(esm.Package())
+					if err != nil {
+						return rex.Status(500, err.Error())
+					}
+				}
+				filename := path.Join(wd, "node_modules", esm.PkgName, esm.SubPath)
+				stat, err := os.Lstat(filename)
+				if err != nil {
+					if os.IsNotExist(err) {
+						return rex.Status(404, "File Not Found")
+					}
+					return rex.Status(500, err.Error())
+				}
+				if stat.IsDir() {
+					return rex.Status(404, "File Not Found")
+				}
+				if stat.Size() > maxAssetFileSize {
+					return rex.Status(403, "File Too Large")
+				}
+				data, err := os.ReadFile(filename)
+				if err != nil {
+					return rex.Status(500, err.Error())
+				}
+				js, err := toDataModule(esm.SubPath, data)
+				if err != nil {
+					return rex.Status(500, "Failed to convert "+path.Base(esm.SubPath)+": "+err.Error())
+				}
+				go buildStorage.Put(savePath, bytes.NewReader(js))
+				ctx.SetHeader("Cache-Control", ccImmutable)
+				ctx.SetHeader("Content-Type", ctJavaScript)
+				return js
+			}
+
 			// fix url that is related to `import.meta.url`
 			if hasTargetSegment && pathKind == RawFile && !rawFlag {
 				extname := path.Ext(esm.SubPath)
@@ -1252,7 +1497,8 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 			}
 
 			// build/dts files
-			if pathKind == EsmBuild || pathKind == EsmSourceMap || pathKind == EsmDts {
+			// the `cjs` build needs the build meta to set the `X-TypeScript-Types` header
+			if (pathKind == EsmBuild && !strings.HasSuffix(pathname, ".cjs")) || pathKind == EsmSourceMap || pathKind == EsmManifest || pathKind == EsmDts {
 				var savePath string
 				if asteriskPrefix {
 					pathname = "/*" + pathname[1:]
@@ -1267,7 +1513,7 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 				if err != nil {
 					if err != storage.ErrNotFound {
 						return rex.Status(500, err.Error())
-					} else if pathKind == EsmSourceMap {
+					} else if pathKind == EsmSourceMap || pathKind == EsmManifest {
 						return rex.Status(404, "Not found")
 					}
 				}
@@ -1276,7 +1522,7 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 					ctx.SetHeader("Cache-Control", ccImmutable)
 					if pathKind == EsmDts {
 						ctx.SetHeader("Content-Type", ctTypeScript)
-					} else if pathKind == EsmSourceMap {
+					} else if pathKind == EsmSourceMap || pathKind == EsmManifest {
 						ctx.SetHeader("Content-Type", ctJSON)
 					} else if strings.HasSuffix(pathname, ".css") {
 						ctx.SetHeader("Content-Type", ctCSS)
@@ -1310,6 +1556,7 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 							defer f.Close()
 							xxh := xxhash.New()
 							xxh.Write([]byte(strings.Join(exports, ",")))
+							sourceMapPath := savePath + ".map"
 							savePath = strings.TrimSuffix(savePath, ".mjs") + "_" + base64.RawURLEncoding.EncodeToString(xxh.Sum(nil)) + ".mjs"
 							f2, _, err := buildStorage.Get(savePath)
 							if err == nil {
@@ -1325,23 +1572,26 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 							target := "es2022"
 							// check target in the pathname
 							for _, seg := range strings.Split(pathname, "/") {
-								if targets[seg] > 0 {
+								if isBuildTarget(seg) {
 									target = seg
 									break
 								}
 							}
-							ret, err := treeShake(code, exports, targets[target])
+							ret, err := treeShakeAndSave(buildStorage, code, sourceMapPath, savePath, exports, target)
 							if err != nil {
 								return rex.Status(500, err.Error())
 							}
-							go buildStorage.Put(savePath, bytes.NewReader(ret))
-							// note: the source map is dropped
 							return ret
 						}
 					}
 					if pathKind == EsmDts {
 						defer f.Close()
-						buffer, err := io.ReadAll(f)
+						var buffer []byte
+						if query.Has("dts-bundle") {
+							buffer, err = getBundledDTS(buildStorage, savePath)
+						} else {
+							buffer, err = io.ReadAll(f)
+						}
 						if err != nil {
 							return rex.Status(500, err.Error())
 						}
@@ -1353,8 +1603,8 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 		}
 
 		// determine build target by `?target` query or `User-Agent` header
-		target := strings.ToLower(query.Get("target"))
-		targetFromUA := targets[target] == 0
+		target := normalizeBuildTarget(query.Get("target"))
+		targetFromUA := target == ""
 		if targetFromUA {
 			target = getBuildTargetByUA(ctx.UserAgent())
 		}
@@ -1394,22 +1644,6 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 			return redirect(ctx, fmt.Sprintf("%s%s/%s@%s%s%s", origin, registryPrefix, pkgName, pkgVersion, subPath, qs), false)
 		}
 
-		// check `?alias` query
-		alias := map[string]string{}
-		if query.Has("alias") {
-			for _, p := range strings.Split(query.Get("alias"), ",") {
-				p = strings.TrimSpace(p)
-				if p != "" {
-					name, to := utils.SplitByFirstByte(p, ':')
-					name = strings.TrimSpace(name)
-					to = strings.TrimSpace(to)
-					if name != "" && to != "" && name != esm.PkgName {
-						alias[name] = to
-					}
-				}
-			}
-		}
-
 		// check `?deps` query
 		deps := map[string]string{}
 		if query.Has("deps") {
@@ -1421,12 +1655,52 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 						return rex.Status(400, fmt.Sprintf("Invalid deps query: %v not found", v))
 					}
 					if m.PkgName != esm.PkgName {
+						if reason, forbidden := checkPackageRules(npmrc, m.Package()); forbidden {
+							logger.Warnf("blocked %s: deps %s", ctx.R.URL.Path, reason)
+							return errorResponse(ctx, 403, &BuildError{Kind: "forbidden", Message: "forbidden: deps " + reason}, esm.Specifier())
+						}
 						deps[m.PkgName] = m.PkgVersion
 					}
 				}
 			}
 		}
 
+		// check `?alias` query
+		alias := map[string]string{}
+		if query.Has("alias") {
+			for _, p := range strings.Split(query.Get("alias"), ",") {
+				p = strings.TrimSpace(p)
+				if p != "" {
+					name, to := utils.SplitByFirstByte(p, ':')
+					name = strings.TrimSpace(name)
+					to = strings.TrimSpace(to)
+					if name != "" && to != "" && name != esm.PkgName {
+						// check the alias target with its resolved version, the version pinned by the `?deps` query is used
+						// if the target has no version
+						if hasPackageRules() {
+							specifier := to
+							if toName, toVersion, _, _ := splitEsmPath(to); toVersion == "" && deps[toName] != "" {
+								specifier = toName + "@" + deps[toName]
+							}
+							m, _, _, _, err := praseEsmPath(npmrc, specifier)
+							if err != nil {
+								message := err.Error()
+								if strings.HasPrefix(message, "invalid") || strings.HasSuffix(message, " not found") {
+									return rex.Status(400, "Invalid alias query: "+message)
+								}
+								return rex.Status(500, message)
+							}
+							if reason, forbidden := checkPackageRules(npmrc, m.Package()); forbidden {
+								logger.Warnf("blocked %s: alias %s", ctx.R.URL.Path, reason)
+								return errorResponse(ctx, 403, &BuildError{Kind: "forbidden", Message: "forbidden: alias " + reason}, esm.Specifier())
+							}
+						}
+						alias[name] = to
+					}
+				}
+			}
+		}
+
 		// check `?conditions` query
 		var conditions []string
 		conditionsSet := set.New[string]()
@@ -1440,6 +1714,30 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 			}
 		}
 
+		// check `?define` and `?env` query
+		var define map[string]string
+		if query.Has("define") {
+			define, err = parseDefine(query.Get("define"))
+			if err != nil {
+				return rex.Status(400, err.Error())
+			}
+		}
+		if query.Has("env") {
+			env, err := parseDefine(query.Get("env"))
+			if err != nil {
+				return rex.Status(400, err.Error())
+			}
+			if define == nil {
+				define = map[string]string{}
+			}
+			for key, value := range env {
+				if !isJsIdentifier(key) {
+					return rex.Status(400, "invalid env key '"+key+"'")
+				}
+				define["process.env."+key] = value
+			}
+		}
+
 		// check `?external` query
 		external := set.New[string]()
 		externalAll := asteriskPrefix
@@ -1460,6 +1758,7 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 		buildArgs := BuildArgs{
 			alias:      alias,
 			conditions: conditions,
+			define:     define,
 			deps:       deps,
 		}
 		if !externalAll && external.Len() > 0 {
@@ -1484,16 +1783,16 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 
 		// build and return the types(.d.ts) file
 		if pathKind == EsmDts {
+			args := ""
+			if a := encodeBuildArgs(buildArgs, true); a != "" {
+				args = "X-" + a
+			}
+			savePath := normalizeSavePath(npmrc.zoneId, path.Join(fmt.Sprintf(
+				"types/%s/%s",
+				esm.Name(),
+				args,
+			), esm.SubPath))
 			readDts := func() (content io.ReadCloser, stat storage.Stat, err error) {
-				args := ""
-				if a := encodeBuildArgs(buildArgs, true); a != "" {
-					args = "X-" + a
-				}
-				savePath := normalizeSavePath(npmrc.zoneId, path.Join(fmt.Sprintf(
-					"types/%s/%s",
-					esm.Name(),
-					args,
-				), esm.SubPath))
 				content, stat, err = buildStorage.Get(savePath)
 				return
 			}
@@ -1519,11 +1818,11 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 						if output.err.Error() == "types not found" {
 							return rex.Status(404, "Types Not Found")
 						}
-						return rex.Status(500, "Failed to build types: "+output.err.Error())
+						return errorResponse(ctx, 500, fmt.Errorf("Failed to build types: %w", output.err), esm.Specifier())
 					}
 				case <-time.After(time.Duration(config.BuildWaitTime) * time.Second):
 					ctx.SetHeader("Cache-Control", ccMustRevalidate)
-					return rex.Status(http.StatusRequestTimeout, "timeout, the types is waiting to be built, please try refreshing the page.")
+					return errorResponse(ctx, http.StatusRequestTimeout, &BuildError{Kind: "timeout", Message: "timeout, the types is waiting to be built, please try refreshing the page."}, esm.Specifier())
 				}
 				content, _, err = readDts()
 			}
@@ -1534,7 +1833,12 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 				return rex.Status(500, err.Error())
 			}
 			defer content.Close()
-			buffer, err := io.ReadAll(content)
+			var buffer []byte
+			if query.Has("dts-bundle") {
+				buffer, err = getBundledDTS(buildStorage, savePath)
+			} else {
+				buffer, err = io.ReadAll(content)
+			}
 			if err != nil {
 				return rex.Status(500, err.Error())
 			}
@@ -1552,6 +1856,21 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 			buildArgs.externalRequire = externalRequire
 			buildArgs.keepNames = query.Has("keep-names")
 			buildArgs.ignoreAnnotations = query.Has("ignore-annotations")
+			if format := query.Get("format"); format != "" && pathKind == EsmEntry {
+				if format != "iife" && format != "umd" && format != "cjs" {
+					return rex.Status(400, "Invalid format: "+format)
+				}
+				buildArgs.format = format
+				if format != "cjs" {
+					globalName := query.Get("global")
+					if globalName == "" {
+						globalName = toGlobalName(esm.PkgName + "/" + esm.SubModuleName)
+					} else if !isGlobalName(globalName) {
+						return rex.Status(400, "Invalid global name: "+globalName)
+					}
+					buildArgs.globalName = globalName
+				}
+			}
 		}
 
 		bundleMode := BundleDefault
@@ -1560,6 +1879,10 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 		} else if query.Has("no-bundle") || query.Get("bundle") == "false" {
 			bundleMode = BundleFalse
 		}
+		// the `iife`, `umd` and `cjs` builds bundle all dependencies
+		if buildArgs.format != "" {
+			bundleMode = BundleDeps
+		}
 
 		dev := query.Has("dev")
 		// force react/jsx-dev-runtime and react-refresh into `dev` mode
@@ -1572,9 +1895,11 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 			a := strings.Split(esm.SubModuleName, "/")
 			if len(a) > 0 {
 				maybeTarget := a[0]
-				if _, ok := targets[maybeTarget]; ok {
+				if isBuildTarget(maybeTarget) {
 					submodule := strings.Join(a[1:], "/")
-					if strings.HasSuffix(submodule, ".bundle") {
+					if buildArgs.format != "" {
+						submodule = strings.TrimSuffix(submodule, "."+buildArgs.format)
+					} else if strings.HasSuffix(submodule, ".bundle") {
 						submodule = strings.TrimSuffix(submodule, ".bundle")
 						bundleMode = BundleDeps
 					} else if strings.HasSuffix(submodule, ".nobundle") {
@@ -1629,17 +1954,89 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 			select {
 			case output := <-ch:
 				if output.err != nil {
+					var e *BuildError
+					if errors.As(output.err, &e) && e.Kind == "license" {
+						return errorResponse(ctx, http.StatusUnavailableForLegalReasons, output.err, esm.Specifier())
+					} else if e != nil && (e.Kind == "forbidden" || e.Kind == "advisory") {
+						return errorResponse(ctx, 403, output.err, esm.Specifier())
+					}
 					msg := output.err.Error()
 					if msg == "could not resolve build entry" || strings.HasSuffix(msg, " not found") || strings.Contains(msg, "is not exported from package") || strings.Contains(msg, "no such file or directory") {
-						return rex.Status(404, msg)
+						return errorResponse(ctx, 404, output.err, esm.Specifier())
 					}
-					return rex.Status(500, msg)
+					return errorResponse(ctx, 500, output.err, esm.Specifier())
 				}
 				ret = output.meta
 			case <-time.After(time.Duration(config.BuildWaitTime) * time.Second):
 				ctx.SetHeader("Cache-Control", ccMustRevalidate)
-				return rex.Status(http.StatusRequestTimeout, "timeout, the module is waiting to be built, please try refreshing the page.")
+				return errorResponse(ctx, http.StatusRequestTimeout, &BuildError{Kind: "timeout", Message: "timeout, the module is waiting to be built, please try refreshing the page."}, esm.Specifier())
+			}
+		}
+
+		// use the bundled types when the `?dts-bundle` query is present
+		getDtsUrl := func(dts string) string {
+			if query.Has("dts-bundle") {
+				return origin + dts + "?dts-bundle"
+			}
+			return origin + dts
+		}
+
+		// check the bundled dependencies with the current advisory database, the build may be cached before the advisories are added
+		if config.Advisories.Block != "" && len(ret.BundledDeps) > 0 {
+			if err := checkBundledAdvisories(esm.Specifier(), ret.BundledDeps); err != nil {
+				logger.Warnf("blocked %s: %v", ctx.R.URL.Path, err)
+				return errorResponse(ctx, 403, err, esm.Specifier())
+			}
+		}
+
+		// check the bundled dependencies with the current license policy, the build may be cached before the policy changes
+		if config.LicensePolicy.IsEnabled() && len(ret.BundledDeps) > 0 {
+			warnings, err := checkBundledLicenses(esm.Specifier(), ret.BundledDeps)
+			if err != nil {
+				return errorResponse(ctx, http.StatusUnavailableForLegalReasons, err, esm.Specifier())
+			}
+			for _, warning := range warnings {
+				ctx.W.Header().Add("X-ESM-License-Warning", warning)
+			}
+		}
+
+		// return the security advisories of the dependency tree with the `?audit` query
+		if query.Has("audit") {
+			sbom, err := build.buildSBOM(ret)
+			if err != nil {
+				return errorResponse(ctx, 500, err, esm.Specifier())
+			}
+			// the advisory database may be reloaded at any time
+			ctx.SetHeader("Cache-Control", ccMustRevalidate)
+			ctx.SetHeader("Content-Type", ctJSON)
+			return utils.MustEncodeJSON(auditSBOM(sbom))
+		}
+
+		if query.Has("sbom") {
+			sbom, err := build.buildSBOM(ret)
+			if err != nil {
+				return errorResponse(ctx, 500, err, esm.Specifier())
+			}
+			var data []byte
+			switch format := query.Get("sbom"); format {
+			case "", "cyclonedx":
+				data, err = sbom.CycloneDX("esm.sh", VERSION)
+				ctx.SetHeader("Content-Type", "application/vnd.cyclonedx+json")
+			case "spdx":
+				data, err = sbom.SPDX("esm.sh", VERSION, origin+"/spdx/"+esm.Name())
+				ctx.SetHeader("Content-Type", "application/spdx+json")
+			default:
+				return rex.Status(400, "Invalid sbom format: "+format)
+			}
+			if err != nil {
+				return rex.Status(500, err.Error())
+			}
+			if isExactVersion {
+				ctx.SetHeader("Cache-Control", ccOneDay)
+			} else {
+				ctx.SetHeader("Cache-Control", fmt.Sprintf("public, max-age=%d", config.NpmQueryCacheTTL))
 			}
+			return data
 		}
 
 		if ret.CSSEntry != "" {
@@ -1649,8 +2046,7 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 
 		// redirect to `*.d.ts` file
 		if ret.TypesOnly {
-			dtsUrl := origin + ret.Dts
-			ctx.SetHeader("X-TypeScript-Types", dtsUrl)
+			ctx.SetHeader("X-TypeScript-Types", getDtsUrl(ret.Dts))
 			ctx.SetHeader("Content-Type", ctJavaScript)
 			ctx.SetHeader("Cache-Control", ccImmutable)
 			if ctx.R.Method == http.MethodHead {
@@ -1681,6 +2077,20 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 		exports := jsIdentSet.Values()
 		sort.Strings(exports)
 
+		// the `cjs` build shares the types with the esm build
+		if buildArgs.format == "cjs" && ret.Dts != "" && !query.Has("no-dts") && !query.Has("no-check") {
+			ctx.SetHeader("X-TypeScript-Types", getDtsUrl(ret.Dts))
+			ctx.SetHeader("Access-Control-Expose-Headers", "X-TypeScript-Types")
+		}
+
+		// redirect to the build file for the `iife`, `umd` and `cjs` format, which can't be re-exported by an es module
+		if buildArgs.format != "" && (pathKind == EsmEntry || esm.SubPath != build.esm.SubPath) {
+			if targetFromUA {
+				appendVaryHeader(ctx.W.Header(), "User-Agent")
+			}
+			return redirect(ctx, origin+build.Path(), isExactVersion)
+		}
+
 		// if the path is `ESMBuild`, return the built js/css content
 		if pathKind == EsmBuild {
 			if esm.SubPath != build.esm.SubPath {
@@ -1720,7 +2130,7 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 				ctx.SetHeader("Content-Type", ctJSON)
 			} else {
 				ctx.SetHeader("Content-Type", ctJavaScript)
-				if query.Has("worker") {
+				if query.Has("worker") && buildArgs.format == "" {
 					defer f.Close()
 					moduleUrl := origin + build.Path()
 					if !ret.CJS && len(exports) > 0 {
@@ -1732,10 +2142,11 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 						moduleUrl,
 					)
 				}
-				if !ret.CJS && len(exports) > 0 {
+				if !ret.CJS && len(exports) > 0 && buildArgs.format == "" {
 					defer f.Close()
 					xxh := xxhash.New()
 					xxh.Write([]byte(strings.Join(exports, ",")))
+					sourceMapPath := savePath + ".map"
 					savePath = strings.TrimSuffix(savePath, ".mjs") + "_" + base64.RawURLEncoding.EncodeToString(xxh.Sum(nil)) + ".mjs"
 					f2, _, err := buildStorage.Get(savePath)
 					if err == nil {
@@ -1748,12 +2159,10 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 					if err != nil {
 						return rex.Status(500, err.Error())
 					}
-					ret, err := treeShake(code, exports, targets[target])
+					ret, err := treeShakeAndSave(buildStorage, code, sourceMapPath, savePath, exports, target)
 					if err != nil {
 						return rex.Status(500, err.Error())
 					}
-					go buildStorage.Put(savePath, bytes.NewReader(ret))
-					// note: the source map is dropped
 					return ret
 				}
 			}
@@ -1764,6 +2173,15 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 		defer recycle()
 		fmt.Fprintf(buf, "/* esm.sh - %s */\n", esm.Specifier())
 
+		// print the security advisories in the console for the development build
+		if build.dev && len(advisories) > 0 {
+			msg := fmt.Sprintf("[esm.sh] %s@%s has %d security advisories:", esm.PkgName, esm.PkgVersion, len(advisories))
+			for _, a := range advisories {
+				msg += fmt.Sprintf("\n  - %s (%s) %s", a.Id, a.Severity, a.Summary)
+			}
+			fmt.Fprintf(buf, "console.warn(%s);\n", utils.MustEncodeJSON(msg))
+		}
+
 		if query.Has("worker") {
 			moduleUrl := origin + build.Path()
 			if !ret.CJS && len(exports) > 0 {
@@ -1794,7 +2212,7 @@ func esmRouter(db Database, buildStorage storage.Storage, logger *log.Logger) re
 				fmt.Fprintf(buf, "export const { %s } = _;\n", strings.Join(exports, ", "))
 			}
 			if noDts := query.Has("no-dts") || query.Has("no-check"); !noDts && ret.Dts != "" {
-				ctx.SetHeader("X-TypeScript-Types", origin+ret.Dts)
+				ctx.SetHeader("X-TypeScript-Types", getDtsUrl(ret.Dts))
 				ctx.SetHeader("Access-Control-Expose-Headers", "X-ESM-Path, X-TypeScript-Types")
 			} else {
 				ctx.SetHeader("Access-Control-Expose-Headers", "X-ESM-Path")
@@ -1845,15 +2263,41 @@ func redirect(ctx *rex.Context, url string, isMovedPermanently bool) any {
 	return rex.Status(code, nil)
 }
 
-func errorJS(ctx *rex.Context, message string) any {
+// acceptsJSONError checks if the client accepts structured JSON errors.
+func acceptsJSONError(ctx *rex.Context) bool {
+	return strings.Contains(ctx.R.Header.Get("Accept"), "application/json") || ctx.R.URL.Query().Has("diagnostics")
+}
+
+// errorResponse returns the error as JSON if the client accepts `application/json` or requests with the
+// `?diagnostics` query, otherwise returns the error message as plain text.
+func errorResponse(ctx *rex.Context, code int, err error, pkg string) any {
+	appendVaryHeader(ctx.W.Header(), "Accept")
+	if !acceptsJSONError(ctx) {
+		return rex.Status(code, err.Error())
+	}
+	e := *toBuildError(err, pkg)
+	e.Code = code
+	ctx.SetHeader("Content-Type", ctJSON)
+	return rex.Status(code, utils.MustEncodeJSON(e))
+}
+
+func errorJS(ctx *rex.Context, e *BuildError) any {
+	appendVaryHeader(ctx.W.Header(), "Accept")
+	ctx.SetHeader("Cache-Control", ccImmutable)
+	if acceptsJSONError(ctx) {
+		ctx.SetHeader("Content-Type", ctJSON)
+		return utils.MustEncodeJSON(e)
+	}
 	buf, recycle := NewBuffer()
 	defer recycle()
 	buf.WriteString("/* esm.sh - error */\n")
+	buf.WriteString("console.error(")
+	buf.Write(utils.MustEncodeJSON(e.formatConsoleMessage()))
+	buf.WriteString(");\n")
 	buf.WriteString("throw new Error(")
-	buf.Write(utils.MustEncodeJSON(message))
+	buf.Write(utils.MustEncodeJSON(e.Message))
 	buf.WriteString(");\n")
 	buf.WriteString("export default null;\n")
 	ctx.SetHeader("Content-Type", ctJavaScript)
-	ctx.SetHeader("Cache-Control", ccImmutable)
 	return buf.Bytes()
 }
@@ -0,0 +1,247 @@
+package server
+
+import (
+	"path"
+	"strings"
+
+	"github.com/esm-dev/esm.sh/server/common"
+	"github.com/ije/gox/set"
+	"github.com/ije/gox/utils"
+)
+
+// buildSBOM generates the software bill of materials of the build, it includes the packages that are
+// bundled into the build and the packages that are imported by the build recursively.
+func (ctx *BuildContext) buildSBOM(meta *BuildMeta) (*common.SBOM, error) {
+	root, err := ctx.getSBOMComponent(ctx.esm.Package(), "")
+	if err != nil {
+		return nil, err
+	}
+	sbom := &common.SBOM{Root: root.Purl}
+	sbom.Add(root)
+
+	// the `iife`, `umd` and `cjs` builds always bundle all dependencies
+	if ctx.bundleMode == BundleDeps || ctx.args.format != "" {
+		wd := path.Join(ctx.npmrc.StoreDir(), ctx.esm.Name())
+		ctx.walkBundledDeps(sbom, root, wd, path.Join(wd, "node_modules", ctx.esm.PkgName, "package.json"), set.New[string](), true)
+	}
+
+	ctx.walkImports(sbom, root, meta.Imports, set.New[string]())
+	return sbom, nil
+}
+
+// walkBundledDeps adds the dependencies that are installed in the working directory of the build, the
+// tarball integrity of the dependencies is looked up from the registry only if `lookup` is true.
+func (ctx *BuildContext) walkBundledDeps(sbom *common.SBOM, parent *common.SBOMComponent, wd string, pkgJsonPath string, mark *set.Set[string], lookup bool) {
+	var raw PackageJSONRaw
+	if utils.ParseJSONFile(pkgJsonPath, &raw) != nil {
+		return
+	}
+	p := raw.ToNpmPackage()
+	deps := set.New[string]()
+	for name := range p.Dependencies {
+		deps.Add(name)
+	}
+	for name := range p.PeerDependencies {
+		deps.Add(name)
+	}
+	for _, name := range deps.Values() {
+		if ctx.externalAll || ctx.args.external.Has(name) || strings.HasPrefix(name, "@types/") {
+			continue
+		}
+		depPkgJsonPath := path.Join(wd, "node_modules", name, "package.json")
+		var depRaw PackageJSONRaw
+		if utils.ParseJSONFile(depPkgJsonPath, &depRaw) != nil {
+			// not installed
+			continue
+		}
+		c := &common.SBOMComponent{Name: name, Version: depRaw.Version, Purl: common.NpmPurl(name, depRaw.Version), Scope: "bundled"}
+		if lookup {
+			// keep the dependency if the registry is unavailable
+			if ret, err := ctx.getSBOMComponent(Package{Name: name, Version: depRaw.Version}, "bundled"); err == nil {
+				c = ret
+			}
+		}
+		// the license of the installed package is what gets bundled
+		if license := depRaw.ToNpmPackage().License; license != "" {
+			c.License = license
+		}
+		c = sbom.Add(c)
+		parent.AddDependency(c.Purl)
+		if !mark.Has(name) {
+			mark.Add(name)
+			ctx.walkBundledDeps(sbom, c, wd, depPkgJsonPath, mark, lookup)
+		}
+	}
+}
+
+// walkImports adds the packages of the imported modules, the imports of the imported modules are
+// walked with their build meta, or with the dependencies of the package if the module is not built yet.
+func (ctx *BuildContext) walkImports(sbom *common.SBOM, parent *common.SBOMComponent, imports []string, mark *set.Set[string]) {
+	for _, importPath := range imports {
+		pkg, ok := parseSBOMImportPath(importPath)
+		if !ok {
+			if !strings.HasPrefix(strings.TrimPrefix(importPath, "/"), "node/") {
+				ctx.logger.Warnf("sbom(%s): unknown import '%s'", ctx.esm.Specifier(), importPath)
+			}
+			continue
+		}
+		c := parent
+		if pkg.Name != ctx.esm.PkgName || pkg.Version != ctx.esm.PkgVersion {
+			var err error
+			c, err = ctx.getSBOMComponent(pkg, "imported")
+			if err != nil {
+				continue
+			}
+			c = sbom.Add(c)
+			parent.AddDependency(c.Purl)
+		}
+		if mark.Has(importPath) {
+			continue
+		}
+		mark.Add(importPath)
+		metadata, err := ctx.db.Get(ctx.npmrc.zoneId + ":" + importPath)
+		if err == nil && metadata != nil {
+			meta, err := decodeBuildMeta(metadata)
+			if err == nil {
+				ctx.walkImports(sbom, c, meta.Imports, mark)
+				continue
+			}
+		}
+		if c != parent {
+			ctx.walkPackageDeps(sbom, c, pkg, mark)
+		}
+	}
+}
+
+// walkPackageDeps adds the dependencies of the package resolved with its package.json, it's used when
+// the build meta of an imported module is missing (e.g. the cache is cold).
+func (ctx *BuildContext) walkPackageDeps(sbom *common.SBOM, parent *common.SBOMComponent, pkg Package, mark *set.Set[string]) {
+	if mark.Has(parent.Purl) {
+		return
+	}
+	mark.Add(parent.Purl)
+	installDir := path.Join(ctx.npmrc.StoreDir(), pkg.String())
+	var p *PackageJSON
+	var raw PackageJSONRaw
+	if utils.ParseJSONFile(path.Join(installDir, "node_modules", pkg.Name, "package.json"), &raw) == nil {
+		p = raw.ToNpmPackage()
+	} else if !pkg.Github && !pkg.Git && !pkg.PkgPrNew {
+		var err error
+		p, err = ctx.npmrc.getPackageInfo(pkg.Name, pkg.Version)
+		if err != nil {
+			return
+		}
+	} else {
+		return
+	}
+	deps := map[string]string{}
+	for name, version := range p.Dependencies {
+		deps[name] = version
+	}
+	for name, version := range p.PeerDependencies {
+		deps[name] = version
+	}
+	for name, version := range deps {
+		if ctx.externalAll || ctx.args.external.Has(name) || strings.HasPrefix(name, "@types/") {
+			continue
+		}
+		if v, ok := ctx.args.deps[name]; ok {
+			version = v
+		}
+		depPkg := Package{Name: name, Version: version}
+		if dp, err := resolveDependencyVersion(version); err == nil && dp.Name != "" {
+			depPkg = dp
+		}
+		// prefer the installed version
+		var depRaw PackageJSONRaw
+		if utils.ParseJSONFile(path.Join(installDir, "node_modules", name, "package.json"), &depRaw) == nil && depRaw.Name == depPkg.Name {
+			depPkg.Version = depRaw.Version
+		} else if !depPkg.Github && !depPkg.Git && !depPkg.PkgPrNew && !isExactVersion(depPkg.Version) {
+			info, err := ctx.npmrc.getPackageInfo(depPkg.Name, depPkg.Version)
+			if err != nil {
+				continue
+			}
+			depPkg.Version = info.Version
+		}
+		c, err := ctx.getSBOMComponent(depPkg, "imported")
+		if err != nil {
+			continue
+		}
+		c = sbom.Add(c)
+		parent.AddDependency(c.Purl)
+		ctx.walkPackageDeps(sbom, c, depPkg, mark)
+	}
+}
+
+// getSBOMComponent returns the component of the package with the license and the tarball integrity.
+func (ctx *BuildContext) getSBOMComponent(pkg Package, scope string) (*common.SBOMComponent, error) {
+	c := &common.SBOMComponent{
+		Name:    pkg.Name,
+		Version: pkg.Version,
+		Scope:   scope,
+	}
+	if pkg.Github {
+		c.Purl = "pkg:github/" + pkg.Name + "@" + pkg.Version
+	} else if pkg.Git {
+		c.Purl = "pkg:generic/" + pkg.Name + "@" + pkg.Version
+	} else {
+		c.Purl = common.NpmPurl(pkg.Name, pkg.Version)
+	}
+	if pkg.Github || pkg.Git || pkg.PkgPrNew {
+		var raw PackageJSONRaw
+		if utils.ParseJSONFile(path.Join(ctx.npmrc.StoreDir(), pkg.String(), "node_modules", pkg.Name, "package.json"), &raw) == nil {
+			c.License = raw.ToNpmPackage().License
+		}
+		return c, nil
+	}
+	info, err := ctx.npmrc.getPackageInfo(pkg.Name, pkg.Version)
+	if err != nil {
+		return nil, err
+	}
+	c.License = info.License
+	dist := info.Dist
+	if dist.Tarball == "" {
+		// the installed package.json doesn't have the `dist` field
+		dist, _ = ctx.npmrc.getInstalledPackageDist(pkg.Name, pkg.Version)
+	}
+	c.Tarball = dist.Tarball
+	c.Integrity = dist.Integrity
+	return c, nil
+}
+
+// parseSBOMImportPath returns the package of the import path of a build, e.g.
+// "/react@19.0.0/es2022/react.mjs" -> react@19.0.0, the node polyfills are ignored.
+func parseSBOMImportPath(importPath string) (pkg Package, ok bool) {
+	p := strings.TrimPrefix(strings.TrimPrefix(importPath, "/"), "*")
+	if strings.HasPrefix(p, "node/") {
+		return
+	}
+	if strings.HasPrefix(p, "gh/") {
+		// "/gh/owner/repo@ref/..."
+		name, version, _, _ := splitEsmPath("/@" + p[3:])
+		return Package{Name: name[1:], Version: version, Github: true}, version != ""
+	}
+	if strings.HasPrefix(p, "pr/") {
+		name, version, _, _ := splitEsmPath("/" + p[3:])
+		return Package{Name: name, Version: version, PkgPrNew: true}, version != ""
+	}
+	if strings.HasPrefix(p, "git/") {
+		// "/git/host/owner/repo@ref/...", the repo name contains slashes
+		name, rest := utils.SplitByFirstByte(p[4:], '@')
+		version, _ := utils.SplitByFirstByte(rest, '/')
+		return Package{Name: name, Version: version, Git: true}, name != "" && version != ""
+	}
+	if strings.HasPrefix(p, "jsr/") {
+		// "/jsr/@scope/name@version/..." -> @jsr/scope__name@version
+		name, version, _, _ := splitEsmPath("/" + p[4:])
+		if !strings.HasPrefix(name, "@") || !strings.ContainsRune(name, '/') || !isExactVersion(version) {
+			return
+		}
+		return Package{Name: "@jsr/" + strings.Replace(name[1:], "/", "__", 1), Version: version}, true
+	}
+	name, version, _, _ := splitEsmPath("/" + p)
+	if name == "" || !isExactVersion(version) {
+		return
+	}
+	return Package{Name: name, Version: version}, true
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/esm-dev/esm.sh/server/common"
+	"github.com/ije/gox/log"
+	"github.com/ije/gox/set"
+)
+
+func TestParseSBOMImportPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected Package
+		ok       bool
+	}{
+		{"/react@19.0.0/es2022/react.mjs", Package{Name: "react", Version: "19.0.0"}, true},
+		{"/@babel/runtime@7.26.0/es2022/helpers/extends.mjs", Package{Name: "@babel/runtime", Version: "7.26.0"}, true},
+		{"/*preact@10.25.0/es2022/hooks.mjs", Package{Name: "preact", Version: "10.25.0"}, true},
+		{"/gh/microsoft/tslib@v2.8.0/es2022/tslib.mjs", Package{Name: "microsoft/tslib", Version: "v2.8.0", Github: true}, true},
+		{"/git/git.example.com/team/lib@v1.1.0/es2022/lib.mjs", Package{Name: "git.example.com/team/lib", Version: "v1.1.0", Git: true}, true},
+		{"/jsr/@std/path@1.0.8/es2022/path.mjs", Package{Name: "@jsr/std__path", Version: "1.0.8"}, true},
+		{"/@jsr/std__path@1.0.8/es2022/path.mjs", Package{Name: "@jsr/std__path", Version: "1.0.8"}, true},
+		{"/node/buffer.mjs", Package{}, false},
+	}
+	for _, test := range tests {
+		pkg, ok := parseSBOMImportPath(test.path)
+		if ok != test.ok || pkg != test.expected {
+			t.Fatalf("parseSBOMImportPath(%q): expected %v, got %v", test.path, test.expected, pkg)
+		}
+	}
+}
+
+func TestSBOM(t *testing.T) {
+	var raw PackageJSONRaw
+	json.Unmarshal([]byte(`{"name":"foo","version":"1.0.0","licenses":[{"type":"MIT"},{"type":"Apache-2.0"}]}`), &raw)
+	if license := raw.ToNpmPackage().License; license != "(MIT OR Apache-2.0)" {
+		t.Fatalf("unexpected license %q", license)
+	}
+
+	root := &common.SBOMComponent{Name: "foo", Version: "1.0.0", Purl: common.NpmPurl("foo", "1.0.0"), License: "(MIT OR Apache-2.0)"}
+	sbom := &common.SBOM{Root: root.Purl}
+	sbom.Add(root)
+	bar := sbom.Add(&common.SBOMComponent{
+		Name:      "@scope/bar",
+		Version:   "2.0.0",
+		Purl:      common.NpmPurl("@scope/bar", "2.0.0"),
+		License:   "ISC",
+		Tarball:   "https://registry.npmjs.org/@scope/bar/-/bar-2.0.0.tgz",
+		Integrity: "sha512-AAECAw==",
+		Scope:     "bundled",
+	})
+	root.AddDependency(bar.Purl)
+	baz := sbom.Add(&common.SBOMComponent{Name: "baz", Version: "3.0.0", Purl: common.NpmPurl("baz", "3.0.0"), License: "MIT", Scope: "imported"})
+	bar.AddDependency(baz.Purl)
+
+	if bar.Purl != "pkg:npm/%40scope/bar@2.0.0" {
+		t.Fatalf("unexpected purl %q", bar.Purl)
+	}
+
+	data, err := sbom.CycloneDX("esm.sh", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"content": "00010203"`) || !strings.Contains(string(data), `"expression": "(MIT OR Apache-2.0)"`) {
+		t.Fatalf("unexpected CycloneDX output: %s", data)
+	}
+	parsed, err := common.ParseCycloneDX(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.Components) != 3 || parsed.Root != root.Purl {
+		t.Fatalf("unexpected components %v", parsed.Components)
+	}
+	c := parsed.Get(bar.Purl)
+	if c.Name != "@scope/bar" || c.Integrity != "sha512-AAECAw==" || c.Scope != "bundled" || c.Tarball != bar.Tarball || len(c.DependsOn) != 1 {
+		t.Fatalf("unexpected component %v", c)
+	}
+
+	data, err = sbom.SPDX("esm.sh", "test", "https://esm.sh/spdx/foo@1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`"relationshipType": "CONTAINS"`,
+		`"spdxElementId": "SPDXRef-Package-scope-bar-2.0.0"`,
+		`"relatedSpdxElement": "SPDXRef-Package-baz-3.0.0"`,
+		`"licenseDeclared": "(MIT OR Apache-2.0)"`,
+	} {
+		if !strings.Contains(string(data), s) {
+			t.Fatalf("expected %s in SPDX output: %s", s, data)
+		}
+	}
+
+	// merge the SBOMs of an app
+	app := &common.SBOM{Root: "pkg:generic/app"}
+	app.Add(&common.SBOMComponent{Name: "app", Purl: "pkg:generic/app"})
+	app.Merge(parsed)
+	if len(app.Components) != 4 || app.Get("pkg:generic/app").DependsOn[0] != root.Purl || app.Get(root.Purl).Scope != "imported" {
+		t.Fatalf("unexpected merged SBOM %v", app.Components)
+	}
+}
+
+func TestSBOMWalkImportsColdCache(t *testing.T) {
+	workDir := config.WorkDir
+	defer func() { config.WorkDir = workDir }()
+	config.WorkDir = t.TempDir()
+
+	npmrc := &NpmRC{}
+	for pkgId, pkgJson := range map[string]string{
+		"sbom-cold-foo@1.0.0": `{"name":"sbom-cold-foo","version":"1.0.0","license":"MIT","dependencies":{"sbom-cold-bar":"^2.0.0"}}`,
+		"sbom-cold-bar@2.1.0": `{"name":"sbom-cold-bar","version":"2.1.0","license":"ISC"}`,
+	} {
+		pkgName, _, _, _ := splitEsmPath(pkgId)
+		dir := path.Join(npmrc.StoreDir(), pkgId, "node_modules", pkgName)
+		os.MkdirAll(dir, 0755)
+		os.WriteFile(path.Join(dir, "package.json"), []byte(pkgJson), 0644)
+	}
+	os.Symlink(path.Join(npmrc.StoreDir(), "sbom-cold-bar@2.1.0", "node_modules", "sbom-cold-bar"), path.Join(npmrc.StoreDir(), "sbom-cold-foo@1.0.0", "node_modules", "sbom-cold-bar"))
+
+	db, err := OpenBoltDB(path.Join(config.WorkDir, "esm.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := &BuildContext{npmrc: npmrc, db: db, esm: EsmPath{PkgName: "app", PkgVersion: "1.0.0"}}
+	root := &common.SBOMComponent{Name: "app", Version: "1.0.0", Purl: common.NpmPurl("app", "1.0.0")}
+	sbom := &common.SBOM{Root: root.Purl}
+	sbom.Add(root)
+	// the build meta of foo is not in the database
+	ctx.walkImports(sbom, root, []string{"/sbom-cold-foo@1.0.0/es2022/sbom-cold-foo.mjs"}, set.New[string]())
+
+	foo := sbom.Get(common.NpmPurl("sbom-cold-foo", "1.0.0"))
+	bar := sbom.Get(common.NpmPurl("sbom-cold-bar", "2.1.0"))
+	if foo == nil || bar == nil {
+		t.Fatalf("unexpected components %v", sbom.Components)
+	}
+	if len(foo.DependsOn) != 1 || foo.DependsOn[0] != bar.Purl || bar.License != "ISC" {
+		t.Fatalf("unexpected dependencies %v", foo.DependsOn)
+	}
+}
+
+// newBundleTestContext returns the build context of "app@1.0.0" in bundle mode, the package.json files are written
+// to the node_modules of the app in a temporary work directory. The offline mode is enabled so that the
+// registry is never accessed.
+func newBundleTestContext(t *testing.T, pkgJsons map[string]string) *BuildContext {
+	t.Helper()
+	workDir, offline := config.WorkDir, config.Offline
+	t.Cleanup(func() { config.WorkDir, config.Offline = workDir, offline })
+	config.WorkDir = t.TempDir()
+	config.Offline = true
+
+	npmrc := &NpmRC{}
+	wd := path.Join(npmrc.StoreDir(), "app@1.0.0")
+	for name, pkgJson := range pkgJsons {
+		os.MkdirAll(path.Join(wd, "node_modules", name), 0755)
+		os.WriteFile(path.Join(wd, "node_modules", name, "package.json"), []byte(pkgJson), 0644)
+	}
+	return &BuildContext{npmrc: npmrc, logger: &log.Logger{}, esm: EsmPath{PkgName: "app", PkgVersion: "1.0.0"}, bundleMode: BundleDeps}
+}
@@ -11,6 +11,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/esm-dev/esm.sh/server/common"
 	"github.com/esm-dev/esm.sh/server/npm_replacements"
 	"github.com/esm-dev/esm.sh/server/storage"
 	"github.com/ije/gox/log"
@@ -37,6 +38,15 @@ func Serve() {
 		}
 	}
 
+	// refuse to start with an invalid signing key, the manifests would be served unsigned otherwise
+	if config.SigningKeyRaw != "" {
+		config.SigningKey, err = common.ParseSigningKey(config.SigningKeyRaw)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	}
+
 	if DEBUG {
 		config.LogLevel = "debug"
 	} else {
@@ -74,8 +84,27 @@ func Serve() {
 	// setup server
 	Setup(logger)
 
-	// pre-compile uno generator in background
-	go generateUnoCSS(&NpmRC{NpmRegistry: NpmRegistry{Registry: "https://registry.npmjs.org/"}}, "", "")
+	if config.Offline {
+		logger.Info("offline mode enabled, outbound network access is disabled")
+	} else {
+		// check the health of npm registry mirrors in background
+		DefaultNpmRC()
+		go startRegistryHealthCheck(logger)
+
+		// pre-compile uno generator in background
+		go generateUnoCSS(&NpmRC{NpmRegistry: NpmRegistry{Registry: "https://registry.npmjs.org/"}}, "", "")
+	}
+
+	// load the security advisory database
+	if config.Advisories.Path != "" {
+		n, err := advisoryDB.Load(config.Advisories.Path)
+		if err != nil {
+			logger.Errorf("failed to load advisories: %v", err)
+		} else {
+			logger.Infof("%d advisories loaded from %s", n, config.Advisories.Path)
+		}
+		go startAdvisoryDBReload(logger)
+	}
 
 	// add middlewares
 	rex.Use(